@@ -0,0 +1,55 @@
+// BIOAFF/backend/cmd/api/admin.go
+package main
+
+import (
+	"net/http"
+
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// showLogLevelHandler() - returns the logger's current minimum level
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"log_level": app.logger.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler() - changes the logger's minimum level without restarting the server
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	//holds the new level sent by the client
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	level, err := jsonlog.ParseLevel(input.Level)
+
+	v := validator.New()
+	v.Check(input.Level != "", "level", "must be provided")
+	v.Check(err == nil, "level", "must be one of debug, info, warn, error, fatal or off")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous := app.logger.Level()
+	app.logger.SetLevel(level)
+
+	//warn so the change is recorded under every level apart from error and above
+	app.contextGetLogger(r).PrintWarn("log level changed", map[string]interface{}{
+		"from": previous.String(),
+		"to":   level.String(),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"log_level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,57 @@
+// BIOAFF/backend/cmd/api/admin_test.go
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+func TestLogLevelRequiresAuthentication(t *testing.T) {
+	app := newTestApplication(t, nil, testConfig())
+	ts := newTestServer(t, app)
+
+	res := ts.do(t, http.MethodGet, "/v1/admin/log-level", "", nil)
+	if res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d, want 401", res.status)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	reader := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "reader@example.com", "logs:read"))
+	writer := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "writer@example.com", "logs:read", "logs:write"))
+	applicant := authenticationToken(t, app, insertUser(t, app, data.UserKindPublic, "applicant@example.com"))
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		body       interface{}
+		wantStatus int
+	}{
+		{"show", http.MethodGet, reader, nil, http.StatusOK},
+		{"applicant", http.MethodGet, applicant, nil, http.StatusForbidden},
+		{"update without permission", http.MethodPut, reader, map[string]string{"level": "debug"}, http.StatusForbidden},
+		{"update", http.MethodPut, writer, map[string]string{"level": "warn"}, http.StatusOK},
+		{"unknown level", http.MethodPut, writer, map[string]string{"level": "loud"}, http.StatusUnprocessableEntity},
+		{"bad token", http.MethodGet, "AAAAAAAAAAAAAAAAAAAAAAAAAA", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, tt.method, "/v1/admin/log-level", tt.token, tt.body)
+			if res.status != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+		})
+	}
+
+	if app.logger.Level() != jsonlog.LevelWarn {
+		t.Errorf("got level %s, want warn", app.logger.Level())
+	}
+}
@@ -0,0 +1,272 @@
+// BIOAFF/backend/cmd/api/apikeys.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// apiKeyScheme - the Authorization scheme API keys are sent with, e.g. "Authorization: ApiKey bioaff_..."
+const apiKeyScheme = "ApiKey"
+
+// maxAPIKeyGracePeriod - the longest the secret from before a rotation may keep working
+const maxAPIKeyGracePeriod = 7 * 24 * time.Hour
+
+// apiKeyActivity - the latest use of each API key since the last save, saved along with the sessions' activity
+type apiKeyActivity struct {
+	mu      sync.Mutex
+	pending map[int64]data.APIKeyActivity //keyed by the key id
+}
+
+// newAPIKeyActivity() - an empty batch of API key activity
+func newAPIKeyActivity() *apiKeyActivity {
+	return &apiKeyActivity{pending: make(map[int64]data.APIKeyActivity)}
+}
+
+// touch() - records that the key was used just now from ip
+func (a *apiKeyActivity) touch(id int64, ip string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pending[id] = data.APIKeyActivity{ID: id, UsedAt: time.Now(), IP: ip}
+}
+
+// lastUsed() - the key's use waiting to be saved, if there is one
+func (a *apiKeyActivity) lastUsed(id int64) (data.APIKeyActivity, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	use, ok := a.pending[id]
+	return use, ok
+}
+
+// take() - empties the batch, returning what was in it
+func (a *apiKeyActivity) take() []data.APIKeyActivity {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	batch := make([]data.APIKeyActivity, 0, len(a.pending))
+	for _, use := range a.pending {
+		batch = append(batch, use)
+	}
+	a.pending = make(map[int64]data.APIKeyActivity)
+	return batch
+}
+
+// putBack() - returns a batch which couldn't be saved, keeping any newer use recorded in the meantime
+func (a *apiKeyActivity) putBack(batch []data.APIKeyActivity) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, use := range batch {
+		if newer, ok := a.pending[use.ID]; ok && newer.UsedAt.After(use.UsedAt) {
+			continue
+		}
+		a.pending[use.ID] = use
+	}
+}
+
+// saveAPIKeyActivity() - saves the API key activity recorded since the last save
+func (app *application) saveAPIKeyActivity() error {
+	batch := app.apiKeys.take()
+	err := app.models.APIKeys.Touch(batch)
+	if err != nil {
+		app.apiKeys.putBack(batch)
+		return err
+	}
+	return nil
+}
+
+// withLastUse() - fills in the key's latest unsaved use
+func (app *application) withLastUse(key *data.APIKey) {
+	if use, ok := app.apiKeys.lastUsed(key.ID); ok && (key.LastUsedAt == nil || use.UsedAt.After(*key.LastUsedAt)) {
+		usedAt := use.UsedAt
+		key.LastUsedAt, key.LastUsedIP = &usedAt, use.IP
+	}
+}
+
+// readAPIKeyParam() - the key in the :id parameter, ErrRecordNotFound when there is no such key
+func (app *application) readAPIKeyParam(r *http.Request) (*data.APIKey, error) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		return nil, data.ErrRecordNotFound
+	}
+	return app.models.APIKeys.Get(id)
+}
+
+// apiKeyEvent() - the audit event for a change an admin made to a key
+func (app *application) apiKeyEvent(r *http.Request, event string) *data.AuditEvent {
+	return &data.AuditEvent{
+		Event:   event,
+		ActorID: app.contextGetUser(r).ID,
+		IP:      app.contextGetClientIP(r).String(),
+	}
+}
+
+// listAPIKeysHandler() - every API key, revoked ones included, never with their secrets
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, key := range keys {
+		app.withLastUse(key)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createAPIKeyHandler() - issues a key for a partner agency's system, the response is the only time the
+// key is ever shown. Rate limits default to those of authenticated users.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name           string     `json:"name"`
+		Permissions    []string   `json:"permissions"`
+		RateLimitRPS   *float64   `json:"rate_limit_rps"`
+		RateLimitBurst *int       `json:"rate_limit_burst"`
+		ExpiresAt      *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		Name:           input.Name,
+		Permissions:    input.Permissions,
+		RateLimitRPS:   app.config.limiter.userRPS,
+		RateLimitBurst: app.config.limiter.userBurst,
+		CreatedBy:      app.contextGetUser(r).ID,
+		ExpiresAt:      input.ExpiresAt,
+	}
+	if input.RateLimitRPS != nil {
+		key.RateLimitRPS = *input.RateLimitRPS
+	}
+	if input.RateLimitBurst != nil {
+		key.RateLimitBurst = *input.RateLimitBurst
+	}
+
+	v := validator.New()
+	if data.ValidateAPIKey(v, key, app.config.apiKeys.permissions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	event := app.apiKeyEvent(r, data.AuditAPIKeyCreated)
+	event.Details = map[string]interface{}{"permissions": key.Permissions}
+	err = app.models.APIKeys.Insert(key, event)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.contextGetLogger(r).PrintInfo("api key created", map[string]interface{}{"api_key_id": key.ID, "api_key_prefix": key.Prefix})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/api-keys/%d", key.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// rotateAPIKeyHandler() - gives a key a new secret, shown only in the response, the old one keeps working
+// for grace_period_hours so the agency can switch over without an outage
+func (app *application) rotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := app.readAPIKeyParam(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		GracePeriodHours int `json:"grace_period_hours"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	grace := time.Duration(input.GracePeriodHours) * time.Hour
+	v := validator.New()
+	v.Check(grace >= 0, "grace_period_hours", "must not be negative")
+	v.Check(grace <= maxAPIKeyGracePeriod, "grace_period_hours", fmt.Sprintf("must not be more than %d", int(maxAPIKeyGracePeriod.Hours())))
+	v.Check(key.RevokedAt == nil, "api_key", "has been revoked")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	event := app.apiKeyEvent(r, data.AuditAPIKeyRotated)
+	event.Details = map[string]interface{}{"grace_period_hours": input.GracePeriodHours}
+	err = app.models.APIKeys.Rotate(key, grace, event)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.contextGetLogger(r).PrintInfo("api key rotated", map[string]interface{}{"api_key_id": key.ID, "api_key_prefix": key.Prefix})
+	app.withLastUse(key)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAPIKeyHandler() - revokes a key, along with the secret from before its last rotation
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := app.readAPIKeyParam(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.APIKeys.Revoke(key, app.apiKeyEvent(r, data.AuditAPIKeyRevoked))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.errorResponse(w, r, http.StatusConflict, codeAPIKeyRevoked, "the api key has already been revoked", nil)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.contextGetLogger(r).PrintInfo("api key revoked", map[string]interface{}{"api_key_id": key.ID, "api_key_prefix": key.Prefix})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "the api key was revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,206 @@
+// BIOAFF/backend/cmd/api/apikeys_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+// apiKeyRequest() - a request made with the key, as authenticate leaves it
+func apiKeyRequest(app *testApp, key *data.APIKey) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = app.contextSetUser(r, data.AnonymousUser)
+	if key != nil {
+		r = app.contextSetAPIKey(r, key)
+	}
+	return r
+}
+
+func TestAPIKeyPermissions(t *testing.T) {
+	app := newTestApplication(t, nil, testConfig())
+	key := &data.APIKey{ID: 1, Permissions: data.Permissions{"forms:read"}, RateLimitRPS: 1, RateLimitBurst: 2}
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	tests := []struct {
+		name string
+		key  *data.APIKey
+		code string
+		want int
+	}{
+		{"held by the key", key, "forms:read", http.StatusOK},
+		{"not held by the key", key, "forms:verify", http.StatusForbidden},
+		{"no key", nil, "forms:read", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.requirePermission(tt.code, ok).ServeHTTP(rr, apiKeyRequest(app, tt.key))
+		if rr.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, rr.Code, tt.want)
+		}
+	}
+
+	//narrowing the allow-list takes the permission from keys which already hold it
+	app.config.apiKeys.permissions = []string{"emails:read"}
+	rr := httptest.NewRecorder()
+	app.requirePermission("forms:read", ok).ServeHTTP(rr, apiKeyRequest(app, key))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("got status %d for a permission taken off the allow-list, want 403", rr.Code)
+	}
+	app.config.apiKeys.permissions = []string{"forms:read"}
+
+	//the key's own limit applies, not the one for users
+	app.config.limiter.enabled = true
+	handler := app.rateLimit(http.HandlerFunc(ok))
+	codes := make([]int, 3)
+	for i := range codes {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, apiKeyRequest(app, key))
+		codes[i] = rr.Code
+		if limit := rr.Header().Get("RateLimit-Limit"); limit != "2" {
+			t.Errorf("got RateLimit-Limit %q, want the key's burst of 2", limit)
+		}
+	}
+	if codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("got statuses %v, want the third request limited", codes)
+	}
+
+	//anything that isn't one of our keys is refused before the database is asked
+	ts := newTestServer(t, app)
+	for _, header := range []string{"ApiKey nonsense", "ApiKey bioaff_abcdefgh_short", "Apikey " + strings.Repeat("a", 26)} {
+		if res := ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil, "Authorization", header); res.status != http.StatusUnauthorized {
+			t.Errorf("%q: got status %d, want 401", header, res.status)
+		}
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	admin := insertUser(t, app, data.UserKindAdmin, "keys@example.com", "api_keys:read", "api_keys:write")
+	session := authenticationToken(t, app, admin)
+
+	//keys may only hold the permissions the config allows
+	res := ts.do(t, http.MethodPost, "/v1/admin/api-keys", session, map[string]interface{}{"name": "Passport office", "permissions": []string{"forms:verify"}})
+	if res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d granting forms:verify, want 422", res.status)
+	}
+
+	res = ts.do(t, http.MethodPost, "/v1/admin/api-keys", session, map[string]interface{}{"name": "Passport office", "permissions": []string{"forms:read"}, "rate_limit_rps": 10, "rate_limit_burst": 20})
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d creating a key, want 201: %s", res.status, res.body)
+	}
+	var created struct {
+		APIKey data.APIKey `json:"api_key"`
+	}
+	res.decode(t, &created)
+	key := created.APIKey
+	if !strings.HasPrefix(key.Plaintext, "bioaff_"+key.Prefix+"_") || key.RateLimitBurst != 20 || key.CreatedBy != admin.ID {
+		t.Fatalf("got %+v", key)
+	}
+	if n := countAuditEvents(t, app, data.AuditAPIKeyCreated); n != 1 {
+		t.Errorf("got %d created events, want 1", n)
+	}
+
+	//the key is no account, it only reaches what its permissions allow and sees nothing of the applicant
+	form := insertForm(t, app, insertUser(t, app, data.UserKindPublic, "applicant@example.com"), data.FormStatusPending)
+	formPath := "/v1/forms/" + strconv.FormatInt(form.ID, 10) + "/status"
+	res = ts.do(t, http.MethodGet, formPath, "", nil, "Authorization", "ApiKey "+key.Plaintext)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d reading a form's status with the key, want 200: %s", res.status, res.body)
+	}
+	var status struct {
+		Form map[string]interface{} `json:"form"`
+	}
+	res.decode(t, &status)
+	want := map[string]interface{}{"id": float64(form.ID), "form_number": float64(form.FormNumber), "status": data.FormStatusPending, "archived": false}
+	if !reflect.DeepEqual(status.Form, want) {
+		t.Errorf("got form %v, want only %v", status.Form, want)
+	}
+	if res := ts.do(t, http.MethodGet, "/v1/admin/api-keys", "", nil, "Authorization", "ApiKey "+key.Plaintext); res.status != http.StatusForbidden {
+		t.Errorf("got status %d listing keys with a key, want 403", res.status)
+	}
+	if res := ts.do(t, http.MethodGet, "/v1/users/me/sessions", "", nil, "Authorization", "ApiKey "+key.Plaintext); res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d for a user route with a key, want 401", res.status)
+	}
+
+	//the last use shows up straight away and is saved with the sessions
+	res = ts.do(t, http.MethodGet, "/v1/admin/api-keys", session, nil)
+	var list struct {
+		APIKeys []data.APIKey `json:"api_keys"`
+	}
+	res.decode(t, &list)
+	if len(list.APIKeys) != 1 || list.APIKeys[0].LastUsedAt == nil || list.APIKeys[0].Plaintext != "" {
+		t.Fatalf("got %+v, want the key's last use and never its secret", list.APIKeys)
+	}
+	if err := app.saveAPIKeyActivity(); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := app.models.APIKeys.Get(key.ID)
+	if err != nil || stored.LastUsedAt == nil {
+		t.Fatalf("got %+v %v, want last_used_at saved", stored, err)
+	}
+
+	//a batch saved late leaves the newer use's ip alone
+	stale := []data.APIKeyActivity{{ID: key.ID, UsedAt: stored.LastUsedAt.Add(-time.Hour), IP: "10.9.9.9"}}
+	if err := app.models.APIKeys.Touch(stale); err != nil {
+		t.Fatal(err)
+	}
+	afterStale, err := app.models.APIKeys.Get(key.ID)
+	if err != nil || !afterStale.LastUsedAt.Equal(*stored.LastUsedAt) || afterStale.LastUsedIP != stored.LastUsedIP {
+		t.Errorf("got %+v (%v) after a stale batch, want the last use left at %+v", afterStale, err, stored)
+	}
+
+	//a rotation with a grace period keeps the old secret working for a while
+	path := "/v1/admin/api-keys/" + strconv.FormatInt(key.ID, 10)
+	res = ts.do(t, http.MethodPost, path+"/rotate", session, map[string]int{"grace_period_hours": 1})
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d rotating, want 200: %s", res.status, res.body)
+	}
+	var rotated struct {
+		APIKey data.APIKey `json:"api_key"`
+	}
+	res.decode(t, &rotated)
+	if rotated.APIKey.Plaintext == key.Plaintext || rotated.APIKey.Prefix != key.Prefix || rotated.APIKey.PreviousExpiry == nil {
+		t.Fatalf("got %+v after rotating", rotated.APIKey)
+	}
+	for _, plaintext := range []string{key.Plaintext, rotated.APIKey.Plaintext} {
+		if _, err := app.models.APIKeys.GetForKey(plaintext); err != nil {
+			t.Errorf("got %v during the grace period", err)
+		}
+	}
+	if _, err := app.models.APIKeys.GetForKey(key.Plaintext[:len(key.Plaintext)-1] + "a"); err == nil {
+		t.Error("accepted a key with the wrong secret")
+	}
+
+	//without one the old secret stops working straight away
+	res = ts.do(t, http.MethodPost, path+"/rotate", session, map[string]int{})
+	res.decode(t, &rotated)
+	if _, err := app.models.APIKeys.GetForKey(key.Plaintext); err == nil {
+		t.Error("the secret from two rotations ago still works")
+	}
+
+	res = ts.do(t, http.MethodDelete, path, session, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d revoking, want 200", res.status)
+	}
+	if res := ts.do(t, http.MethodGet, formPath, "", nil, "Authorization", "ApiKey "+rotated.APIKey.Plaintext); res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d with a revoked key, want 401", res.status)
+	}
+	if res := ts.do(t, http.MethodDelete, path, session, nil); res.status != http.StatusConflict {
+		t.Errorf("got status %d revoking twice, want 409", res.status)
+	}
+	for _, event := range []string{data.AuditAPIKeyRotated, data.AuditAPIKeyRevoked} {
+		if n := countAuditEvents(t, app, event); n == 0 {
+			t.Errorf("no %s events", event)
+		}
+	}
+}
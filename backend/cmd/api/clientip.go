@@ -0,0 +1,127 @@
+// BIOAFF/backend/cmd/api/clientip.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// the forwarding headers our proxies may be set up to add, only the configured one is read since most proxies
+// append to their own header and pass any other a client sent straight through
+const (
+	proxyHeaderXForwardedFor = "x-forwarded-for"
+	proxyHeaderForwarded     = "forwarded" //RFC 7239
+)
+
+// parseTrustedProxies() - reads a space separated list of CIDR ranges, bare addresses are treated as single hosts
+func parseTrustedProxies(val string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Fields(val) {
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", field)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", field)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// trustedProxy() - checks if the address belongs to one of our own proxies
+func (app *application) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range app.config.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveClientIP() - works out the address of the client that made the request
+// the configured forwarding header is only believed when it was added by a trusted proxy, the hops are walked
+// from the closest proxy backwards and the first untrusted address is the client
+func (app *application) resolveClientIP(r *http.Request) (netip.Addr, error) {
+	remote, err := parseHop(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid remote address %q", r.RemoteAddr)
+	}
+
+	client := remote
+	if !app.trustedProxy(client) {
+		return client, nil
+	}
+
+	var hops []string
+	switch app.config.trustedProxyHeader {
+	case proxyHeaderForwarded:
+		hops = forwardedHops(r.Header)
+	default:
+		hops = xForwardedForHops(r.Header)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseHop(hops[i])
+		if err != nil {
+			//a garbled or obfuscated hop can't be trusted any further, stop at the last good one
+			break
+		}
+		client = addr
+		if !app.trustedProxy(client) {
+			break
+		}
+	}
+	return client, nil
+}
+
+// xForwardedForHops() - lists the addresses of every X-Forwarded-For header, client first
+func xForwardedForHops(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedHops() - lists the "for" addresses of every RFC 7239 Forwarded header, client first
+func forwardedHops(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			//an element without a "for" pair still counts as a hop we know nothing about
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHop() - parses an address with or without a port, e.g. 10.0.0.1, 10.0.0.1:80, [::1]:80 or ::1
+func parseHop(hop string) (netip.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), nil
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
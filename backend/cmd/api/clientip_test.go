@@ -0,0 +1,61 @@
+// BIOAFF/backend/cmd/api/clientip_test.go
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8 2001:db8:ffff::/48")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		header  string //the trusted proxy header configured
+		remote  string
+		headers []string //name/value pairs
+		want    string
+	}{
+		{"direct client", proxyHeaderXForwardedFor, "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"headers from an untrusted client", proxyHeaderXForwardedFor, "203.0.113.7:5000", []string{"X-Forwarded-For", "198.51.100.1"}, "203.0.113.7"},
+		{"one proxy", proxyHeaderXForwardedFor, "10.0.0.1:5000", []string{"X-Forwarded-For", "203.0.113.7"}, "203.0.113.7"},
+		{"chained proxies", proxyHeaderXForwardedFor, "10.0.0.1:5000", []string{"X-Forwarded-For", "203.0.113.7, 10.0.0.3, 10.0.0.2"}, "203.0.113.7"},
+		{"split over headers", proxyHeaderXForwardedFor, "10.0.0.1:5000", []string{"X-Forwarded-For", "203.0.113.7", "X-Forwarded-For", "10.0.0.2"}, "203.0.113.7"},
+		{"spoofed x-forwarded-for hop", proxyHeaderXForwardedFor, "10.0.0.1:5000", []string{"X-Forwarded-For", "1.2.3.4, 203.0.113.7"}, "203.0.113.7"},
+		{"spoofed forwarded header", proxyHeaderXForwardedFor, "10.0.0.1:5000", []string{"X-Forwarded-For", "203.0.113.7", "Forwarded", "for=1.2.3.4"}, "203.0.113.7"},
+		{"spoofed x-forwarded-for in forwarded mode", proxyHeaderForwarded, "10.0.0.1:5000", []string{"Forwarded", "for=203.0.113.7", "X-Forwarded-For", "1.2.3.4"}, "203.0.113.7"},
+		{"spoofed forwarded hop", proxyHeaderForwarded, "10.0.0.1:5000", []string{"Forwarded", "for=1.2.3.4, for=203.0.113.7;proto=https"}, "203.0.113.7"},
+		{"chained forwarded", proxyHeaderForwarded, "10.0.0.1:5000", []string{"Forwarded", `for=203.0.113.7, for="10.0.0.2:8080";by=10.0.0.1`}, "203.0.113.7"},
+		{"obfuscated hop", proxyHeaderForwarded, "10.0.0.1:5000", []string{"Forwarded", "for=_hidden, for=10.0.0.2"}, "10.0.0.2"},
+		{"unknown hop", proxyHeaderForwarded, "10.0.0.1:5000", []string{"Forwarded", "for=unknown"}, "10.0.0.1"},
+		{"garbled hop", proxyHeaderXForwardedFor, "10.0.0.1:5000", []string{"X-Forwarded-For", "203.0.113.7, not-an-ip"}, "10.0.0.1"},
+		{"ipv6 forwarded", proxyHeaderForwarded, "[2001:db8:ffff::1]:443", []string{"Forwarded", `for="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"ipv6 x-forwarded-for", proxyHeaderXForwardedFor, "[2001:db8:ffff::1]:443", []string{"X-Forwarded-For", "2001:db8:cafe::17, 2001:db8:ffff::2"}, "2001:db8:cafe::17"},
+		{"ipv4 mapped ipv6", proxyHeaderXForwardedFor, "[::ffff:10.0.0.1]:443", []string{"X-Forwarded-For", "::ffff:203.0.113.7"}, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.trustedProxies = proxies
+			cfg.trustedProxyHeader = tt.header
+			app := newTestApplication(t, nil, cfg)
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for i := 0; i+1 < len(tt.headers); i += 2 {
+				r.Header.Add(tt.headers[i], tt.headers[i+1])
+			}
+
+			got, err := app.resolveClientIP(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
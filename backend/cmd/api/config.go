@@ -0,0 +1,400 @@
+// BIOAFF/backend/cmd/api/config.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix - environment variables are named after the flags, e.g. -db-dsn is BIOAFF_DB_DSN
+const envPrefix = "BIOAFF_"
+
+// repeatableFlags - flags which add a value each time they are set, a list in the config file sets them once per
+// item and their environment variable holds one value per line
+// a higher source replaces the values of a lower one instead of adding to them
+var repeatableFlags = map[string]bool{
+	"jwt-key":                true,
+	"limiter-route":          true,
+	"log-redact-pattern":     true,
+	"oidc-group-permissions": true,
+}
+
+// loadConfig() - parses the command line and fills in the flags that weren't given on it,
+// from the environment or else the config file, so the precedence is flags > environment > file
+func loadConfig(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	//remember the flags given on the command line, nothing may override them
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	//the values of each flag from the highest source which has it, along with where they came from
+	values := make(map[string][]string)
+	sources := make(map[string]string)
+
+	//the file first so the environment can replace it
+	path := os.Getenv(envPrefix + "CONFIG")
+	if f := fs.Lookup("config"); f != nil && explicit["config"] {
+		path = f.Value.String()
+	}
+	if path != "" {
+		fileValues, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+		for name, vals := range fileValues {
+			if fs.Lookup(name) == nil {
+				return fmt.Errorf("config file %s: unknown setting %q", path, name)
+			}
+			values[name] = vals
+			sources[name] = fmt.Sprintf("config file %s: invalid value for %q", path, name)
+		}
+	}
+
+	//then the environment
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		key := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			return
+		}
+		values[f.Name] = []string{value}
+		if repeatableFlags[f.Name] {
+			values[f.Name] = nil
+			for _, line := range strings.Split(value, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					values[f.Name] = append(values[f.Name], line)
+				}
+			}
+		}
+		sources[f.Name] = "environment variable " + key
+	})
+
+	//apply in a stable order, repeatable flags keep the order of their values
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if explicit[name] {
+			continue
+		}
+		for _, value := range values[name] {
+			err := fs.Set(name, value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", sources[name], err)
+			}
+		}
+	}
+	return nil
+}
+
+// readConfigFile() - reads a YAML config file into flag names and their values
+// nested sections are joined with a dash, so db: {dsn: ...} sets -db-dsn
+func readConfigFile(path string) (map[string][]string, error) {
+	ext := filepath.Ext(path)
+	if ext != ".yaml" && ext != ".yml" {
+		return nil, fmt.Errorf("config file %s: only .yaml and .yml files are supported", path)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	err = yaml.Unmarshal(content, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := make(map[string][]string)
+	err = flattenConfig("", raw, values)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// flattenConfig() - turns nested sections into flag names, lists become space separated values
+// except for the repeatable flags where every item is set on its own
+func flattenConfig(prefix string, section map[string]interface{}, values map[string][]string) error {
+	for key, value := range section {
+		name := key
+		if prefix != "" {
+			name = prefix + "-" + key
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			err := flattenConfig(name, v, values)
+			if err != nil {
+				return err
+			}
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			if repeatableFlags[name] {
+				values[name] = items
+			} else {
+				values[name] = []string{strings.Join(items, " ")}
+			}
+		case nil:
+			return fmt.Errorf("setting %q has no value", name)
+		default:
+			values[name] = []string{fmt.Sprint(v)}
+		}
+	}
+	return nil
+}
+
+// personOnlyPermissions - permissions whose handlers record the staff member acting, an API key has no account to
+// record, and keys managing keys would let a leaked key outlive its revocation
+var personOnlyPermissions = []string{"forms:verify", "users:unlock", "sessions:write"}
+
+// personOnlyPermission() - reports whether the permission can't be granted to API keys
+func personOnlyPermission(code string) bool {
+	return strings.HasPrefix(code, "api_keys:") || validator.In(code, personOnlyPermissions...)
+}
+
+// validateConfig() - checks the merged configuration before anything is started with it
+func validateConfig(v *validator.Validator, cfg config) {
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.In(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	for key, level := range map[string]string{"log-level": cfg.log.level, "log-stdout-level": cfg.log.stdoutLevel, "log-file-level": cfg.log.fileLevel} {
+		_, err := jsonlog.ParseLevel(level)
+		v.Check(err == nil, key, "must be debug, info, warn, error, fatal or off")
+	}
+	v.Check(cfg.log.fileMaxSize > 0, "log-file-max-size", "must be greater than zero")
+	v.Check(cfg.log.fileMaxBackups >= 0, "log-file-max-backups", "must not be negative")
+	for _, pattern := range cfg.log.redactPatterns {
+		_, err := regexp.Compile(pattern)
+		v.Check(err == nil, "log-redact-pattern", "must be a valid regular expression")
+	}
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns > 0, "db-max-open-conns", "must be greater than zero")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	v.Check(cfg.db.schemaVersion >= 0, "db-schema-version", "must not be negative")
+
+	v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+	v.Check(cfg.limiter.burst > 0, "limiter-burst", "must be greater than zero")
+	v.Check(cfg.limiter.userRPS > 0, "limiter-user-rps", "must be greater than zero")
+	v.Check(cfg.limiter.userBurst > 0, "limiter-user-burst", "must be greater than zero")
+	v.Check(validator.In(cfg.limiter.store, "memory", "postgres"), "limiter-store", "must be memory or postgres")
+
+	v.Check(cfg.smtp.host != "", "smtp-host", "must be provided")
+	v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+
+	v.Check(cfg.jobs.workers > 0, "jobs-workers", "must be greater than zero")
+	v.Check(cfg.jobs.queueSize > 0, "jobs-queue-size", "must be greater than zero")
+	v.Check(cfg.outbox.workers > 0, "outbox-workers", "must be greater than zero")
+	v.Check(cfg.outbox.pollInterval > 0, "outbox-poll-interval", "must be greater than zero")
+	v.Check(cfg.outbox.maxAttempts > 0, "outbox-max-attempts", "must be greater than zero")
+	v.Check(cfg.outbox.backoff > 0, "outbox-backoff", "must be greater than zero")
+	v.Check(cfg.outbox.maxBackoff >= cfg.outbox.backoff, "outbox-max-backoff", "must not be less than outbox-backoff")
+
+	v.Check(cfg.login.maxFailures > 0, "login-max-failures", "must be greater than zero")
+	v.Check(cfg.login.ipMaxFailures >= cfg.login.maxFailures, "login-ip-max-failures", "must not be less than login-max-failures")
+	v.Check(cfg.login.delayAfter > 0, "login-delay-after", "must be greater than zero")
+	v.Check(cfg.login.delay >= 0, "login-delay", "must not be negative")
+	v.Check(cfg.login.maxDelay >= cfg.login.delay, "login-max-delay", "must not be less than login-delay")
+	v.Check(cfg.login.lockout > 0, "login-lockout", "must be greater than zero")
+	v.Check(cfg.login.window > 0, "login-window", "must be greater than zero")
+
+	v.Check(cfg.sessions.flushInterval > 0, "sessions-flush-interval", "must be greater than zero")
+
+	v.Check(validator.In(cfg.auth.mode, authModeOpaque, authModeJWT), "auth-mode", "must be opaque or jwt")
+	if cfg.auth.mode == authModeJWT {
+		v.Check(cfg.jwt.issuer != "", "jwt-issuer", "must be provided")
+		v.Check(cfg.jwt.accessTTL > 0, "jwt-access-ttl", "must be greater than zero")
+		v.Check(cfg.jwt.refreshTTL > cfg.jwt.accessTTL, "jwt-refresh-ttl", "must be greater than jwt-access-ttl")
+		v.Check(len(cfg.jwt.keys) > 0, "jwt-key", "must be provided in the jwt auth mode")
+		ids := make([]string, 0, len(cfg.jwt.keys))
+		for _, k := range cfg.jwt.keys {
+			v.Check(!validator.In(k.id, ids...), "jwt-key", "must not repeat a key id")
+			ids = append(ids, k.id)
+		}
+		v.Check(validator.In(cfg.jwt.signingKey, ids...), "jwt-signing-key", "must be the id of a jwt-key")
+	}
+
+	validateSSOConfig(v, cfg)
+
+	for _, code := range cfg.apiKeys.permissions {
+		v.Check(!personOnlyPermission(code), "api-key-permissions", "must not contain permissions which need a person: "+code)
+	}
+
+	v.Check(cfg.twoFactor.issuer != "" && !strings.Contains(cfg.twoFactor.issuer, ":"), "two-factor-issuer", "must be provided and must not contain a colon")
+
+	v.Check(cfg.healthcheck.timeout > 0, "healthcheck-timeout", "must be greater than zero")
+	v.Check(cfg.healthcheck.drainDelay >= 0, "healthcheck-drain-delay", "must not be negative")
+
+	for _, origin := range cfg.cors.trustedOrigins {
+		v.Check(origin == "*" || validator.ValidWebsite(strings.Replace(origin, "*.", "", 1)), "cors-trusted-origin", "must be *, an origin or a wildcard origin")
+	}
+	//echoing every origin with credentials allowed would let any site act for our users
+	v.Check(!(cfg.cors.allowCredentials && validator.In("*", cfg.cors.trustedOrigins...)), "cors-allow-credentials", "cannot be used with the * trusted origin")
+	v.Check(cfg.cors.maxAge >= 0, "cors-max-age", "must not be negative")
+
+	v.Check((cfg.tls.certFile == "") == (cfg.tls.keyFile == ""), "tls-key", "must be provided together with tls-cert")
+	v.Check(cfg.tls.redirectAddr == "" || cfg.tls.enabled(), "tls-redirect-addr", "requires tls-cert and tls-key")
+	v.Check(cfg.tls.hstsMaxAge >= 0, "tls-hsts-max-age", "must not be negative")
+
+	v.Check(validator.In(cfg.trustedProxyHeader, proxyHeaderXForwardedFor, proxyHeaderForwarded), "trusted-proxy-header", "must be x-forwarded-for or forwarded")
+}
+
+// redacted() - the effective configuration keyed by flag name, with the secrets hidden
+func (cfg config) redacted() map[string]interface{} {
+	routes := make([]string, 0, len(cfg.limiter.routes))
+	for _, rl := range cfg.limiter.routes {
+		routes = append(routes, strings.TrimSpace(fmt.Sprintf("%s %s=%g:%d", rl.method, rl.pattern, rl.limit.Rate, rl.limit.Burst)))
+	}
+	proxies := make([]string, 0, len(cfg.trustedProxies))
+	for _, prefix := range cfg.trustedProxies {
+		proxies = append(proxies, prefix.String())
+	}
+
+	jwtKeys := make([]string, 0, len(cfg.jwt.keys))
+	for _, k := range cfg.jwt.keys {
+		jwtKeys = append(jwtKeys, k.String())
+	}
+
+	groups := make([]string, 0, len(cfg.oidc.groupPermissions))
+	for _, g := range cfg.oidc.groupPermissions {
+		groups = append(groups, g.String())
+	}
+
+	smtpPassword := ""
+	if cfg.smtp.password != "" {
+		smtpPassword = jsonlog.Redacted
+	}
+	oidcClientSecret := ""
+	if cfg.oidc.clientSecret != "" {
+		oidcClientSecret = jsonlog.Redacted
+	}
+
+	return map[string]interface{}{
+		"port":                            cfg.port,
+		"env":                             cfg.env,
+		"log-level":                       cfg.log.level,
+		"log-stdout-level":                cfg.log.stdoutLevel,
+		"log-file":                        cfg.log.file,
+		"log-file-level":                  cfg.log.fileLevel,
+		"log-file-max-size":               cfg.log.fileMaxSize,
+		"log-file-max-age":                cfg.log.fileMaxAge.String(),
+		"log-file-max-backups":            cfg.log.fileMaxBackups,
+		"log-file-compress":               cfg.log.fileCompress,
+		"log-redact":                      cfg.log.redact,
+		"log-redact-keys":                 cfg.log.redactKeys,
+		"log-redact-pattern":              cfg.log.redactPatterns,
+		"db-dsn":                          redactDSN(cfg.db.dsn),
+		"db-max-open-conns":               cfg.db.maxOpenConns,
+		"db-max-idle-conns":               cfg.db.maxIdleConns,
+		"db-max-idle-time":                cfg.db.maxIdleTime,
+		"db-schema-version":               cfg.db.schemaVersion,
+		"migrate-on-start":                cfg.db.migrateOnStart,
+		"limiter-rps":                     cfg.limiter.rps,
+		"limiter-burst":                   cfg.limiter.burst,
+		"limiter-enabled":                 cfg.limiter.enabled,
+		"limiter-store":                   cfg.limiter.store,
+		"limiter-user-rps":                cfg.limiter.userRPS,
+		"limiter-user-burst":              cfg.limiter.userBurst,
+		"limiter-route":                   routes,
+		"smtp-host":                       cfg.smtp.host,
+		"smtp-port":                       cfg.smtp.port,
+		"smtp-username":                   cfg.smtp.username,
+		"smtp-password":                   smtpPassword,
+		"smtp-sender":                     cfg.smtp.sender,
+		"jobs-workers":                    cfg.jobs.workers,
+		"jobs-queue-size":                 cfg.jobs.queueSize,
+		"outbox-workers":                  cfg.outbox.workers,
+		"outbox-poll-interval":            cfg.outbox.pollInterval.String(),
+		"outbox-max-attempts":             cfg.outbox.maxAttempts,
+		"outbox-backoff":                  cfg.outbox.backoff.String(),
+		"outbox-max-backoff":              cfg.outbox.maxBackoff.String(),
+		"login-max-failures":              cfg.login.maxFailures,
+		"login-ip-max-failures":           cfg.login.ipMaxFailures,
+		"login-delay-after":               cfg.login.delayAfter,
+		"login-delay":                     cfg.login.delay.String(),
+		"login-max-delay":                 cfg.login.maxDelay.String(),
+		"login-lockout":                   cfg.login.lockout.String(),
+		"login-window":                    cfg.login.window.String(),
+		"sessions-flush-interval":         cfg.sessions.flushInterval.String(),
+		"auth-mode":                       cfg.auth.mode,
+		"jwt-issuer":                      cfg.jwt.issuer,
+		"jwt-access-ttl":                  cfg.jwt.accessTTL.String(),
+		"jwt-refresh-ttl":                 cfg.jwt.refreshTTL.String(),
+		"jwt-key":                         jwtKeys,
+		"jwt-signing-key":                 cfg.jwt.signingKey,
+		"oidc-issuer":                     cfg.oidc.issuer,
+		"oidc-client-id":                  cfg.oidc.clientID,
+		"oidc-client-secret":              oidcClientSecret,
+		"oidc-redirect-url":               cfg.oidc.redirectURL,
+		"oidc-scopes":                     cfg.oidc.scopes,
+		"oidc-groups-claim":               cfg.oidc.groupsClaim,
+		"oidc-group-permissions":          groups,
+		"oidc-mfa-values":                 cfg.oidc.mfaValues,
+		"api-key-permissions":             cfg.apiKeys.permissions,
+		"two-factor-issuer":               cfg.twoFactor.issuer,
+		"two-factor-required-permissions": cfg.twoFactor.requiredPermissions,
+		"healthcheck-timeout":             cfg.healthcheck.timeout.String(),
+		"healthcheck-smtp":                cfg.healthcheck.smtp,
+		"healthcheck-drain-delay":         cfg.healthcheck.drainDelay.String(),
+		"cors-trusted-origin":             cfg.cors.trustedOrigins,
+		"cors-allowed-methods":            cfg.cors.allowedMethods,
+		"cors-allowed-headers":            cfg.cors.allowedHeaders,
+		"cors-exposed-headers":            cfg.cors.exposedHeaders,
+		"cors-max-age":                    cfg.cors.maxAge.String(),
+		"cors-allow-credentials":          cfg.cors.allowCredentials,
+		"tls-cert":                        cfg.tls.certFile,
+		"tls-key":                         cfg.tls.keyFile,
+		"tls-redirect-addr":               cfg.tls.redirectAddr,
+		"tls-hsts-max-age":                cfg.tls.hstsMaxAge.String(),
+		"tls-hsts-include-subdomains":     cfg.tls.hstsSubdomains,
+		"trusted-proxies":                 proxies,
+		"trusted-proxy-header":            cfg.trustedProxyHeader,
+	}
+}
+
+// dsnPasswordRX - the password of a key/value DSN, e.g. "host=db password=secret"
+var dsnPasswordRX = regexp.MustCompile(`(?i)(password\s*=\s*)('[^']*'|\S+)`)
+
+// redactDSN() - hides the password of a URL or key/value PostgreSQL DSN
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			//brackets would be percent-encoded in a URL
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+		//the password may also be passed as a query parameter
+		if q := u.Query(); q.Has("password") {
+			q.Set("password", "REDACTED")
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	return dsnPasswordRX.ReplaceAllString(dsn, "${1}"+jsonlog.Redacted)
+}
@@ -0,0 +1,210 @@
+// BIOAFF/backend/cmd/api/config_test.go
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// testFlags - a flag set shaped like the api's, with a plain, a nested and a repeatable flag
+type testFlags struct {
+	fs     *flag.FlagSet
+	port   string
+	dbDSN  string
+	routes []string
+}
+
+// newTestFlags() - the flags loadConfig is tested against
+func newTestFlags() *testFlags {
+	tf := &testFlags{fs: flag.NewFlagSet("api", flag.ContinueOnError)}
+	tf.fs.SetOutput(new(strings.Builder))
+	tf.fs.String("config", "", "")
+	tf.fs.StringVar(&tf.port, "port", "4000", "")
+	tf.fs.StringVar(&tf.dbDSN, "db-dsn", "", "")
+	tf.fs.Func("limiter-route", "", func(val string) error {
+		tf.routes = append(tf.routes, val)
+		return nil
+	})
+	return tf
+}
+
+// writeConfigFile() - writes a config file for the test and returns its path
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := `
+port: 5000
+db:
+  dsn: postgres://file
+limiter:
+  route:
+    - POST /v1/tokens/authentication=0.2:5
+    - GET /v1/forms=1:2
+`
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		args       []string
+		wantPort   string
+		wantDSN    string
+		wantRoutes []string
+	}{
+		{
+			name:       "file",
+			wantPort:   "5000",
+			wantDSN:    "postgres://file",
+			wantRoutes: []string{"POST /v1/tokens/authentication=0.2:5", "GET /v1/forms=1:2"},
+		},
+		{
+			name:       "environment over file",
+			env:        map[string]string{"BIOAFF_PORT": "6000", "BIOAFF_LIMITER_ROUTE": "GET /v1/healthcheck=5:10"},
+			wantPort:   "6000",
+			wantDSN:    "postgres://file",
+			wantRoutes: []string{"GET /v1/healthcheck=5:10"},
+		},
+		{
+			name:       "several values in the environment",
+			env:        map[string]string{"BIOAFF_LIMITER_ROUTE": "GET /v1/healthcheck=5:10\n\nPOST /v1/tokens/sso=1:1\n"},
+			wantPort:   "5000",
+			wantDSN:    "postgres://file",
+			wantRoutes: []string{"GET /v1/healthcheck=5:10", "POST /v1/tokens/sso=1:1"},
+		},
+		{
+			name:       "an empty environment value removes the file's values",
+			env:        map[string]string{"BIOAFF_LIMITER_ROUTE": ""},
+			wantPort:   "5000",
+			wantDSN:    "postgres://file",
+			wantRoutes: nil,
+		},
+		{
+			name:       "flags over everything",
+			env:        map[string]string{"BIOAFF_PORT": "6000", "BIOAFF_DB_DSN": "postgres://env", "BIOAFF_LIMITER_ROUTE": "GET /v1/healthcheck=5:10"},
+			args:       []string{"-port", "7000", "-limiter-route", "GET /=1:1"},
+			wantPort:   "7000",
+			wantDSN:    "postgres://env",
+			wantRoutes: []string{"GET /=1:1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BIOAFF_CONFIG", writeConfigFile(t, file))
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			tf := newTestFlags()
+			err := loadConfig(tf.fs, tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tf.port != tt.wantPort || tf.dbDSN != tt.wantDSN {
+				t.Errorf("got port %s dsn %s, want %s %s", tf.port, tf.dbDSN, tt.wantPort, tt.wantDSN)
+			}
+			if !reflect.DeepEqual(tf.routes, tt.wantRoutes) {
+				t.Errorf("got routes %q, want %q", tf.routes, tt.wantRoutes)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileFlag(t *testing.T) {
+	t.Setenv("BIOAFF_CONFIG", writeConfigFile(t, "port: 5000\n"))
+
+	tf := newTestFlags()
+	err := loadConfig(tf.fs, []string{"-config", writeConfigFile(t, "port: 6000\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.port != "6000" {
+		t.Errorf("got port %s, want the one from the -config file", tf.port)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr string
+	}{
+		{"unknown setting", "colour: blue\n", `unknown setting "colour"`},
+		{"setting without a value", "db:\n  dsn:\n", `setting "db-dsn" has no value`},
+		{"not yaml", "port: [\n", "config file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BIOAFF_CONFIG", writeConfigFile(t, tt.file))
+
+			err := loadConfig(newTestFlags().fs, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	//the source of a bad value is named in the error
+	tf := newTestFlags()
+	tf.fs.Func("strict", "", func(val string) error {
+		if val != "ok" {
+			return os.ErrInvalid
+		}
+		return nil
+	})
+	t.Setenv("BIOAFF_CONFIG", writeConfigFile(t, "strict: bad\n"))
+	err := loadConfig(tf.fs, nil)
+	if err == nil || !strings.Contains(err.Error(), `invalid value for "strict"`) {
+		t.Errorf("got %v for a bad file value", err)
+	}
+	t.Setenv("BIOAFF_STRICT", "bad")
+	err = loadConfig(tf.fs, nil)
+	if err == nil || !strings.Contains(err.Error(), "environment variable BIOAFF_STRICT") {
+		t.Errorf("got %v for a bad environment value", err)
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	values, err := readConfigFile("config.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) == 0 {
+		t.Error("got no settings from the example config")
+	}
+}
+
+func TestAPIKeyPermissionsConfig(t *testing.T) {
+	tests := []struct {
+		permissions []string
+		valid       bool
+	}{
+		{[]string{"forms:read", "emails:read"}, true},
+		{[]string{"forms:read", "forms:verify"}, false},
+		{[]string{"users:unlock"}, false},
+		{[]string{"sessions:write"}, false},
+		{[]string{"api_keys:read"}, false},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.apiKeys.permissions = tt.permissions
+		v := validator.New()
+		validateConfig(v, cfg)
+		if _, invalid := v.Errors["api-key-permissions"]; invalid == tt.valid {
+			t.Errorf("%v: got errors %v, want valid %t", tt.permissions, v.Errors, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// BIOAFF/backend/cmd/api/context.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+	"github.com/jinzhu/gorm/backend/internal/limiter"
+)
+
+// Defining a custom type for the request context keys, avoids collisions with other packages
+type contextKey string
+
+const (
+	clientIPContextKey    = contextKey("client_ip")
+	requestInfoContextKey = contextKey("request_info")
+	loggerContextKey      = contextKey("logger")
+	userContextKey        = contextKey("user")
+	sessionContextKey     = contextKey("session")
+	apiKeyContextKey      = contextKey("api_key")
+	rateLimitContextKey   = contextKey("rate_limit")
+)
+
+// requestInfo - details about a request gathered for its access log line
+// it is shared by pointer so middleware further down the chain can fill in what they learn
+type requestInfo struct {
+	id       string
+	userID   int64
+	apiKeyID int64
+}
+
+// contextSetClientIP() - returns a copy of the request with the client's ip address added to its context
+func (app *application) contextSetClientIP(r *http.Request, ip netip.Addr) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+// contextGetClientIP() - retrieves the client's ip address from the request context
+// the zero value is returned when the realIP middleware hasn't run for the request
+func (app *application) contextGetClientIP(r *http.Request) netip.Addr {
+	ip, _ := r.Context().Value(clientIPContextKey).(netip.Addr)
+	return ip
+}
+
+// contextSetRequestInfo() - returns a copy of the request with the request info added to its context
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestInfo() - retrieves the request info, nil when the requestID middleware hasn't run
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
+
+// contextGetRequestID() - retrieves the request id, an empty string when there isn't one
+func (app *application) contextGetRequestID(r *http.Request) string {
+	info := app.contextGetRequestInfo(r)
+	if info == nil {
+		return ""
+	}
+	return info.id
+}
+
+// contextSetLogger() - returns a copy of the request with a request-scoped logger added to its context
+func (app *application) contextSetLogger(r *http.Request, logger *jsonlog.Logger) *http.Request {
+	ctx := context.WithValue(r.Context(), loggerContextKey, logger)
+	return r.WithContext(ctx)
+}
+
+// contextGetLogger() - retrieves the request-scoped logger, which carries the request id, client ip
+// and user id of the request, falling back to the application logger
+func (app *application) contextGetLogger(r *http.Request) *jsonlog.Logger {
+	logger, ok := r.Context().Value(loggerContextKey).(*jsonlog.Logger)
+	if !ok {
+		return app.logger
+	}
+	return logger
+}
+
+// contextSetUser() - returns a copy of the request with the user added to its context
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser() - retrieves the user from the request context
+// the authenticate middleware always sets one, so a missing user is a bug
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return user
+}
+
+// sessionRef - the session a request was made with, by the hash of its opaque authentication token
+// or, for a JWT access token, the id of the session it was issued for
+type sessionRef struct {
+	hash []byte
+	id   int64
+}
+
+// contextSetSession() - returns a copy of the request with its session added to its context
+func (app *application) contextSetSession(r *http.Request, session sessionRef) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, session)
+	return r.WithContext(ctx)
+}
+
+// contextGetSession() - retrieves the request's session, the zero value for anonymous requests
+func (app *application) contextGetSession(r *http.Request) sessionRef {
+	session, _ := r.Context().Value(sessionContextKey).(sessionRef)
+	return session
+}
+
+// contextSetAPIKey() - returns a copy of the request with the API key it was made with added to its context
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey() - retrieves the request's API key, nil unless it was made with one
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// contextSetRateLimit() - returns a copy of the request with the result of the per-ip rate limit added to its context
+func (app *application) contextSetRateLimit(r *http.Request, result limiter.Result) *http.Request {
+	ctx := context.WithValue(r.Context(), rateLimitContextKey, result)
+	return r.WithContext(ctx)
+}
+
+// contextGetRateLimit() - retrieves the result of the per-ip rate limit, ok is false when it wasn't checked
+func (app *application) contextGetRateLimit(r *http.Request) (limiter.Result, bool) {
+	result, ok := r.Context().Value(rateLimitContextKey).(limiter.Result)
+	return result, ok
+}
@@ -1,27 +1,82 @@
+// BIOAFF/backend/cmd/api/errors.go
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// error codes, clients branch on these so they must never change once released
+const (
+	codeServerError            = "server_error"
+	codeNotFound               = "not_found"
+	codeMethodNotAllowed       = "method_not_allowed"
+	codeBadRequest             = "bad_request"
+	codeValidationFailed       = "validation_failed"
+	codeEditConflict           = "edit_conflict"
+	codeRateLimitExceeded      = "rate_limit_exceeded"
+	codeInvalidCredentials     = "invalid_credentials"
+	codeLoginThrottled         = "login_throttled"
+	codeNotLocked              = "not_locked"
+	codeInvalidToken           = "invalid_authentication_token"
+	codeAuthenticationRequired = "authentication_required"
+	codeInactiveAccount        = "inactive_account"
+	codeNotPermitted           = "not_permitted"
+	codeTwoFactorRequired      = "two_factor_required"
+	codeTwoFactorEnabled       = "two_factor_enabled"
+	codeSSORequired            = "single_sign_on_required"
+	codeAccountExists          = "account_exists"
+	codeSSOLinked              = "single_sign_on_linked"
+	codeAPIKeyRevoked          = "api_key_revoked"
+)
+
+// problem - an RFC 7807 problem details object, sent as application/problem+json
+// code is stable for clients to branch on, message is meant for people and may change
+type problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Details   map[string]string `json:"details,omitempty"` //the fields which failed validation
+	RequestID string            `json:"request_id,omitempty"`
+}
+
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	//the request-scoped logger adds the request id, client ip and user id
+	app.contextGetLogger(r).PrintError(err, map[string]interface{}{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
 }
 
-// errorResponse() - sends a JSON-formatted error message to the client
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
-	//creating the json response
-	env := envelope{"error": message}
-	err := app.writeJSON(w, status, env, nil)
+// errorResponse() - sends a problem details object to the client
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]string) {
+	//the codes carry the meaning, so the problem type is left blank and the title is the status text
+	p := problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: app.contextGetRequestID(r), //lets users quote the failure to support
+	}
 
+	js, err := json.MarshalIndent(p, "", "\t")
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+	js = append(js, '\n')
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	w.Write(js)
 }
 
 // serverErrorResponse() - reports on errors that occur on the server
@@ -31,66 +86,115 @@ func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Reque
 
 	//preparing a message with the error
 	message := "the server encountered a problem and could not process the request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, codeServerError, message, nil)
 }
 
 // 404 not found - for when the path isn't a registered path
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested resource could not be found"
+	app.errorResponse(w, r, http.StatusNotFound, codeNotFound, message, nil)
+}
+
+// 405 method not allowed - for when the path exists but not with the method used
+func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	//creating our message
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, message, nil)
 }
 
 // bad request - when the user supplies a badly formatted request
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
 	//creating on error message
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
 }
 
 // Validation error - for when something goes wrong during validation
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+	message := "the request contains invalid fields"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, codeValidationFailed, message, errors)
 }
 
 // Edit conflict error - for when something goes wrong with editing a db record(s)
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusConflict, codeEditConflict, message, nil)
 }
 
 // Rate limit error - once something tries to pass the rate limit
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, codeRateLimitExceeded, message, nil)
 }
 
 // Invalid credentials
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidCredentials, message, nil)
+}
+
+// Too many failed logins - the account or client ip has to wait, or is locked out
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(retryAfter), 10))
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, codeLoginThrottled, message, nil)
 }
 
 // Invalid token
-func (app *application) invalidAuthenicationToken(w http.ResponseWriter, r *http.Request) {
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("WWW-Authenticate", "Bearer")
 	message := "invalid or missing authorization token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidToken, message, nil)
 }
 
 // Unauthorized access
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeAuthenticationRequired, message, nil)
 }
 
 // Users who have not activated their account
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, codeInactiveAccount, message, nil)
 }
 
 // User does not have required permission
-func (app *application) notPermittedReponse(w http.ResponseWriter, r *http.Request) {
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account does not have the necessary permission to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, codeNotPermitted, message, nil)
+}
+
+// User holds a permission which can't be used without two-factor authentication
+func (app *application) twoFactorRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account's permissions require two-factor authentication, turn it on to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, codeTwoFactorRequired, message, nil)
+}
+
+// Two-factor authentication is already on, it has to be turned off before starting again
+func (app *application) twoFactorEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is already turned on"
+	app.errorResponse(w, r, http.StatusConflict, codeTwoFactorEnabled, message, nil)
+}
+
+// Staff accounts linked to the directory log in with it, never with a password
+func (app *application) ssoRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your account logs in with single sign-on"
+	app.errorResponse(w, r, http.StatusForbidden, codeSSORequired, message, nil)
+}
+
+// The directory logged the user in but doesn't let them into BioAff
+func (app *application) ssoNotPermittedResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusForbidden, codeNotPermitted, message, nil)
+}
+
+// A staff account with the directory account's email exists, its owner has to link it while signed in
+func (app *application) ssoAccountExistsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "an account with your email address already exists, sign in to it and link it to your directory account"
+	app.errorResponse(w, r, http.StatusConflict, codeAccountExists, message, nil)
+}
+
+// The staff account, or the directory account, is already linked
+func (app *application) ssoLinkedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the account is already linked to a directory account"
+	app.errorResponse(w, r, http.StatusConflict, codeSSOLinked, message, nil)
 }
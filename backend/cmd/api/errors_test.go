@@ -0,0 +1,129 @@
+// BIOAFF/backend/cmd/api/errors_test.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+// decodeProblem() - checks the response is a problem details object and decodes it
+func decodeProblem(t *testing.T, res testResponse) problem {
+	t.Helper()
+
+	if ct := res.header.Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("got Content-Type %q, want application/problem+json", ct)
+	}
+	var p problem
+	res.decode(t, &p)
+	if p.Status != res.status {
+		t.Errorf("got status %d in the body, %d in the response", p.Status, res.status)
+	}
+	return p
+}
+
+func TestRouterErrors(t *testing.T) {
+	app := newTestApplication(t, nil, testConfig())
+	ts := newTestServer(t, app)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+		wantCode   string
+	}{
+		{"unknown path", http.MethodGet, "/v1/nothing-here", "", http.StatusNotFound, codeNotFound},
+		{"unknown method", http.MethodPost, "/v1/healthcheck", "", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"anonymous", http.MethodGet, "/v1/admin/log-level", "", http.StatusUnauthorized, codeAuthenticationRequired},
+		{"malformed token", http.MethodGet, "/v1/admin/log-level", "short", http.StatusUnauthorized, codeInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, tt.method, tt.path, tt.token, nil, "X-Request-ID", "problem-test")
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", res.status, tt.wantStatus)
+			}
+
+			p := decodeProblem(t, res)
+			if p.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", p.Code, tt.wantCode)
+			}
+			if p.Message == "" || p.Title != http.StatusText(tt.wantStatus) {
+				t.Errorf("got message %q and title %q", p.Message, p.Title)
+			}
+			if p.RequestID != "problem-test" {
+				t.Errorf("got request id %q, want problem-test", p.RequestID)
+			}
+		})
+	}
+
+	//httprouter lists the methods the path does support
+	res := ts.do(t, http.MethodDelete, "/v1/healthcheck", "", nil)
+	if allow := res.header.Get("Allow"); allow == "" {
+		t.Error("no Allow header on a 405")
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	app := newTestApplication(t, nil, testConfig())
+
+	tests := []struct {
+		name       string
+		respond    func(w http.ResponseWriter, r *http.Request)
+		wantStatus int
+		wantCode   string
+	}{
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			app.serverErrorResponse(w, r, errors.New("boom"))
+		}, http.StatusInternalServerError, codeServerError},
+		{"edit conflict", app.editConflictResponse, http.StatusConflict, codeEditConflict},
+		{"rate limit", app.rateLimitExceededResponse, http.StatusTooManyRequests, codeRateLimitExceeded},
+		{"invalid credentials", app.invalidCredentialsResponse, http.StatusUnauthorized, codeInvalidCredentials},
+		{"inactive account", app.inactiveAccountResponse, http.StatusForbidden, codeInactiveAccount},
+		{"not permitted", app.notPermittedResponse, http.StatusForbidden, codeNotPermitted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tt.respond(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rr.Code, tt.wantStatus)
+			}
+
+			p := decodeProblem(t, testResponse{status: rr.Code, header: rr.Header(), body: rr.Body.Bytes()})
+			if p.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", p.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestValidationProblem(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	token := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "writer@example.com", "logs:write"))
+
+	res := ts.do(t, http.MethodPut, "/v1/admin/log-level", token, map[string]string{"level": "loud"})
+	if res.status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d, want 422", res.status)
+	}
+	p := decodeProblem(t, res)
+	if p.Code != codeValidationFailed || p.Details["level"] == "" {
+		t.Errorf("got %+v, want validation_failed with a level detail", p)
+	}
+
+	res = ts.do(t, http.MethodPut, "/v1/admin/log-level", token, `{"level":`)
+	if res.status != http.StatusBadRequest {
+		t.Fatalf("got status %d, want 400", res.status)
+	}
+	if p := decodeProblem(t, res); p.Code != codeBadRequest {
+		t.Errorf("got code %q, want %q", p.Code, codeBadRequest)
+	}
+}
@@ -0,0 +1,238 @@
+// BIOAFF/backend/cmd/api/forms.go
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/mailer"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// showFormStatusHandler() - the verification status of a form, for staff and the partner agencies' API keys,
+// nothing about the applicant is included
+func (app *application) showFormStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	form, err := app.models.Forms.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := envelope{
+		"id":          form.ID,
+		"form_number": form.FormNumber,
+		"status":      form.Status,
+		"archived":    form.Archived,
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"form": status}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateFormStatusHandler() - a reviewer verifies or returns a form, an email to the applicant is queued with the review
+func (app *application) updateFormStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//holds the review sent by the client
+	var input struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	form, err := app.models.Forms.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateStatusChange(v, form, input.Status, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the history keeps the reason next to the decision
+	comment := input.Status
+	if input.Reason != "" {
+		comment += ": " + input.Reason
+	}
+
+	//the applicant is told about the review in their own language, unless they opted out
+	applicant, err := app.models.Users.Get(data.UserKindPublic, form.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	prefs, err := app.models.Notifications.GetPreferences(applicant.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	n, email := statusChangeNotification(form, input.Status, input.Reason, applicant, prefs)
+
+	form.Status = input.Status
+	reviewer := app.contextGetUser(r)
+	err = app.models.Forms.UpdateStatus(form, reviewer.ID, comment, n, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"form": form}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// statusChangeNotification() - the notification about a form's new status and the email to queue for it,
+// the email is nil when the applicant opted out
+func statusChangeNotification(form *data.Form, status, reason string, applicant *data.User, prefs *data.Preferences) (*data.Notification, *data.Email) {
+	kind := data.NotificationFormVerified
+	if status == data.FormStatusReturned {
+		kind = data.NotificationFormReturned
+	}
+
+	n := &data.Notification{
+		UserID:    applicant.ID,
+		FormID:    form.ID,
+		Kind:      kind,
+		Template:  mailer.TemplateFor(kind, prefs.Language),
+		Recipient: applicant.Email,
+		Status:    data.NotificationQueued,
+	}
+	if !prefs.FormStatusEmails {
+		n.Status = data.NotificationOptedOut
+		return n, nil
+	}
+
+	email := &data.Email{
+		Recipient: n.Recipient,
+		Template:  n.Template,
+		Data: map[string]interface{}{
+			"name":       form.AffiantFullName,
+			"formNumber": form.FormNumber,
+			"reason":     reason,
+		},
+	}
+	return n, email
+}
+
+// showPreferencesHandler() - returns the applicant's notification preferences
+func (app *application) showPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	//staff don't receive applicant emails, so they have no preferences
+	if user.Kind != data.UserKindPublic {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	prefs, err := app.models.Notifications.GetPreferences(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"preferences": prefs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updatePreferencesHandler() - changes the applicant's notification preferences, omitted fields are left as they are
+func (app *application) updatePreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if user.Kind != data.UserKindPublic {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	prefs, err := app.models.Notifications.GetPreferences(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//pointers so we can tell a missing field from a false or empty one
+	var input struct {
+		FormStatusEmails *bool   `json:"form_status_emails"`
+		Language         *string `json:"language"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.FormStatusEmails != nil {
+		prefs.FormStatusEmails = *input.FormStatusEmails
+	}
+	if input.Language != nil {
+		prefs.Language = *input.Language
+	}
+
+	v := validator.New()
+	if data.ValidatePreferences(v, prefs, mailer.Languages...); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Notifications.UpdatePreferences(user.ID, prefs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"preferences": prefs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,196 @@
+// BIOAFF/backend/cmd/api/forms_test.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+// insertForm() - files a form for the applicant with the given status
+func insertForm(t *testing.T, app *testApp, applicant *data.User, status string) *data.Form {
+	t.Helper()
+
+	query := `
+		INSERT INTO form (user_id, form_id, form_status, archive_status, affiant_full_name, social_security_num,
+			social_security_date, social_security_country, passport_number, passport_date, passport_country, dob,
+			place_of_birth, nationality, affiants_address, residencial_phone_number)
+		VALUES ($1, 343434, $2, false, 'Hipolito Bautista', 2948503, '2015-12-17', 'Belize', 29293, '2014-12-17',
+			'Belize', '1980-12-17', 'Corozal', 'Belizean', '2 baboon avenue', 6367238)
+		RETURNING id`
+
+	var id int64
+	err := app.db.QueryRow(query, applicant.ID, status).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form, err := app.models.Forms.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form
+}
+
+func TestUpdateFormStatus(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	verifier := insertUser(t, app, data.UserKindAdmin, "reviewer@example.com", "forms:verify")
+	enableTwoFactor(t, app, verifier)
+	reviewer := authenticationToken(t, app, verifier)
+	reader := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "reader@example.com", "forms:read"))
+	applicant := insertUser(t, app, data.UserKindPublic, "applicant@example.com")
+
+	t.Run("verified", func(t *testing.T) {
+		form := insertForm(t, app, applicant, data.FormStatusPending)
+
+		res := ts.do(t, http.MethodPatch, fmt.Sprintf("/v1/forms/%d/status", form.ID), reviewer, map[string]string{"status": "verified"})
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d, want 200: %s", res.status, res.body)
+		}
+		var body struct {
+			Form data.Form `json:"form"`
+		}
+		res.decode(t, &body)
+		if body.Form.Status != data.FormStatusVerified || body.Form.Version != form.Version+1 {
+			t.Errorf("got %+v", body.Form)
+		}
+
+		drainOutbox(t, app)
+		emails := app.mailer.emails()
+		if len(emails) != 1 || emails[0].recipient != applicant.Email || emails[0].templateFile != "form_verified.en.tmpl" {
+			t.Fatalf("got emails %+v", emails)
+		}
+
+		notifications, err := app.models.Notifications.GetAllForUser(applicant.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notifications) != 1 || notifications[0].Status != data.NotificationSent || notifications[0].FormID != form.ID {
+			t.Errorf("got notifications %+v", notifications)
+		}
+
+		//a reviewed form can't be reviewed again
+		res = ts.do(t, http.MethodPatch, fmt.Sprintf("/v1/forms/%d/status", form.ID), reviewer, map[string]string{"status": "returned", "reason": "typo"})
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d, want 422", res.status)
+		}
+	})
+
+	t.Run("returned in spanish", func(t *testing.T) {
+		err := app.models.Notifications.UpdatePreferences(applicant.ID, &data.Preferences{FormStatusEmails: true, Language: "es"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := insertForm(t, app, applicant, data.FormStatusPending)
+		path := fmt.Sprintf("/v1/forms/%d/status", form.ID)
+
+		res := ts.do(t, http.MethodPatch, path, reviewer, map[string]string{"status": "returned"})
+		if res.status != http.StatusUnprocessableEntity {
+			t.Fatalf("got status %d, want 422 without a reason", res.status)
+		}
+
+		before := len(app.mailer.emails())
+		res = ts.do(t, http.MethodPatch, path, reviewer, map[string]string{"status": "returned", "reason": "the passport number is missing"})
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d, want 200: %s", res.status, res.body)
+		}
+
+		drainOutbox(t, app)
+		emails := app.mailer.emails()[before:]
+		if len(emails) != 1 || emails[0].templateFile != "form_returned.es.tmpl" {
+			t.Fatalf("got emails %+v", emails)
+		}
+		if got := emails[0].data.(map[string]interface{})["reason"]; got != "the passport number is missing" {
+			t.Errorf("got reason %v", got)
+		}
+	})
+
+	t.Run("opted out", func(t *testing.T) {
+		err := app.models.Notifications.UpdatePreferences(applicant.ID, &data.Preferences{FormStatusEmails: false, Language: "en"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := insertForm(t, app, applicant, data.FormStatusNew)
+
+		before := len(app.mailer.emails())
+		res := ts.do(t, http.MethodPatch, fmt.Sprintf("/v1/forms/%d/status", form.ID), reviewer, map[string]string{"status": "verified"})
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d, want 200", res.status)
+		}
+
+		drainOutbox(t, app)
+		if len(app.mailer.emails()) != before {
+			t.Error("emailed an applicant who opted out")
+		}
+		notifications, err := app.models.Notifications.GetAllForUser(applicant.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if notifications[0].Status != data.NotificationOptedOut {
+			t.Errorf("got status %q, want opted_out", notifications[0].Status)
+		}
+	})
+
+	t.Run("not permitted", func(t *testing.T) {
+		form := insertForm(t, app, applicant, data.FormStatusPending)
+
+		res := ts.do(t, http.MethodPatch, fmt.Sprintf("/v1/forms/%d/status", form.ID), reader, map[string]string{"status": "verified"})
+		if res.status != http.StatusForbidden {
+			t.Errorf("got status %d, want 403", res.status)
+		}
+	})
+
+	t.Run("unknown form", func(t *testing.T) {
+		res := ts.do(t, http.MethodPatch, "/v1/forms/999999/status", reviewer, map[string]string{"status": "verified"})
+		if res.status != http.StatusNotFound {
+			t.Errorf("got status %d, want 404", res.status)
+		}
+	})
+}
+
+func TestPreferences(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	applicant := authenticationToken(t, app, insertUser(t, app, data.UserKindPublic, "applicant@example.com"))
+	staff := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "staff@example.com"))
+
+	var body struct {
+		Preferences data.Preferences `json:"preferences"`
+	}
+	res := ts.do(t, http.MethodGet, "/v1/users/me/preferences", applicant, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want 200", res.status)
+	}
+	res.decode(t, &body)
+	if !body.Preferences.FormStatusEmails || body.Preferences.Language != "en" {
+		t.Errorf("got defaults %+v", body.Preferences)
+	}
+
+	res = ts.do(t, http.MethodPatch, "/v1/users/me/preferences", applicant, map[string]string{"language": "es"})
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want 200", res.status)
+	}
+	res.decode(t, &body)
+	if !body.Preferences.FormStatusEmails || body.Preferences.Language != "es" {
+		t.Errorf("got %+v, want emails left on and language es", body.Preferences)
+	}
+
+	res = ts.do(t, http.MethodPatch, "/v1/users/me/preferences", applicant, map[string]string{"language": "fr"})
+	if res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d, want 422", res.status)
+	}
+
+	res = ts.do(t, http.MethodGet, "/v1/users/me/preferences", staff, nil)
+	if res.status != http.StatusForbidden {
+		t.Errorf("got status %d, want 403 for staff", res.status)
+	}
+	res = ts.do(t, http.MethodGet, "/v1/users/me/preferences", "", nil)
+	if res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d, want 401", res.status)
+	}
+}
@@ -1,12 +1,31 @@
 //BIOAFF/backend/cmd/api/healthcheck.go
-//Note: this is a testing file only
 
 package main
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"net/textproto"
+	"time"
 )
 
+// dependencyStatus - the result of probing a single dependency of the api
+type dependencyStatus struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Detail  string `json:"detail,omitempty"`
+	Error   string `json:"error,omitempty"`
+	err     error  //the full error, only logged since the endpoint is public
+}
+
+// healthy() - reports if the dependency can currently be used
+func (d dependencyStatus) healthy() bool {
+	return d.Status == "up"
+}
+
+// healthcheckHandler() - liveness probe, the process is running and can answer requests
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 	data := envelope{
 		"status": "available",
@@ -25,3 +44,133 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 }
+
+// readinessHandler() - readiness probe, checks every dependency the api needs to serve traffic
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]dependencyStatus{
+		"database":   app.checkDatabase(r.Context()),
+		"migrations": app.checkMigrations(r.Context()),
+	}
+
+	//the smtp probe is optional since not every environment can reach the mail server
+	if app.config.healthcheck.smtp {
+		checks["smtp"] = app.checkSMTP(r.Context())
+	}
+
+	//any unhealthy dependency, or a shutdown in progress, makes the instance unavailable
+	status := "available"
+	code := http.StatusOK
+	for name, check := range checks {
+		if !check.healthy() {
+			status = "unavailable"
+			code = http.StatusServiceUnavailable
+			app.logError(r, fmt.Errorf("readiness %s check: %w", name, check.err))
+		}
+	}
+	if app.shuttingDown.Load() {
+		status = "shutting down"
+		code = http.StatusServiceUnavailable
+	}
+
+	data := envelope{
+		"status": status,
+		"checks": checks,
+		"system_info": map[string]string{
+			"environment": app.config.env,
+			"version":     version,
+		},
+	}
+
+	err := app.writeJSON(w, code, data, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// checkDatabase() - pings the connection pool within the healthcheck timeout
+func (app *application) checkDatabase(ctx context.Context) dependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, app.config.healthcheck.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := app.db.PingContext(ctx)
+	return newDependencyStatus(start, "", err)
+}
+
+// checkMigrations() - makes sure the schema is at the version this build expects
+func (app *application) checkMigrations(ctx context.Context) dependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, app.config.healthcheck.timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	var (
+		current int64
+		dirty   bool
+	)
+	query := `
+		SELECT version, dirty
+		FROM schema_migrations
+		LIMIT 1`
+	err := app.db.QueryRowContext(ctx, query).Scan(&current, &dirty)
+	if err != nil {
+		return newDependencyStatus(start, "", err)
+	}
+
+	detail := fmt.Sprintf("version %d, expected %d", current, app.config.db.schemaVersion)
+	switch {
+	case dirty:
+		err = fmt.Errorf("schema version %d is dirty", current)
+	case current != app.config.db.schemaVersion:
+		err = fmt.Errorf("schema is at version %d but version %d is required", current, app.config.db.schemaVersion)
+	}
+	return newDependencyStatus(start, detail, err)
+}
+
+// checkSMTP() - connects to the mail server and waits for its greeting
+func (app *application) checkSMTP(ctx context.Context) dependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, app.config.healthcheck.timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	addr := net.JoinHostPort(app.config.smtp.host, fmt.Sprint(app.config.smtp.port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return newDependencyStatus(start, "", err)
+	}
+	defer conn.Close()
+
+	//the greeting has to arrive before the context deadline as well
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	text := textproto.NewConn(conn)
+	_, _, err = text.ReadResponse(220)
+	if err != nil {
+		return newDependencyStatus(start, "", err)
+	}
+
+	//say goodbye politely, the reply doesn't matter
+	text.PrintfLine("QUIT")
+	return newDependencyStatus(start, "", nil)
+}
+
+// newDependencyStatus() - builds the status of a probe which started at the given time
+func newDependencyStatus(start time.Time, detail string, err error) dependencyStatus {
+	status := dependencyStatus{
+		Status:  "up",
+		Latency: time.Since(start).String(),
+		Detail:  detail,
+	}
+	if err != nil {
+		//driver and smtp errors name internal hosts and auth failures, so the body only says it's unavailable
+		status.Status = "down"
+		status.Error = "unavailable"
+		status.err = err
+	}
+	return status
+}
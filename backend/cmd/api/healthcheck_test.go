@@ -0,0 +1,132 @@
+// BIOAFF/backend/cmd/api/healthcheck_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+func TestLiveness(t *testing.T) {
+	app := newTestApplication(t, nil, testConfig())
+	ts := newTestServer(t, app)
+
+	for _, path := range []string{"/v1/healthcheck", "/v1/healthcheck/live"} {
+		res := ts.do(t, http.MethodGet, path, "", nil)
+		if res.status != http.StatusOK {
+			t.Fatalf("%s: got status %d, want 200", path, res.status)
+		}
+
+		var body struct {
+			Status     string            `json:"status"`
+			SystemInfo map[string]string `json:"system_info"`
+		}
+		res.decode(t, &body)
+		if body.Status != "available" || body.SystemInfo["version"] != version {
+			t.Errorf("%s: got %+v", path, body)
+		}
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	db := testdb.Open(t)
+
+	tests := []struct {
+		name          string
+		schemaVersion int64
+		shuttingDown  bool
+		wantStatus    int
+		wantBody      string
+	}{
+		{"ready", 0, false, http.StatusOK, "available"},
+		{"schema behind", 999999, false, http.StatusServiceUnavailable, "unavailable"},
+		{"shutting down", 0, true, http.StatusServiceUnavailable, "shutting down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.db.schemaVersion = tt.schemaVersion
+			app := newTestApplication(t, db, cfg)
+			app.shuttingDown.Store(tt.shuttingDown)
+			ts := newTestServer(t, app)
+
+			res := ts.do(t, http.MethodGet, "/v1/healthcheck/ready", "", nil)
+			if res.status != tt.wantStatus {
+				t.Errorf("got status %d, want %d", res.status, tt.wantStatus)
+			}
+
+			var body struct {
+				Status string                      `json:"status"`
+				Checks map[string]dependencyStatus `json:"checks"`
+			}
+			res.decode(t, &body)
+			if body.Status != tt.wantBody {
+				t.Errorf("got status %q, want %q", body.Status, tt.wantBody)
+			}
+			if !body.Checks["database"].healthy() {
+				t.Errorf("got database check %+v, want up", body.Checks["database"])
+			}
+		})
+	}
+}
+
+func TestReadinessDuringDrain(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- app.shutdown(ts.Config, nil)
+	}()
+	for !app.shuttingDown.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	//the listener is still open for the drain delay, so the probe sees the shutdown
+	res, err := http.Get(ts.URL + "/v1/healthcheck/ready")
+	if err != nil {
+		t.Fatalf("got %v during the drain delay, want the listener still open", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d during the drain delay, want 503", res.StatusCode)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get(ts.URL + "/v1/healthcheck/ready"); err == nil {
+		t.Error("got a response after the shutdown, want the connection refused")
+	}
+}
+
+func TestDependencyErrorHidden(t *testing.T) {
+	//a port nothing listens on, the dial error names the address
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	cfg := testConfig()
+	cfg.smtp.host, cfg.smtp.port = "127.0.0.1", addr.Port
+	app := newTestApplication(t, nil, cfg)
+
+	check := app.checkSMTP(context.Background())
+	body, err := json.Marshal(check)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.healthy() || check.Error != "unavailable" || check.err == nil || strings.Contains(string(body), "127.0.0.1") {
+		t.Errorf("got %s, want the smtp server's address kept out of the response", body)
+	}
+}
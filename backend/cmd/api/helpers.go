@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/jinzhu/gorm/backend/internal/data"
 	"github.com/jinzhu/gorm/backend/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -32,6 +33,20 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readUserParams() - finds the account named by the :kind and :id parameters, ErrRecordNotFound covers
+// parameters that can't name an account as well as accounts that don't exist
+func (app *application) readUserParams(r *http.Request) (*data.User, error) {
+	kind := httprouter.ParamsFromContext(r.Context()).ByName("kind")
+	if kind != data.UserKindAdmin && kind != data.UserKindPublic {
+		return nil, data.ErrRecordNotFound
+	}
+	id, err := app.readIDParam(r)
+	if err != nil {
+		return nil, data.ErrRecordNotFound
+	}
+	return app.models.Users.Get(kind, id)
+}
+
 // writeJSON - allows us to push json formatted messages to the client user
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	//converting map into a JSON object
@@ -151,20 +166,3 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	}
 	return intValue
 }
-
-// background accepts a function as it's parameter
-func (app *application) background(fn func()) {
-	//increament the WaitGroup counter
-	app.wg.Add(1)
-	go func() {
-		defer app.wg.Done()
-		//recover from panics
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
-			}
-		}()
-		//Execute fn()
-		fn()
-	}()
-}
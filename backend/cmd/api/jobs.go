@@ -0,0 +1,100 @@
+// BIOAFF/backend/cmd/api/jobs.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// the job types the api runs
+const (
+	jobSendEmails         = "send-emails"
+	jobLimiterCleanup     = "limiter-cleanup"
+	jobPurgeExpiredTokens = "purge-expired-tokens"
+	jobSaveSessions       = "save-sessions"
+)
+
+// registerJobs() - registers the api's job types and schedules the recurring ones
+func (app *application) registerJobs() error {
+	app.jobs.Register(jobSendEmails, 10*time.Minute, app.sendEmailsJob)
+	app.jobs.Register(jobLimiterCleanup, 30*time.Second, app.limiterCleanupJob)
+	app.jobs.Register(jobPurgeExpiredTokens, time.Minute, app.purgeExpiredTokensJob)
+	app.jobs.Register(jobSaveSessions, 30*time.Second, app.saveSessionsJob)
+
+	schedules := []struct {
+		name  string
+		every time.Duration
+	}{
+		{jobSendEmails, app.config.outbox.pollInterval},
+		{jobLimiterCleanup, time.Minute},
+		{jobPurgeExpiredTokens, time.Hour},
+		{jobSaveSessions, app.config.sessions.flushInterval},
+	}
+	for _, s := range schedules {
+		err := app.jobs.Every(s.name, nil, s.every)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendEmailsJob() - sends every due email in the outbox with outbox-workers senders,
+// stopping between emails once ctx is done so an email is never cut off half sent
+func (app *application) sendEmailsJob(ctx context.Context, arg interface{}) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, app.config.outbox.workers)
+
+	for i := 0; i < app.config.outbox.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				processed, err := app.sendNextEmail()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !processed {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	//the other senders carried on, so one error is enough to report
+	return <-errs
+}
+
+// limiterCleanupJob() - forgets the rate limiter buckets which have refilled
+func (app *application) limiterCleanupJob(ctx context.Context, arg interface{}) error {
+	return app.limiter.Cleanup(ctx, time.Minute)
+}
+
+// purgeExpiredTokensJob() - deletes the tokens, and the single sign-on logins, which can no longer be used
+func (app *application) purgeExpiredTokensJob(ctx context.Context, arg interface{}) error {
+	deleted, err := app.models.Tokens.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	logins, err := app.models.SSO.DeleteExpiredLogins()
+	if err != nil {
+		return err
+	}
+	if deleted > 0 || logins > 0 {
+		app.logger.PrintInfo("purged expired tokens", map[string]interface{}{"tokens": deleted, "sso_logins": logins})
+	}
+	return nil
+}
+
+// showJobsHandler() - what the job runner is doing, along with its most recent runs
+func (app *application) showJobsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"jobs": app.jobs.Status()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,73 @@
+// BIOAFF/backend/cmd/api/jobs_test.go
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/jobs"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+func TestSendEmailsJob(t *testing.T) {
+	cfg := testConfig()
+	cfg.outbox.pollInterval = 10 * time.Millisecond
+	app := newTestApplication(t, testdb.Open(t), cfg)
+
+	err := app.registerJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.jobs.Start()
+
+	for _, recipient := range []string{"one@example.com", "two@example.com", "three@example.com"} {
+		err := app.models.Outbox.Insert(&data.Email{
+			Recipient: recipient,
+			Template:  "form_verified.en.tmpl",
+			Data:      map[string]interface{}{"name": "Someone", "formNumber": 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(app.mailer.emails()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := len(app.mailer.emails()); got != 3 {
+		t.Fatalf("got %d emails, want the 3 queued ones sent", got)
+	}
+}
+
+func TestShowJobs(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	err := app.registerJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "reader@example.com", "jobs:read"))
+	nobody := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "nobody@example.com"))
+
+	res := ts.do(t, http.MethodGet, "/v1/admin/jobs", reader, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want 200", res.status)
+	}
+	var body struct {
+		Jobs jobs.Status `json:"jobs"`
+	}
+	res.decode(t, &body)
+	if len(body.Jobs.Types) != 5 || len(body.Jobs.Scheduled) != 4 || body.Jobs.Workers != app.config.jobs.workers {
+		t.Errorf("got %+v, want the 4 recurring jobs", body.Jobs)
+	}
+
+	res = ts.do(t, http.MethodGet, "/v1/admin/jobs", nobody, nil)
+	if res.status != http.StatusForbidden {
+		t.Errorf("got status %d without jobs:read, want 403", res.status)
+	}
+}
@@ -0,0 +1,175 @@
+// BIOAFF/backend/cmd/api/jwt.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/jwt"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// the auth modes, opaque tokens are looked up on every request while JWT access tokens are checked with
+// the keys alone and traded for new ones with a refresh token
+const (
+	authModeOpaque = "opaque"
+	authModeJWT    = "jwt"
+)
+
+// jwksMaxAge - how long clients may cache the JWKS, a new key should be published this long before signing with it
+const jwksMaxAge = 5 * time.Minute
+
+// jwtKeyFile - a key from a -jwt-key flag, read when the server starts
+type jwtKeyFile struct {
+	id        string
+	algorithm string
+	path      string
+}
+
+// String() - the key in the form of its flag
+func (k jwtKeyFile) String() string {
+	return k.id + ":" + k.algorithm + ":" + k.path
+}
+
+// parseJWTKeyFile() - parses a -jwt-key flag value such as "2026-10:EdDSA:/etc/bioaff/jwt.pem"
+func parseJWTKeyFile(val string) (jwtKeyFile, error) {
+	parts := strings.SplitN(val, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return jwtKeyFile{}, fmt.Errorf("jwt key %q: must be id:algorithm:path", val)
+	}
+	if !validator.In(parts[1], jwt.EdDSA, jwt.HS256) {
+		return jwtKeyFile{}, fmt.Errorf("jwt key %q: the algorithm must be %s or %s", val, jwt.EdDSA, jwt.HS256)
+	}
+	return jwtKeyFile{id: parts[0], algorithm: parts[1], path: parts[2]}, nil
+}
+
+// loadJWTKeys() - reads the configured keys into the key set access tokens are signed and verified with
+func loadJWTKeys(cfg config) (*jwt.KeySet, error) {
+	keys := make([]*jwt.Key, 0, len(cfg.jwt.keys))
+	for _, kf := range cfg.jwt.keys {
+		material, err := os.ReadFile(kf.path)
+		if err != nil {
+			return nil, err
+		}
+		k, err := jwt.ParseKey(kf.id, kf.algorithm, material)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return jwt.NewKeySet(cfg.jwt.issuer, cfg.jwt.signingKey, keys...)
+}
+
+// userFromAccessToken() - the user a JWT access token was issued to, as it was then, and the id of its session.
+// Nothing is looked up, so a revoked session or changed account only takes effect once the token expires.
+func (app *application) userFromAccessToken(token string) (*data.User, int64, error) {
+	claims, err := app.jwtKeys.Verify(token, time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || id < 1 || !validator.In(claims.Kind, data.UserKindAdmin, data.UserKindPublic) {
+		return nil, 0, jwt.ErrInvalidToken
+	}
+
+	user := &data.User{
+		ID:        id,
+		Kind:      claims.Kind,
+		Email:     claims.Email,
+		Activated: claims.Activated,
+	}
+	return user, claims.SessionID, nil
+}
+
+// writeTokenPair() - responds with a new access token for the user along with the session's refresh token
+func (app *application) writeTokenPair(w http.ResponseWriter, r *http.Request, user *data.User, refresh *data.Token) {
+	now := time.Now()
+	access, err := app.jwtKeys.Sign(jwt.Claims{
+		Subject:   strconv.FormatInt(user.ID, 10),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(app.config.jwt.accessTTL).Unix(),
+		Kind:      user.Kind,
+		Email:     user.Email,
+		Activated: user.Activated,
+		SessionID: refresh.Family,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"expires_in":    int(app.config.jwt.accessTTL.Seconds()),
+		"refresh_token": refresh,
+	}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createRefreshTokenHandler() - trades a refresh token for a new access token and a new refresh token,
+// presenting a refresh token a second time signs its session out as it must have been stolen
+func (app *application) createRefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	refresh, err := app.models.RefreshTokens.Rotate(input.TokenPlaintext, app.config.jwt.refreshTTL, app.contextGetClientIP(r).String(), r.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.contextGetLogger(r).PrintWarn("refresh token reused, session revoked", nil)
+			v.AddError("token", "invalid or expired refresh token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired refresh token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//the access token carries the account as it is now
+	user, err := app.models.Users.Get(refresh.UserKind, refresh.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeTokenPair(w, r, user, refresh)
+}
+
+// showJWKSHandler() - the public keys access tokens may be verified with, so other services can check
+// them without calling us and pick up new keys as they are rotated in
+func (app *application) showJWKSHandler(w http.ResponseWriter, r *http.Request) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"keys": app.jwtKeys.JWKS()}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,239 @@
+// BIOAFF/backend/cmd/api/jwt_test.go
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/jwt"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+// tokenPair - the body of a login or refresh in the jwt auth mode
+type tokenPair struct {
+	AccessToken  string     `json:"access_token"`
+	TokenType    string     `json:"token_type"`
+	ExpiresIn    int        `json:"expires_in"`
+	RefreshToken data.Token `json:"refresh_token"`
+}
+
+// jwtConfig() - the test config in the jwt auth mode
+func jwtConfig() config {
+	cfg := testConfig()
+	cfg.auth.mode = authModeJWT
+	cfg.jwt.keys = []jwtKeyFile{{id: "test", algorithm: jwt.EdDSA, path: "unused"}}
+	cfg.jwt.signingKey = "test"
+	return cfg
+}
+
+// useJWTKeys() - gives the application a fresh signing key, returning it
+func useJWTKeys(t *testing.T, app *testApp) *jwt.Key {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := jwt.NewEd25519Key("test", private)
+	app.jwtKeys, err = jwt.NewKeySet(app.config.jwt.issuer, k.ID, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestParseJWTKeyFile(t *testing.T) {
+	k, err := parseJWTKeyFile("2026-10:EdDSA:/etc/bioaff/jwt:2026.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.id != "2026-10" || k.algorithm != jwt.EdDSA || k.path != "/etc/bioaff/jwt:2026.pem" {
+		t.Errorf("got %+v", k)
+	}
+
+	for _, val := range []string{"", "a:EdDSA", ":EdDSA:/key.pem", "a:RS256:/key.pem", "a:EdDSA:"} {
+		if _, err := parseJWTKeyFile(val); err == nil {
+			t.Errorf("%q was accepted", val)
+		}
+	}
+}
+
+func TestAccessTokenAuthentication(t *testing.T) {
+	app := newTestApplication(t, nil, jwtConfig())
+	useJWTKeys(t, app)
+
+	var got *data.User
+	var session sessionRef
+	handler := app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, session = app.contextGetUser(r), app.contextGetSession(r)
+	}))
+	authenticate := func(token string) int {
+		got, session = nil, sessionRef{}
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	now := time.Now()
+	claims := jwt.Claims{
+		Subject:   strconv.Itoa(42),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		Kind:      data.UserKindAdmin,
+		Email:     "staff@example.com",
+		Activated: true,
+		SessionID: 7,
+	}
+	token, err := app.jwtKeys.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//no database is needed to know who the user is
+	if code := authenticate(token); code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", code)
+	}
+	if got.ID != 42 || got.Kind != data.UserKindAdmin || got.Email != "staff@example.com" || !got.Activated {
+		t.Errorf("got user %+v from the claims", got)
+	}
+	if session.id != 7 || session.hash != nil {
+		t.Errorf("got session %+v, want the refresh token family", session)
+	}
+
+	claims.ExpiresAt = now.Add(-time.Second).Unix()
+	expired, _ := app.jwtKeys.Sign(claims)
+	if code := authenticate(expired); code != http.StatusUnauthorized {
+		t.Errorf("got status %d for an expired token, want 401", code)
+	}
+
+	//a token signed with a key we don't hold
+	other := app.jwtKeys
+	useJWTKeys(t, app)
+	if code := authenticate(token); code != http.StatusUnauthorized {
+		t.Errorf("got status %d for a token from another key, want 401", code)
+	}
+
+	//the opaque mode never accepts JWTs
+	app.jwtKeys = nil
+	token, _ = other.Sign(jwt.Claims{Subject: "42", ExpiresAt: now.Add(time.Minute).Unix(), Kind: data.UserKindAdmin})
+	if code := authenticate(token); code != http.StatusUnauthorized {
+		t.Errorf("got status %d for a JWT in the opaque mode, want 401", code)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	app := newTestApplication(t, nil, jwtConfig())
+	k := useJWTKeys(t, app)
+	ts := newTestServer(t, app)
+
+	res := ts.do(t, http.MethodGet, "/.well-known/jwks.json", "", nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want 200", res.status)
+	}
+	if cc := res.header.Get("Cache-Control"); cc != "public, max-age=300" {
+		t.Errorf("got Cache-Control %q", cc)
+	}
+	var body struct {
+		Keys []jwt.JWK `json:"keys"`
+	}
+	res.decode(t, &body)
+	if len(body.Keys) != 1 || body.Keys[0].KeyID != k.ID || body.Keys[0].Algorithm != jwt.EdDSA {
+		t.Errorf("got keys %+v, want the signing key", body.Keys)
+	}
+
+	//the opaque mode has neither the keys nor refresh tokens
+	ts = newTestServer(t, newTestApplication(t, nil, testConfig()))
+	if res := ts.do(t, http.MethodGet, "/.well-known/jwks.json", "", nil); res.status != http.StatusNotFound {
+		t.Errorf("got status %d in the opaque mode, want 404", res.status)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), jwtConfig())
+	useJWTKeys(t, app)
+	ts := newTestServer(t, app)
+
+	applicant := insertUser(t, app, data.UserKindPublic, "applicant@example.com")
+	_, err := app.db.Exec("UPDATE public_user SET pu_password = 'Legacy-Pass-1' WHERE id = $1", applicant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{"email": applicant.Email, "password": "Legacy-Pass-1"})
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d logging in, want 201: %s", res.status, res.body)
+	}
+	var login tokenPair
+	res.decode(t, &login)
+	if login.TokenType != "Bearer" || login.ExpiresIn != 900 || !jwt.LooksLikeJWT(login.AccessToken) {
+		t.Fatalf("got %+v, want a bearer JWT valid for 15 minutes", login)
+	}
+
+	res = ts.do(t, http.MethodGet, "/v1/users/me/sessions", login.AccessToken, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d with the access token, want 200", res.status)
+	}
+	var list sessionList
+	res.decode(t, &list)
+	if len(list.Sessions) != 1 || !list.Sessions[0].Current {
+		t.Fatalf("got %+v, want the refresh token's session marked current", list.Sessions)
+	}
+	session := list.Sessions[0].ID
+
+	refresh := func(token string) testResponse {
+		return ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]string{"token": token})
+	}
+	res = refresh(login.RefreshToken.Plaintext)
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d refreshing, want 201: %s", res.status, res.body)
+	}
+	var rotated tokenPair
+	res.decode(t, &rotated)
+	if rotated.RefreshToken.Plaintext == login.RefreshToken.Plaintext {
+		t.Fatal("the refresh token wasn't rotated")
+	}
+
+	//still one session, with the same id
+	res = ts.do(t, http.MethodGet, "/v1/users/me/sessions", rotated.AccessToken, nil)
+	res.decode(t, &list)
+	if len(list.Sessions) != 1 || list.Sessions[0].ID != session || !list.Sessions[0].Current {
+		t.Errorf("got %+v after rotating, want session %d", list.Sessions, session)
+	}
+
+	//replaying the first refresh token means it leaked, the whole session goes
+	if res := refresh(login.RefreshToken.Plaintext); res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d reusing a refresh token, want 422", res.status)
+	}
+	if res := refresh(rotated.RefreshToken.Plaintext); res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d with the session's latest refresh token after reuse, want 422", res.status)
+	}
+	if n := countAuditEvents(t, app, data.AuditRefreshReused); n != 1 {
+		t.Errorf("got %d reuse events, want 1", n)
+	}
+
+	//revoking a session takes its refresh token with it
+	res = ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{"email": applicant.Email, "password": "Legacy-Pass-1"})
+	res.decode(t, &login)
+	res = ts.do(t, http.MethodDelete, "/v1/users/me/sessions", login.AccessToken, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d signing out everywhere, want 200", res.status)
+	}
+	var revoked struct {
+		Sessions int `json:"sessions"`
+	}
+	res.decode(t, &revoked)
+	if revoked.Sessions != 1 {
+		t.Errorf("got %d sessions revoked, want 1", revoked.Sessions)
+	}
+	if res := refresh(login.RefreshToken.Plaintext); res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d with a revoked session's refresh token, want 422", res.status)
+	}
+}
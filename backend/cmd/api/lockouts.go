@@ -0,0 +1,168 @@
+// BIOAFF/backend/cmd/api/lockouts.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/mailer"
+)
+
+// loginWait() - how long until a login may be attempted, zero unless the client ip or the account
+// is locked out or the account's recent failures call for a delay
+func (app *application) loginWait(accountKey, ipKey string) (time.Duration, error) {
+	now := time.Now()
+
+	for _, key := range []string{ipKey, accountKey} {
+		t, err := app.models.LoginThrottle.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		if t.Locked(now) {
+			return t.LockedUntil.Sub(now), nil
+		}
+		//failures older than the window no longer count
+		if key == accountKey && now.Sub(t.LastFailureAt) <= app.config.login.window {
+			if wait := t.LastFailureAt.Add(app.loginDelay(t.Failures)).Sub(now); wait > 0 {
+				return wait, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// loginDelay() - the wait after an account's latest failed login, nothing until login-delay-after failures
+// and then login-delay doubled after each further one, up to login-max-delay
+func (app *application) loginDelay(failures int) time.Duration {
+	if failures < app.config.login.delayAfter {
+		return 0
+	}
+
+	delay := app.config.login.delay
+	for i := app.config.login.delayAfter; i < failures && delay < app.config.login.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > app.config.login.maxDelay {
+		delay = app.config.login.maxDelay
+	}
+	return delay
+}
+
+// recordLoginFailure() - counts a failed login against the account and the client ip, locking either out once
+// it reaches its limit. user is nil when there is no such account, its failures still count so the
+// responses don't reveal which emails have accounts.
+func (app *application) recordLoginFailure(r *http.Request, user *data.User, accountKey, ipKey string) error {
+	ip := app.contextGetClientIP(r).String()
+	logger := app.contextGetLogger(r)
+
+	account, err := app.models.LoginThrottle.RecordFailure(accountKey, app.config.login.window)
+	if err != nil {
+		return err
+	}
+	if account.Failures >= app.config.login.maxFailures && !account.Locked(time.Now()) {
+		event := &data.AuditEvent{
+			Event:   data.AuditAccountLocked,
+			IP:      ip,
+			Details: map[string]interface{}{"key": accountKey, "failures": account.Failures},
+		}
+
+		//the owner is told, with a way back in, if there is one
+		var email *data.Email
+		if user != nil {
+			event.UserID, event.UserKind = user.ID, user.Kind
+			language, err := app.userLanguage(user)
+			if err != nil {
+				return err
+			}
+			email = &data.Email{
+				Recipient: user.Email,
+				Template:  mailer.TemplateFor("account_locked", language),
+				Data:      map[string]interface{}{"minutes": int(app.config.login.lockout.Minutes())},
+			}
+		}
+
+		err = app.models.LoginThrottle.Lock(account, time.Now().Add(app.config.login.lockout), event, email)
+		switch {
+		case err == nil:
+			logger.PrintWarn("account locked out", map[string]interface{}{"key": accountKey, "failures": account.Failures})
+		case !errors.Is(err, data.ErrEditConflict):
+			return err
+		}
+	}
+
+	client, err := app.models.LoginThrottle.RecordFailure(ipKey, app.config.login.window)
+	if err != nil {
+		return err
+	}
+	if client.Failures >= app.config.login.ipMaxFailures && !client.Locked(time.Now()) {
+		event := &data.AuditEvent{
+			Event:   data.AuditIPLocked,
+			IP:      ip,
+			Details: map[string]interface{}{"key": ipKey, "failures": client.Failures},
+		}
+
+		err = app.models.LoginThrottle.Lock(client, time.Now().Add(app.config.login.lockout), event, nil)
+		switch {
+		case err == nil:
+			logger.PrintWarn("client ip locked out", map[string]interface{}{"key": ipKey, "failures": client.Failures})
+		case !errors.Is(err, data.ErrEditConflict):
+			return err
+		}
+	}
+	return nil
+}
+
+// listLockoutsHandler() - the accounts and client ips which are locked out right now
+func (app *application) listLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := app.models.LoginThrottle.GetLocked()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lockouts": lockouts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unlockUserHandler() - lifts an account's lockout before it runs out, recording which admin did it
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.readUserParams(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	event := &data.AuditEvent{
+		Event:    data.AuditAccountUnlocked,
+		UserID:   user.ID,
+		UserKind: user.Kind,
+		ActorID:  app.contextGetUser(r).ID,
+		IP:       app.contextGetClientIP(r).String(),
+	}
+	err = app.models.LoginThrottle.Unlock(data.AccountThrottleKey(user.Kind, user.Email), event)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, codeNotLocked, "the account is not locked out", nil)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.contextGetLogger(r).PrintInfo("account unlocked", map[string]interface{}{"unlocked_user_id": user.ID, "unlocked_user_kind": user.Kind})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "the account was unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,229 @@
+// BIOAFF/backend/cmd/api/lockouts_test.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+// login() - tries to log in to an applicant's account
+func login(t *testing.T, ts *testServer, email, password string) testResponse {
+	t.Helper()
+	return ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{"email": email, "password": password})
+}
+
+// countAuditEvents() - how many of the event were recorded
+func countAuditEvents(t *testing.T, app *testApp, event string) int {
+	t.Helper()
+
+	var n int
+	err := app.db.QueryRow("SELECT count(*) FROM audit_events WHERE event = $1", event).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestLoginDelay(t *testing.T) {
+	cfg := testConfig()
+	cfg.login.delayAfter = 3
+	cfg.login.delay = time.Second
+	cfg.login.maxDelay = 5 * time.Second
+	app := newTestApplication(t, nil, cfg)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 5 * time.Second},
+		{60, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := app.loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	//accounts from before passwords were hashed still have their plaintext password
+	applicant := insertUser(t, app, data.UserKindPublic, "applicant@example.com")
+	_, err := app.db.Exec("UPDATE public_user SET pu_password = 'Legacy-Pass-1' WHERE id = $1", applicant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := login(t, ts, "applicant@example.com", "wrong-password"); res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d with the wrong password, want 401", res.status)
+	}
+	res := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{"email": "applicant@example.com", "password": "Legacy-Pass-1", "kind": "admin"})
+	if res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d logging in as the wrong kind of account, want 401", res.status)
+	}
+
+	res = login(t, ts, "Applicant@example.com", "Legacy-Pass-1")
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", res.status, res.body)
+	}
+	var issued struct {
+		Token data.Token `json:"authentication_token"`
+	}
+	res.decode(t, &issued)
+	if res := ts.do(t, http.MethodGet, "/v1/users/me/preferences", issued.Token.Plaintext, nil); res.status != http.StatusOK {
+		t.Errorf("got status %d with the issued token, want 200", res.status)
+	}
+
+	//the first login hashes the legacy password
+	var legacy string
+	var hash []byte
+	err = app.db.QueryRow("SELECT pu_password, password_hash FROM public_user WHERE id = $1", applicant.ID).Scan(&legacy, &hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy != "" || hash == nil {
+		t.Errorf("got legacy password %q and hash %v, want only a hash", legacy, hash != nil)
+	}
+	if res := login(t, ts, "applicant@example.com", "Legacy-Pass-1"); res.status != http.StatusCreated {
+		t.Errorf("got status %d logging in with the hashed password, want 201", res.status)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	cfg := testConfig()
+	cfg.login.maxFailures = 3
+	cfg.login.delayAfter = 100
+	app := newTestApplication(t, testdb.Open(t), cfg)
+	ts := newTestServer(t, app)
+
+	applicant := insertUser(t, app, data.UserKindPublic, "applicant@example.com")
+	_, err := app.db.Exec("UPDATE public_user SET pu_password = 'Legacy-Pass-1' WHERE id = $1", applicant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := insertUser(t, app, data.UserKindAdmin, "staff@example.com", "users:unlock")
+	session := authenticationToken(t, app, admin)
+
+	for i := 0; i < cfg.login.maxFailures; i++ {
+		if res := login(t, ts, applicant.Email, "wrong-password"); res.status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got status %d, want 401", i+1, res.status)
+		}
+		//unknown emails are locked out the same way
+		if res := login(t, ts, "nobody@example.com", "wrong-password"); res.status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got status %d for an unknown email, want 401", i+1, res.status)
+		}
+	}
+
+	//even the right password is refused while locked out
+	res := login(t, ts, applicant.Email, "Legacy-Pass-1")
+	var problem problem
+	res.decode(t, &problem)
+	if res.status != http.StatusTooManyRequests || problem.Code != codeLoginThrottled {
+		t.Fatalf("got status %d, want 429 login_throttled: %s", res.status, res.body)
+	}
+	if res.header.Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	if res := login(t, ts, "nobody@example.com", "wrong-password"); res.status != http.StatusTooManyRequests {
+		t.Errorf("got status %d for a locked out unknown email, want 429", res.status)
+	}
+
+	//both lockouts are audited, only the real account is emailed
+	if n := countAuditEvents(t, app, data.AuditAccountLocked); n != 2 {
+		t.Errorf("got %d lockout events, want 2", n)
+	}
+	drainOutbox(t, app)
+	if emails := app.mailer.emails(); len(emails) != 1 || emails[0].recipient != applicant.Email || emails[0].templateFile != "account_locked.en.tmpl" {
+		t.Errorf("got emails %+v, want one lockout email to the applicant", emails)
+	}
+
+	res = ts.do(t, http.MethodGet, "/v1/admin/lockouts", session, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d listing lockouts, want 200", res.status)
+	}
+	var listed struct {
+		Lockouts []data.LoginThrottle `json:"lockouts"`
+	}
+	res.decode(t, &listed)
+	if len(listed.Lockouts) != 2 {
+		t.Errorf("got lockouts %+v, want 2", listed.Lockouts)
+	}
+
+	path := fmt.Sprintf("/v1/admin/users/public/%d/unlock", applicant.ID)
+	if res := ts.do(t, http.MethodPost, path, session, nil); res.status != http.StatusOK {
+		t.Fatalf("got status %d unlocking, want 200: %s", res.status, res.body)
+	}
+	if res := ts.do(t, http.MethodPost, path, session, nil); res.status != http.StatusConflict {
+		t.Errorf("got status %d unlocking an account which isn't locked, want 409", res.status)
+	}
+	var actor int64
+	err = app.db.QueryRow("SELECT actor_admin_id FROM audit_events WHERE event = $1 AND public_user_id = $2", data.AuditAccountUnlocked, applicant.ID).Scan(&actor)
+	if err != nil || actor != admin.ID {
+		t.Errorf("got actor %d (%v), want the admin who unlocked it", actor, err)
+	}
+
+	if res := login(t, ts, applicant.Email, "Legacy-Pass-1"); res.status != http.StatusCreated {
+		t.Errorf("got status %d after the unlock, want 201", res.status)
+	}
+
+	res = ts.do(t, http.MethodPost, "/v1/admin/users/robot/1/unlock", session, nil)
+	if res.status != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown kind of account, want 404", res.status)
+	}
+}
+
+func TestLoginProgressiveDelay(t *testing.T) {
+	cfg := testConfig()
+	cfg.login.delayAfter = 1
+	cfg.login.delay = time.Hour
+	cfg.login.maxDelay = time.Hour
+	app := newTestApplication(t, testdb.Open(t), cfg)
+	ts := newTestServer(t, app)
+
+	insertUser(t, app, data.UserKindPublic, "applicant@example.com")
+
+	if res := login(t, ts, "applicant@example.com", "wrong-password"); res.status != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want 401", res.status)
+	}
+	res := login(t, ts, "applicant@example.com", "wrong-password")
+	if res.status != http.StatusTooManyRequests || res.header.Get("Retry-After") == "" {
+		t.Errorf("got status %d with Retry-After %q, want 429 and a wait", res.status, res.header.Get("Retry-After"))
+	}
+	if n := countAuditEvents(t, app, data.AuditAccountLocked); n != 0 {
+		t.Errorf("got %d lockout events for a delay, want none", n)
+	}
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	cfg := testConfig()
+	cfg.login.ipMaxFailures = 3
+	cfg.login.delayAfter = 100
+	app := newTestApplication(t, testdb.Open(t), cfg)
+	ts := newTestServer(t, app)
+
+	//spraying passwords across many accounts locks the client out
+	for i := 0; i < cfg.login.ipMaxFailures; i++ {
+		res := login(t, ts, fmt.Sprintf("user%d@example.com", i), "wrong-password")
+		if res.status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got status %d, want 401", i+1, res.status)
+		}
+	}
+	if res := login(t, ts, "someone-else@example.com", "wrong-password"); res.status != http.StatusTooManyRequests {
+		t.Errorf("got status %d from a locked out ip, want 429", res.status)
+	}
+	if n := countAuditEvents(t, app, data.AuditIPLocked); n != 1 {
+		t.Errorf("got %d ip lockout events, want 1", n)
+	}
+}
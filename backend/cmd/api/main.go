@@ -5,10 +5,28 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"net/netip"
 	"os"
+	"os/signal"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/jobs"
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+	"github.com/jinzhu/gorm/backend/internal/jwt"
+	"github.com/jinzhu/gorm/backend/internal/limiter"
+	"github.com/jinzhu/gorm/backend/internal/mailer"
+	"github.com/jinzhu/gorm/backend/internal/migrate"
+	"github.com/jinzhu/gorm/backend/internal/oidc"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+	"github.com/jinzhu/gorm/backend/migrations"
+	_ "github.com/lib/pq"
 )
 
 // version umber 1
@@ -18,16 +36,40 @@ const version = "0.1.0"
 type config struct {
 	port int
 	env  string // development | staging | production
-	db   struct {
+	log  struct {
+		level       string // debug | info | warn | error | fatal | off
+		stdoutLevel string //minimum level written to stdout
+		//optional rotating log file, for servers without a log collector
+		file           string
+		fileLevel      string //minimum level written to the file
+		fileMaxSize    int    //megabytes
+		fileMaxAge     time.Duration
+		fileMaxBackups int
+		fileCompress   bool
+		//redaction of sensitive values, extending the jsonlog defaults
+		redact         bool
+		redactKeys     []string
+		redactPatterns []string
+	}
+	db struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		//schema version the migrations must be at for the api to be ready
+		schemaVersion  int64
+		migrateOnStart bool
 	}
 	limiter struct {
 		rps     float64 //requests per second
 		burst   int     //how many request an the intial momment
 		enabled bool    //rate limiting toggle
+		store   string  //memory | postgres
+		//limits for authenticated users, anonymous users are limited by ip
+		userRPS   float64
+		userBurst int
+		//limits for individual routes, applied on top of the limits above
+		routes []routeLimit
 	}
 	smtp struct {
 		host     string
@@ -37,51 +79,334 @@ type config struct {
 		sender   string
 	}
 	cors struct {
-		trustedOrigins []string
+		trustedOrigins   []string //exact origins, "*" or wildcard subdomains like https://*.example.com
+		allowedMethods   []string
+		allowedHeaders   []string
+		exposedHeaders   []string
+		maxAge           time.Duration //how long browsers may cache a preflight response
+		allowCredentials bool
+	}
+	jobs struct {
+		workers   int //jobs running at once
+		queueSize int //jobs waiting for a worker
+	}
+	outbox struct {
+		workers      int           //senders the send-emails job uses
+		pollInterval time.Duration //how often idle workers look for due emails
+		maxAttempts  int           //attempts before an email is dead-lettered
+		backoff      time.Duration //wait after the first failed attempt, doubled after each one
+		maxBackoff   time.Duration
+	}
+	login struct {
+		maxFailures   int           //failed logins to an account before it is locked out
+		ipMaxFailures int           //failed logins from a client ip before it is locked out
+		delayAfter    int           //failed logins to an account before each further attempt has to wait
+		delay         time.Duration //the first wait, doubled after each further failure
+		maxDelay      time.Duration
+		lockout       time.Duration //how long an account or client ip stays locked out
+		window        time.Duration //failures older than this are forgotten
+	}
+	sessions struct {
+		flushInterval time.Duration //how often the sessions' last use is saved
+	}
+	auth struct {
+		mode string //opaque tokens looked up on every request, or jwt access tokens with refresh tokens
+	}
+	jwt struct {
+		issuer     string
+		accessTTL  time.Duration
+		refreshTTL time.Duration //refresh tokens expire after going this long unused
+		keys       []jwtKeyFile  //every key tokens may be signed with, old ones stay until their tokens expire
+		signingKey string        //the id of the key new tokens are signed with
+	}
+	oidc struct {
+		issuer           string //the directory staff log in with, single sign-on is off when empty
+		clientID         string
+		clientSecret     string
+		redirectURL      string //the frontend page the directory sends the browser back to
+		scopes           []string
+		groupsClaim      string             //the ID token claim listing the user's groups
+		groupPermissions []groupPermissions //the permissions each group grants
+		mfaValues        []string           //the amr or acr values which show the directory checked a second factor
+	}
+	apiKeys struct {
+		permissions []string //the only permissions API keys may be granted, handlers acting as a staff member need a person
+	}
+	twoFactor struct {
+		issuer              string   //the name authenticator apps show next to the code
+		requiredPermissions []string //holding any of these requires two-factor authentication
+	}
+	healthcheck struct {
+		timeout    time.Duration //how long each dependency probe may take
+		smtp       bool          //probe the mail server during readiness checks
+		drainDelay time.Duration //how long readiness reports a shutdown before the listeners close
 	}
+	tls tlsConfig
+	//proxies whose forwarding headers we believe when resolving the client ip
+	trustedProxies     []netip.Prefix
+	trustedProxyHeader string //the header they add, x-forwarded-for or forwarded
+}
+
+// tlsConfig - native tls serving, used when a certificate and key are configured
+type tlsConfig struct {
+	certFile       string
+	keyFile        string
+	redirectAddr   string        //address of the optional http -> https redirect listener
+	hstsMaxAge     time.Duration //how long browsers stick to https
+	hstsSubdomains bool          //whether every subdomain is held to https as well
+}
+
+// enabled() - reports if the server should be serving https
+func (t tlsConfig) enabled() bool {
+	return t.certFile != "" && t.keyFile != ""
 }
 
 // dependency injection
 type application struct {
-	config config
-	logger *jsonlog.logger
-	models data.models
-	mailer mailer.mailer
-	wg     sync.waitGroup
+	config       config
+	logger       *jsonlog.Logger
+	db           *sql.DB
+	limiter      limiter.Store
+	models       data.Models
+	mailer       mailer.Mailer
+	jobs         *jobs.Runner
+	sessions     *sessionActivity
+	apiKeys      *apiKeyActivity
+	jwtKeys      *jwt.KeySet    //nil unless the auth mode is jwt
+	sso          *oidc.Provider //nil unless single sign-on is configured
+	shuttingDown atomic.Bool    //set once serve() begins a graceful shutdown
 }
 
 func main() {
 	var cfg config
 
+	//every flag can also be set in the config file or through a BIOAFF_* environment variable
+	flag.String("config", "", "Path to a YAML config file (or BIOAFF_CONFIG)")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration and exit")
+
 	//flags for webserver
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment(development | staging | production)")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv(), "PostgreSQL DSN")
+
+	//flags for the logger
+	flag.StringVar(&cfg.log.level, "log-level", "info", "Minimum log level (debug | info | warn | error | fatal | off)")
+	flag.StringVar(&cfg.log.stdoutLevel, "log-stdout-level", "debug", "Minimum log level written to stdout")
+	flag.StringVar(&cfg.log.file, "log-file", "", "Log file path, logs only go to stdout when empty")
+	flag.StringVar(&cfg.log.fileLevel, "log-file-level", "debug", "Minimum log level written to the log file")
+	flag.IntVar(&cfg.log.fileMaxSize, "log-file-max-size", 100, "Log file size in megabytes before it is rotated")
+	flag.DurationVar(&cfg.log.fileMaxAge, "log-file-max-age", 24*time.Hour, "Log file age before it is rotated")
+	flag.IntVar(&cfg.log.fileMaxBackups, "log-file-max-backups", 7, "Number of rotated log files to keep")
+	flag.BoolVar(&cfg.log.fileCompress, "log-file-compress", true, "Gzip rotated log files")
+	flag.BoolVar(&cfg.log.redact, "log-redact", true, "Redact sensitive values from the logs")
+	flag.Func("log-redact-keys", "Extra property keys to redact from the logs (space separated)", func(val string) error {
+		cfg.log.redactKeys = strings.Fields(val)
+		return nil
+	})
+	flag.Func("log-redact-pattern", "Extra regular expression to redact from the logs (repeatable)", func(val string) error {
+		cfg.log.redactPatterns = append(cfg.log.redactPatterns, val)
+		return nil
+	})
+
+	//flags for the database
+	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idel connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.Int64Var(&cfg.db.schemaVersion, "db-schema-version", 0, "PostgreSQL schema version required for readiness, 0 for the newest embedded migration")
+	flag.BoolVar(&cfg.db.migrateOnStart, "migrate-on-start", false, "Apply pending schema migrations before starting the server")
 
 	//flags for the rate limiter
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum request per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Rate limiter enables")
+	flag.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Rate limiter store (memory | postgres)")
+	flag.Float64Var(&cfg.limiter.userRPS, "limiter-user-rps", 4, "Rate limiter maximum request per second for authenticated users")
+	flag.IntVar(&cfg.limiter.userBurst, "limiter-user-burst", 8, "Rate limiter maximum burst for authenticated users")
+	flag.Func("limiter-route", "Rate limit for a single route as \"[METHOD] /path=rps:burst\" (repeatable)", func(val string) error {
+		rl, err := parseRouteLimit(val)
+		if err != nil {
+			return err
+		}
+		cfg.limiter.routes = append(cfg.limiter.routes, rl)
+		return nil
+	})
 
 	//flags for the mailer
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smpt.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", " ", "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", " ", "SMPT sender")
 
+	//flags for the job runner
+	flag.IntVar(&cfg.jobs.workers, "jobs-workers", 4, "Background jobs running at once")
+	flag.IntVar(&cfg.jobs.queueSize, "jobs-queue-size", 100, "Background jobs waiting for a worker before more are refused")
+
+	//flags for the email outbox
+	flag.IntVar(&cfg.outbox.workers, "outbox-workers", 2, "Emails the send-emails job sends at once")
+	flag.DurationVar(&cfg.outbox.pollInterval, "outbox-poll-interval", 5*time.Second, "How often the send-emails job looks for due emails")
+	flag.IntVar(&cfg.outbox.maxAttempts, "outbox-max-attempts", 8, "Attempts to send an email before it is dead-lettered")
+	flag.DurationVar(&cfg.outbox.backoff, "outbox-backoff", 30*time.Second, "Wait after an email's first failed attempt, doubled after each further one")
+	flag.DurationVar(&cfg.outbox.maxBackoff, "outbox-max-backoff", time.Hour, "Longest wait between attempts to send an email")
+
+	//flags for login throttling
+	flag.IntVar(&cfg.login.maxFailures, "login-max-failures", 10, "Failed logins to an account before it is locked out")
+	flag.IntVar(&cfg.login.ipMaxFailures, "login-ip-max-failures", 100, "Failed logins from a client IP before it is locked out")
+	flag.IntVar(&cfg.login.delayAfter, "login-delay-after", 3, "Failed logins to an account before each further attempt has to wait")
+	flag.DurationVar(&cfg.login.delay, "login-delay", time.Second, "Wait before the next login after the first delayed failure, doubled after each further one")
+	flag.DurationVar(&cfg.login.maxDelay, "login-max-delay", 30*time.Second, "Longest wait between failed logins to an account")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", 15*time.Minute, "How long an account or client IP stays locked out")
+	flag.DurationVar(&cfg.login.window, "login-window", 15*time.Minute, "Failed logins older than this are forgotten")
+
+	//flags for sessions
+	flag.DurationVar(&cfg.sessions.flushInterval, "sessions-flush-interval", time.Minute, "How often the last use of each session is saved")
+
+	//flags for the auth mode
+	flag.StringVar(&cfg.auth.mode, "auth-mode", authModeOpaque, "How sessions are authenticated (opaque | jwt)")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "bioaff", "Issuer of the JWT access tokens")
+	flag.DurationVar(&cfg.jwt.accessTTL, "jwt-access-ttl", 15*time.Minute, "How long a JWT access token is valid")
+	flag.DurationVar(&cfg.jwt.refreshTTL, "jwt-refresh-ttl", 30*24*time.Hour, "How long a refresh token is valid for if it isn't used")
+	flag.Func("jwt-key", "Key to sign or verify JWT access tokens as \"id:EdDSA|HS256:path\" (repeatable)", func(val string) error {
+		k, err := parseJWTKeyFile(val)
+		if err != nil {
+			return err
+		}
+		cfg.jwt.keys = append(cfg.jwt.keys, k)
+		return nil
+	})
+	flag.StringVar(&cfg.jwt.signingKey, "jwt-signing-key", "", "Id of the jwt-key new access tokens are signed with")
+
+	//flags for single sign-on
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "Issuer URL of the OpenID Connect provider staff log in with, single sign-on is off when empty")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "Client id registered with the OpenID Connect provider")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "Client secret registered with the OpenID Connect provider")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "", "Frontend URL the OpenID Connect provider sends the browser back to")
+	cfg.oidc.scopes = []string{"openid", "email", "profile"}
+	flag.Func("oidc-scopes", "Scopes asked of the OpenID Connect provider (space separated)", func(val string) error {
+		cfg.oidc.scopes = strings.Fields(val)
+		return nil
+	})
+	flag.StringVar(&cfg.oidc.groupsClaim, "oidc-groups-claim", "groups", "ID token claim listing the groups of the user")
+	flag.Func("oidc-group-permissions", "Permissions granted to a directory group as \"group=permission ...\" (repeatable)", func(val string) error {
+		g, err := parseGroupPermissions(val)
+		if err != nil {
+			return err
+		}
+		cfg.oidc.groupPermissions = append(cfg.oidc.groupPermissions, g)
+		return nil
+	})
+	flag.Func("oidc-mfa-values", "ID token amr or acr values which show the provider checked a second factor (space separated)", func(val string) error {
+		cfg.oidc.mfaValues = strings.Fields(val)
+		return nil
+	})
+
+	//flags for API keys
+	cfg.apiKeys.permissions = []string{"forms:read"}
+	flag.Func("api-key-permissions", "Permissions API keys may be granted (space separated)", func(val string) error {
+		cfg.apiKeys.permissions = strings.Fields(val)
+		return nil
+	})
+
+	//flags for two-factor authentication
+	flag.StringVar(&cfg.twoFactor.issuer, "two-factor-issuer", "BioAff", "Issuer shown by authenticator apps")
+	cfg.twoFactor.requiredPermissions = []string{"forms:verify"}
+	flag.Func("two-factor-required-permissions", "Permissions which can only be used with two-factor authentication turned on (space separated)", func(val string) error {
+		cfg.twoFactor.requiredPermissions = strings.Fields(val)
+		return nil
+	})
+
+	//flags for the readiness probe
+	flag.DurationVar(&cfg.healthcheck.timeout, "healthcheck-timeout", 2*time.Second, "Timeout for each readiness dependency probe")
+	flag.BoolVar(&cfg.healthcheck.smtp, "healthcheck-smtp", false, "Probe the SMTP server during readiness checks")
+	flag.DurationVar(&cfg.healthcheck.drainDelay, "healthcheck-drain-delay", 15*time.Second, "How long readiness probes report a shutdown before the server stops accepting connections")
+
 	//cors' flag
 	flag.Func("cors-trusted-origin", "Trusted CORS origin (space separated)", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
+	cfg.cors.allowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	flag.Func("cors-allowed-methods", "CORS methods allowed in preflight requests (space separated)", func(val string) error {
+		cfg.cors.allowedMethods = strings.Fields(strings.ToUpper(val))
+		return nil
+	})
+	cfg.cors.allowedHeaders = []string{"Authorization", "Content-Type"}
+	flag.Func("cors-allowed-headers", "CORS headers allowed in preflight requests (space separated)", func(val string) error {
+		cfg.cors.allowedHeaders = strings.Fields(val)
+		return nil
+	})
+	cfg.cors.exposedHeaders = []string{"X-Request-ID", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"}
+	flag.Func("cors-exposed-headers", "Response headers exposed to CORS requests (space separated)", func(val string) error {
+		cfg.cors.exposedHeaders = strings.Fields(val)
+		return nil
+	})
+	flag.DurationVar(&cfg.cors.maxAge, "cors-max-age", time.Hour, "How long browsers may cache CORS preflight responses")
+	flag.BoolVar(&cfg.cors.allowCredentials, "cors-allow-credentials", false, "Allow credentialed CORS requests")
+
+	//flags for tls
+	flag.StringVar(&cfg.tls.certFile, "tls-cert", "", "TLS certificate file, serves https together with -tls-key")
+	flag.StringVar(&cfg.tls.keyFile, "tls-key", "", "TLS private key file")
+	flag.StringVar(&cfg.tls.redirectAddr, "tls-redirect-addr", "", "Address of a listener redirecting http to https, e.g. :80")
+	flag.DurationVar(&cfg.tls.hstsMaxAge, "tls-hsts-max-age", 365*24*time.Hour, "Strict-Transport-Security max-age sent over https")
+	flag.BoolVar(&cfg.tls.hstsSubdomains, "tls-hsts-include-subdomains", false, "Hold every subdomain to https as well with Strict-Transport-Security")
+
+	//trusted proxies' flag
+	flag.Func("trusted-proxies", "Trusted proxy CIDR ranges (space separated)", func(val string) error {
+		prefixes, err := parseTrustedProxies(val)
+		if err != nil {
+			return err
+		}
+		cfg.trustedProxies = prefixes
+		return nil
+	})
+	flag.StringVar(&cfg.trustedProxyHeader, "trusted-proxy-header", proxyHeaderXForwardedFor, "Forwarding header the trusted proxies add (x-forwarded-for | forwarded)")
+
+	//merge the config file, the environment and the command line
+	err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	//check the merged configuration before starting anything with it
+	v := validator.New()
+	if validateConfig(v, cfg); !v.Valid() {
+		for key, message := range v.Errors {
+			fmt.Fprintf(os.Stderr, "invalid configuration: %s %s\n", key, message)
+		}
+		os.Exit(2)
+	}
+
+	if *printConfig {
+		js, _ := json.MarshalIndent(cfg.redacted(), "", "\t")
+		fmt.Println(string(js))
+		return
+	}
 
-	flag.Parse()
+	//creating the logger instance, the level can be changed later through the admin api
+	logger, logFile, err := openLogger(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logger.PrintInfo("effective configuration", cfg.redacted())
 
-	//creating the logger instance
-	logger := jsonlog.New(os.stdout, jsonlog.Levelinfo)
+	//reopen the log file on SIGHUP, so it can also be rotated by an external tool
+	if logFile != nil {
+		defer logFile.Close()
+		go func() {
+			hangup := make(chan os.Signal, 1)
+			signal.Notify(hangup, syscall.SIGHUP)
+			for range hangup {
+				err := logFile.Reopen()
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					continue
+				}
+				logger.PrintInfo("log file reopened", map[string]interface{}{"file": cfg.log.file})
+			}
+		}()
+	}
 
 	//create the connecction pool
 	db, err := openDB(cfg)
@@ -94,21 +419,116 @@ func main() {
 	//loging the successful connection
 	logger.PrintInfo("database connection pool established", nil)
 
+	//a command such as "migrate up" runs instead of the server
+	if flag.NArg() > 0 {
+		err = runCommand(cfg, db, logger, flag.Args())
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+
+	//the schema migrations are embedded in the binary
+	schema, err := migrate.New(db, migrations.Schema, schemaMigrationsTable)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	if cfg.db.migrateOnStart {
+		applied, err := schema.Up(context.Background(), 0)
+		logMigrations(logger, "applied migration", applied)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+	if cfg.db.schemaVersion == 0 {
+		cfg.db.schemaVersion = schema.Latest()
+	}
+
+	//choosing where the rate limiter keeps its buckets
+	var store limiter.Store
+	switch cfg.limiter.store {
+	case "memory":
+		store = limiter.NewMemoryStore()
+	case "postgres":
+		store = limiter.NewPostgresStore(db)
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown rate limiter store %q", cfg.limiter.store), nil)
+	}
+
 	//instance of app struct
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:   cfg,
+		logger:   logger,
+		db:       db,
+		limiter:  store,
+		models:   data.NewModels(db),
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		jobs:     jobs.New(logger, cfg.jobs.workers, cfg.jobs.queueSize),
+		sessions: newSessionActivity(),
+		apiKeys:  newAPIKeyActivity(),
+		sso:      newSSOProvider(cfg, logger),
+	}
+	err = app.registerJobs()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	if cfg.auth.mode == authModeJWT {
+		app.jwtKeys, err = loadJWTKeys(cfg)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
 	}
 
 	//call app server() to start the server
-	err = app.server()
+	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
 }
 
+// openLogger() - creates the logger writing to stdout and, when configured, a rotating log file
+func openLogger(cfg config) (*jsonlog.Logger, *jsonlog.RotatingFile, error) {
+	level, err := jsonlog.ParseLevel(cfg.log.level)
+	if err != nil {
+		return nil, nil, err
+	}
+	stdoutLevel, err := jsonlog.ParseLevel(cfg.log.stdoutLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+	sinks := []jsonlog.Sink{{Out: os.Stdout, MinLevel: stdoutLevel}}
+
+	//the default rules are extended with our own keys and patterns
+	var redactor *jsonlog.Redactor
+	if cfg.log.redact {
+		patterns, err := jsonlog.ParseRedactionPatterns(cfg.log.redactPatterns)
+		if err != nil {
+			return nil, nil, err
+		}
+		patterns = append(patterns, jsonlog.DefaultRedactionPatterns...)
+		redactor = jsonlog.NewRedactor(append(jsonlog.DefaultRedactedKeys, cfg.log.redactKeys...), patterns...)
+	}
+
+	if cfg.log.file == "" {
+		logger := jsonlog.NewMulti(level, sinks...)
+		logger.SetRedactor(redactor)
+		return logger, nil, nil
+	}
+
+	fileLevel, err := jsonlog.ParseLevel(cfg.log.fileLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := jsonlog.NewRotatingFile(cfg.log.file, int64(cfg.log.fileMaxSize)*1024*1024, cfg.log.fileMaxAge, cfg.log.fileMaxBackups, cfg.log.fileCompress)
+	if err != nil {
+		return nil, nil, err
+	}
+	sinks = append(sinks, jsonlog.Sink{Out: file, MinLevel: fileLevel})
+	logger := jsonlog.NewMulti(level, sinks...)
+	logger.SetRedactor(redactor)
+	return logger, file, nil
+}
+
 // OpenDB() function returns a *sql.DB connection pool
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
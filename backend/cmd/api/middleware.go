@@ -3,17 +3,112 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"net"
+	"math"
 	"net/http"
-	"sync"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/jwt"
+	"github.com/jinzhu/gorm/backend/internal/limiter"
 	"github.com/jinzhu/gorm/backend/internal/validator"
-	"golang.org/x/time/rate"
 )
 
+// requestIDRX - request ids we accept from clients and proxies, anything else is replaced
+var requestIDRX = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,128}$`)
+
+// requestID() - propagates the X-Request-ID sent with the request or assigns a new one,
+// echoes it in the response and adds it to the request context
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestInfo(r, &requestInfo{id: id})
+		r = app.contextSetLogger(r, app.logger.With(map[string]interface{}{"request_id": id}))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newRequestID() - generates a random 128 bit request id
+func newRequestID() string {
+	b := make([]byte, 16)
+	//crypto/rand only fails when the operating system can't provide randomness
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// responseRecorder - wraps a http.ResponseWriter to remember the status code and size of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// Unwrap() - lets http.ResponseController reach the original writer
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// logRequest() - writes one access log line for every request once it has been served
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		properties := map[string]interface{}{
+			"request_method": r.Method,
+			"request_url":    r.URL.String(),
+			"status":         rw.status,
+			"bytes":          rw.bytes,
+			"duration":       time.Since(start),
+		}
+
+		//authenticate() fills in the user id, or the API key id, for authenticated requests
+		if info := app.contextGetRequestInfo(r); info != nil && info.userID != 0 {
+			properties["user_id"] = info.userID
+		}
+		if info := app.contextGetRequestInfo(r); info != nil && info.apiKeyID != 0 {
+			properties["api_key_id"] = info.apiKeyID
+		}
+
+		//the request-scoped logger already carries the request id and client ip
+		app.contextGetLogger(r).PrintInfo("request completed", properties)
+	})
+}
+
 // recoverPanic tries to return to a normal state otherwise,
 // it starts the gradual shutdown
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -28,57 +123,201 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	//Create a client type
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-
-	//Launch a background goroutine that removes old entries
-	//from the clients map once every minute
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			//Lock before starting to clean
-			mu.Lock()
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
+// realIP() - resolves the client's ip address, looking through our trusted proxies,
+// and stores it in the request context for everything further down the chain
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, err := app.resolveClientIP(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		r = app.contextSetClientIP(r, ip)
+		r = app.contextSetLogger(r, app.contextGetLogger(r).With(map[string]interface{}{"client_ip": ip.String()}))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeLimit - a rate limit that only applies to the requests matching a route
+type routeLimit struct {
+	method  string //empty matches every method
+	pattern string //httprouter style path, e.g. /v1/forms/:id
+	limit   limiter.Limit
+}
+
+// parseRouteLimit() - reads a route limit written as "[METHOD] /path=rps:burst"
+func parseRouteLimit(val string) (routeLimit, error) {
+	var rl routeLimit
+
+	route, values, found := strings.Cut(val, "=")
+	if !found {
+		return rl, fmt.Errorf("route limit %q must be written as [METHOD] /path=rps:burst", val)
+	}
+
+	//the method is optional
+	fields := strings.Fields(route)
+	switch len(fields) {
+	case 1:
+		rl.pattern = fields[0]
+	case 2:
+		rl.method, rl.pattern = strings.ToUpper(fields[0]), fields[1]
+	default:
+		return rl, fmt.Errorf("route limit %q must be written as [METHOD] /path=rps:burst", val)
+	}
+	if !strings.HasPrefix(rl.pattern, "/") {
+		return rl, fmt.Errorf("route limit %q must have a path starting with /", val)
+	}
+
+	rps, burst, found := strings.Cut(values, ":")
+	if !found {
+		return rl, fmt.Errorf("route limit %q must be written as [METHOD] /path=rps:burst", val)
+	}
+	var err error
+	rl.limit.Rate, err = strconv.ParseFloat(rps, 64)
+	if err != nil || rl.limit.Rate <= 0 {
+		return rl, fmt.Errorf("route limit %q must have a positive rps", val)
+	}
+	rl.limit.Burst, err = strconv.Atoi(burst)
+	if err != nil || rl.limit.Burst < 1 {
+		return rl, fmt.Errorf("route limit %q must have a burst of at least 1", val)
+	}
+	return rl, nil
+}
+
+// matches() - checks if a request is covered by the route limit
+func (rl routeLimit) matches(r *http.Request) bool {
+	if rl.method != "" && rl.method != r.Method {
+		return false
+	}
+
+	patternParts := strings.Split(strings.Trim(rl.pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	for i, part := range patternParts {
+		//a catch-all parameter matches the remainder of the path
+		if strings.HasPrefix(part, "*") {
+			return len(pathParts) >= i
+		}
+		if i >= len(pathParts) {
+			return false
+		}
+		if !strings.HasPrefix(part, ":") && part != pathParts[i] {
+			return false
+		}
+	}
+	return len(patternParts) == len(pathParts)
+}
+
+// moreRestrictive() - reports if result a should be reported to the client instead of result b
+func moreRestrictive(a, b limiter.Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+// rateLimitIP() - limits requests per ip, it runs before authenticate so guessing tokens and API keys is
+// limited like everything else and every guess doesn't cost a database lookup
+func (app *application) rateLimitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.limiter.enabled {
+			buckets := map[string]limiter.Limit{
+				"ip:" + app.contextGetClientIP(r).String(): {Rate: app.config.limiter.rps, Burst: app.config.limiter.burst},
+			}
+			result, checked := app.takeTokens(r, buckets)
+			if checked {
+				if !result.Allowed {
+					app.rateLimitExceeded(w, r, result)
+					return
 				}
+				//rateLimit reports this result unless one of its own buckets is more restrictive
+				r = app.contextSetRateLimit(r, result)
 			}
-			mu.Unlock()
 		}
-	}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimit() - limits requests per authenticated user or API key, along with any limits configured for the
+// requested route, anonymous requests were already limited by ip in rateLimitIP
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	//old buckets are removed by the limiter-cleanup job
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if app.config.limiter.enabled {
-			//Get the ip address of the request
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
+			identity := "ip:" + app.contextGetClientIP(r).String()
+			buckets := make(map[string]limiter.Limit)
+			user := app.contextGetUser(r)
+			if !user.IsAnonymous() {
+				identity = fmt.Sprintf("user:%s:%d", user.Kind, user.ID)
+				buckets[identity] = limiter.Limit{Rate: app.config.limiter.userRPS, Burst: app.config.limiter.userBurst}
+			}
+			//API keys carry their own limit
+			if key := app.contextGetAPIKey(r); key != nil {
+				identity = fmt.Sprintf("api_key:%d", key.ID)
+				buckets = map[string]limiter.Limit{identity: {Rate: key.RateLimitRPS, Burst: key.RateLimitBurst}}
 			}
-			//Update the last seen time of the client
-			clients[ip].lastSeen = time.Now()
 
-			//check if request allowed
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
+			for _, rl := range app.config.limiter.routes {
+				if rl.matches(r) {
+					buckets[fmt.Sprintf("route:%s %s:%s", rl.method, rl.pattern, identity)] = rl.limit
+				}
+			}
+
+			result, checked := app.takeTokens(r, buckets)
+			if ipResult, ok := app.contextGetRateLimit(r); ok && (!checked || moreRestrictive(ipResult, result)) {
+				result, checked = ipResult, true
+			}
+
+			if checked {
+				if !result.Allowed {
+					app.rateLimitExceeded(w, r, result)
+					return
+				}
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+				w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+				w.Header().Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.Reset), 10))
 			}
-			mu.Unlock()
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// takeTokens() - takes a token from every bucket and returns the most restrictive result,
+// checked is false when the store couldn't be asked about any of them
+func (app *application) takeTokens(r *http.Request, buckets map[string]limiter.Limit) (result limiter.Result, checked bool) {
+	for key, limit := range buckets {
+		res, err := app.limiter.Allow(r.Context(), key, limit)
+		if err != nil {
+			//an unavailable store shouldn't take the whole api down with it
+			app.logError(r, err)
+			continue
+		}
+		if !checked || moreRestrictive(res, result) {
+			result = res
+			checked = true
+		}
+	}
+	return result, checked
+}
+
+// rateLimitExceeded() - sends the 429 along with the headers telling the client when to come back
+func (app *application) rateLimitExceeded(w http.ResponseWriter, r *http.Request, result limiter.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.Reset), 10))
+	w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
+	app.rateLimitExceededResponse(w, r)
+}
+
+// ceilSeconds() - rounds a duration up to whole seconds for the rate limit headers
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
 // Authentication
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -93,41 +332,96 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		//if no authorization found then we will create an anonymous user
 		if authorizationHeader == "" {
-			r = app.contextSetUser(r, data.anonymousUser)
+			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		//check if the provided authorization header is in the right format
-		headerParts := strings.Spit(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || (headerParts[0] != "Bearer" && headerParts[0] != apiKeyScheme) {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
-		//Extract the token
-		token := headerParts[1]
+		//machines call the api with keys which belong to no account, the request stays anonymous
+		//and the key's own permissions are checked instead
+		if headerParts[0] == apiKeyScheme {
+			key, err := app.models.APIKeys.GetForKey(headerParts[1])
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			r = app.contextSetUser(r, data.AnonymousUser)
+			r = app.contextSetAPIKey(r, key)
+
+			//the key's last use is saved with the sessions' next batch
+			app.apiKeys.touch(key.ID, app.contextGetClientIP(r).String())
 
-		//Validate the token
-		v := validator.New()
-		if data.ValidateTokenPlaintext(v, token); !v.valid() {
-			app.invalidCredentialsResponse(w, r)
+			if info := app.contextGetRequestInfo(r); info != nil {
+				info.apiKeyID = key.ID
+			}
+			r = app.contextSetLogger(r, app.contextGetLogger(r).With(map[string]interface{}{"api_key_id": key.ID, "api_key_prefix": key.Prefix}))
+
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		//Retrive details about the user
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidCredentialsResponse(w, r)
-			default:
-				app.serverErrorResponse(w, r, err)
+		//Extract the token
+		token := headerParts[1]
+
+		var user *data.User
+		var err error
+		if app.jwtKeys != nil && jwt.LooksLikeJWT(token) {
+			//JWT access tokens are checked without going to the database
+			var sessionID int64
+			user, sessionID, err = app.userFromAccessToken(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
 			}
-			return
+			r = app.contextSetUser(r, user)
+			r = app.contextSetSession(r, sessionRef{id: sessionID})
+		} else {
+			//opaque tokens are still accepted in the jwt auth mode, so switching to it signs nobody out
+
+			//Validate the token
+			v := validator.New()
+			if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			//Retrive details about the user
+			user, err = app.models.Users.GetForToken(data.ScopeAuthentication, token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			//Add the user infromation to the request context
+			r = app.contextSetUser(r, user)
+
+			//the session's last use is saved with the next batch, not on every request
+			hash := data.HashToken(token)
+			app.sessions.touch(hash, app.contextGetClientIP(r).String())
+			r = app.contextSetSession(r, sessionRef{hash: hash})
+		}
+
+		//let the access log and the request's logger know who made the request
+		if info := app.contextGetRequestInfo(r); info != nil {
+			info.userID = user.ID
 		}
-		//Add the user infromation to the request context
-		r = app.contextSetUser(r, user)
+		r = app.contextSetLogger(r, app.contextGetLogger(r).With(map[string]interface{}{"user_id": user.ID, "user_kind": user.Kind}))
 
 		//call the next handler
 		next.ServeHTTP(w, r)
@@ -136,7 +430,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 // Check for activated user
 func (app *application) requireAutheniticatedUser(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		//get the user
 		user := app.contextGetUser(r)
 
@@ -157,7 +451,7 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 		user := app.contextGetUser(r)
 
 		//check if user is activated
-		if !user.activated {
+		if !user.Activated {
 			app.inactiveAccountResponse(w, r)
 			return
 		}
@@ -166,46 +460,146 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAutheniticatedUser(fn)
 }
 
-// Check for activated user
+// Check for a permission, held by the activated user or by the API key the request was made with
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
-	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	forUser := app.requireActivatedUser(app.requireUserPermission(code, next))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//API keys hold their own permissions, there is no account behind them to check
+		//the allow-list is checked too so narrowing it takes the removed permissions from existing keys
+		if key := app.contextGetAPIKey(r); key != nil {
+			if !key.Permissions.Include(code) || !validator.In(code, app.config.apiKeys.permissions...) {
+				app.notPermittedResponse(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		forUser.ServeHTTP(w, r)
+	})
+}
+
+// requireUserPermission() - checks the user holds the permission, along with two-factor when the policy requires it
+func (app *application) requireUserPermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		//Get the user
 		user := app.contextGetUser(r)
 
 		//get the permission slice for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.models.Permissions.GetAllForUser(user)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
 		//check for the permission
-		if !permission.Include(code) {
+		if !permissions.Include(code) {
 			app.notPermittedResponse(w, r)
 			return
 		}
+
+		//holding a permission the policy guards means no permission can be used until two-factor is on
+		if app.requiresTwoFactor(permissions) {
+			enabled, err := app.models.TOTP.Enabled(user)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			//staff logging in with the directory get their second factor from it, when it said it checked one
+			//as the session was started
+			if !enabled {
+				session := app.contextGetSession(r)
+				enabled, err = app.models.Sessions.MFA(session.hash, session.id)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+			if !enabled {
+				app.twoFactorRequiredResponse(w, r)
+				return
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
-	return app.requireActivatedUser(fn)
 }
 
 // Enable CORS
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		//add the "Vary-Origin" headers
-		w.Header().add("Vary", "Origin")
+		//add the "Vary" headers, the response changes with the origin and the preflight request headers
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
 
 		//Get the value of the request's origin header
 		origin := r.Header.Get("Origin")
-		//check if origin header is present
-		if origin != "" {
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-					break
-				}
+		//check if origin header is present and trusted
+		if origin != "" && app.trustedOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if app.config.cors.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			//a preflight request is an OPTIONS request asking about the real request's method
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(app.config.cors.allowedMethods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(app.config.cors.allowedHeaders, ", "))
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(app.config.cors.maxAge.Seconds())))
+
+				//the preflight is answered here, it never reaches the router
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			//let the browser's scripts read our own response headers
+			if len(app.config.cors.exposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(app.config.cors.exposedHeaders, ", "))
 			}
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// trustedOrigin() - checks the origin against the trusted origins, which may be exact origins,
+// "*" for any origin, or wildcard subdomain patterns such as https://*.example.com
+func (app *application) trustedOrigin(origin string) bool {
+	for _, pattern := range app.config.cors.trustedOrigins {
+		switch {
+		case pattern == "*":
+			return true
+		case strings.EqualFold(origin, pattern):
+			return true
+		case strings.Contains(pattern, "://*."):
+			//everything before the wildcard is the scheme, everything after it the parent domain and port
+			prefix, suffix, _ := strings.Cut(pattern, "*")
+			if len(origin) <= len(prefix)+len(suffix) {
+				continue
+			}
+			if !strings.EqualFold(origin[:len(prefix)], prefix) || !strings.EqualFold(origin[len(origin)-len(suffix):], suffix) {
+				continue
+			}
+
+			//the subdomain part may only hold host name characters, so the pattern can't be escaped
+			subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+			if validSubdomain(subdomain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validSubdomain() - checks that a string is made up of dot separated host name labels
+func validSubdomain(subdomain string) bool {
+	for _, label := range strings.Split(subdomain, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+// BIOAFF/backend/cmd/api/middleware_test.go
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t, nil, testConfig())
+	ts := newTestServer(t, app)
+
+	res := ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil, "X-Request-ID", "abc-123")
+	if got := res.header.Get("X-Request-ID"); got != "abc-123" {
+		t.Errorf("got request id %q, want the one we sent", got)
+	}
+
+	//ids which could mess up the logs are replaced
+	res = ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil, "X-Request-ID", "bad id; DROP")
+	if got := res.header.Get("X-Request-ID"); got == "" || got == "bad id; DROP" {
+		t.Errorf("got request id %q, want a generated one", got)
+	}
+
+	//every request ends up in the access log with its id
+	var found bool
+	for _, entry := range app.logs.entries(t) {
+		properties, _ := entry["properties"].(map[string]interface{})
+		if entry["message"] == "request completed" && properties["request_id"] == "abc-123" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("no access log line for request abc-123")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := testConfig()
+	cfg.limiter.enabled = true
+	cfg.limiter.rps = 0.01
+	cfg.limiter.burst = 2
+	app := newTestApplication(t, nil, cfg)
+	ts := newTestServer(t, app)
+
+	for i := 0; i < cfg.limiter.burst; i++ {
+		res := ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+		if res.status != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want 200", i+1, res.status)
+		}
+		if res.header.Get("RateLimit-Limit") != "2" {
+			t.Errorf("got RateLimit-Limit %q, want 2", res.header.Get("RateLimit-Limit"))
+		}
+	}
+
+	res := ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+	if res.status != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want 429", res.status)
+	}
+	if res.header.Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+
+	//guessing tokens is limited by ip before authenticate gets to refuse them
+	app = newTestApplication(t, nil, cfg)
+	ts = newTestServer(t, app)
+	for i := 0; i < cfg.limiter.burst; i++ {
+		if res := ts.do(t, http.MethodGet, "/v1/healthcheck", "not-a-token", nil); res.status != http.StatusUnauthorized {
+			t.Fatalf("guess %d: got status %d, want 401", i+1, res.status)
+		}
+	}
+	if res := ts.do(t, http.MethodGet, "/v1/healthcheck", "not-a-token", nil); res.status != http.StatusTooManyRequests {
+		t.Errorf("got status %d for a guess after the burst, want 429", res.status)
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	cfg := testConfig()
+	cfg.cors.trustedOrigins = []string{"https://*.bioaff.test"}
+	app := newTestApplication(t, nil, cfg)
+	ts := newTestServer(t, app)
+
+	tests := []struct {
+		name       string
+		origin     string
+		wantOrigin string
+	}{
+		{"trusted", "https://portal.bioaff.test", "https://portal.bioaff.test"},
+		{"untrusted", "https://evil.test", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodOptions, "/v1/healthcheck", "", nil,
+				"Origin", tt.origin,
+				"Access-Control-Request-Method", http.MethodPut,
+			)
+			if got := res.header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tt.wantOrigin)
+			}
+			//trusted preflights are answered by the middleware itself
+			if tt.wantOrigin != "" && res.status != http.StatusNoContent {
+				t.Errorf("got status %d, want 204", res.status)
+			}
+		})
+	}
+}
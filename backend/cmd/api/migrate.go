@@ -0,0 +1,133 @@
+// BIOAFF/backend/cmd/api/migrate.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+	"github.com/jinzhu/gorm/backend/internal/migrate"
+	"github.com/jinzhu/gorm/backend/migrations"
+)
+
+// the tables recording how far the schema and the sample data have been migrated
+const (
+	schemaMigrationsTable = "schema_migrations"
+	seedMigrationsTable   = "seed_migrations"
+)
+
+// runCommand() - runs a subcommand given after the flags instead of starting the server
+//
+//	bioaff migrate up [N] | down N | status | force V
+//	bioaff seed
+func runCommand(cfg config, db *sql.DB, logger *jsonlog.Logger, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(db, logger, args[1:])
+	case "seed":
+		return runSeed(cfg, db, logger)
+	default:
+		return fmt.Errorf("unknown command %q, expected migrate or seed", args[0])
+	}
+}
+
+// runMigrate() - applies, rolls back, reports on or forces the schema migrations
+func runMigrate(db *sql.DB, logger *jsonlog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up [N] | down N | status | force V")
+	}
+
+	m, err := migrate.New(db, migrations.Schema, schemaMigrationsTable)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		//without a count every pending migration is applied
+		n := 0
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return errors.New("usage: migrate up [N], N must be a positive number")
+			}
+		}
+		applied, err := m.Up(ctx, n)
+		logMigrations(logger, "applied migration", applied)
+		return err
+
+	case "down":
+		//rolling everything back by accident would be a disaster, so the count is required
+		if len(args) < 2 {
+			return errors.New("usage: migrate down N")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return errors.New("usage: migrate down N, N must be a positive number")
+		}
+		reverted, err := m.Down(ctx, n)
+		logMigrations(logger, "reverted migration", reverted)
+		return err
+
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("version %d (dirty: %t, latest: %d)\n", status.Version, status.Dirty, m.Latest())
+		for _, migration := range status.Applied {
+			fmt.Printf("applied  %06d_%s\n", migration.Version, migration.Name)
+		}
+		for _, migration := range status.Pending {
+			fmt.Printf("pending  %06d_%s\n", migration.Version, migration.Name)
+		}
+		return nil
+
+	case "force":
+		if len(args) < 2 {
+			return errors.New("usage: migrate force V")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return errors.New("usage: migrate force V, V must be a version or 0 for none")
+		}
+		err = m.Force(ctx, version)
+		if err != nil {
+			return err
+		}
+		logger.PrintInfo("forced migration version", map[string]interface{}{"version": version})
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down, status or force", args[0])
+	}
+}
+
+// runSeed() - loads the sample data, which must never end up in a staging or production database
+func runSeed(cfg config, db *sql.DB, logger *jsonlog.Logger) error {
+	if cfg.env != "development" {
+		return fmt.Errorf("seed data can only be loaded in development, not %s", cfg.env)
+	}
+
+	m, err := migrate.New(db, migrations.Seed(), seedMigrationsTable)
+	if err != nil {
+		return err
+	}
+	applied, err := m.Up(context.Background(), 0)
+	logMigrations(logger, "applied seed", applied)
+	return err
+}
+
+// logMigrations() - logs one line per migration that was run
+func logMigrations(logger *jsonlog.Logger, message string, applied []migrate.Migration) {
+	for _, migration := range applied {
+		logger.PrintInfo(message, map[string]interface{}{
+			"version": migration.Version,
+			"name":    migration.Name,
+		})
+	}
+}
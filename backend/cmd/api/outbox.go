@@ -0,0 +1,121 @@
+// BIOAFF/backend/cmd/api/outbox.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// sendNextEmail() - sends the oldest due email, reports false when there was nothing to send
+func (app *application) sendNextEmail() (bool, error) {
+	return app.models.Outbox.Process(app.config.outbox.maxAttempts, app.outboxBackoff, func(email *data.Email) (err error) {
+		//a panicking template counts as a failed attempt instead of taking the worker down
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic sending email: %v", p)
+			}
+		}()
+
+		err = app.mailer.Send(email.Recipient, email.Template, email.Data)
+		if err != nil {
+			app.logger.PrintWarn("sending email failed", map[string]interface{}{
+				"email_id": email.ID,
+				"template": email.Template,
+				"attempts": email.Attempts,
+				"error":    err.Error(),
+			})
+		}
+		return err
+	})
+}
+
+// outboxBackoff() - how long to wait after the given number of failed attempts, doubling each time
+func (app *application) outboxBackoff(attempts int) time.Duration {
+	backoff := app.config.outbox.backoff
+	for i := 1; i < attempts && backoff < app.config.outbox.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > app.config.outbox.maxBackoff {
+		backoff = app.config.outbox.maxBackoff
+	}
+	return backoff
+}
+
+// listEmailsHandler() - the newest emails in the outbox, ?status=dead lists the ones that need re-sending
+func (app *application) listEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+	status := app.readString(qs, "status", "")
+	limit := app.readInt(qs, "limit", 50, v)
+	v.Check(status == "" || validator.In(status, data.EmailPending, data.EmailSending, data.EmailSent, data.EmailDead), "status", "must be pending, sending, sent or dead")
+	v.Check(limit > 0 && limit <= 500, "limit", "must be between 1 and 500")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	emails, err := app.models.Outbox.GetAll(status, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"emails": emails}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resendEmailHandler() - queues a dead-lettered email again, the workers pick it up on their next poll
+func (app *application) resendEmailHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	email, err := app.models.Outbox.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(email.Status == data.EmailDead, "status", "only dead-lettered emails can be re-sent")
+	//its token was cleared when it was dead-lettered, the user can ask for a new one
+	v.Check(!strings.HasPrefix(email.Template, "password_reset."), "template", "password reset emails can't be re-sent")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Outbox.Resend(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.contextGetLogger(r).PrintInfo("email re-sent", map[string]interface{}{"email_id": email.ID})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"email": email}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
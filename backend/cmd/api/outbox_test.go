@@ -0,0 +1,308 @@
+// BIOAFF/backend/cmd/api/outbox_test.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+// drainOutbox() - sends every due email, as the send-emails job would
+func drainOutbox(t *testing.T, app *testApp) {
+	t.Helper()
+
+	for {
+		processed, err := app.sendNextEmail()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !processed {
+			return
+		}
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	cfg := testConfig()
+	cfg.outbox.backoff = 30 * time.Second
+	cfg.outbox.maxBackoff = 5 * time.Minute
+	app := newTestApplication(t, nil, cfg)
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{5, 5 * time.Minute},
+		{40, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := app.outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestOutboxRetries(t *testing.T) {
+	cfg := testConfig()
+	cfg.outbox.maxAttempts = 2
+	app := newTestApplication(t, testdb.Open(t), cfg)
+	ts := newTestServer(t, app)
+
+	verifier := insertUser(t, app, data.UserKindAdmin, "reviewer@example.com", "forms:verify")
+	enableTwoFactor(t, app, verifier)
+	reviewer := authenticationToken(t, app, verifier)
+	admin := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "admin@example.com", "emails:read", "emails:write"))
+	applicant := insertUser(t, app, data.UserKindPublic, "applicant@example.com")
+	form := insertForm(t, app, applicant, data.FormStatusPending)
+
+	app.mailer.failWith(errors.New("smtp: connection refused"))
+	res := ts.do(t, http.MethodPatch, fmt.Sprintf("/v1/forms/%d/status", form.ID), reviewer, map[string]string{"status": "verified"})
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", res.status, res.body)
+	}
+
+	//the first failure is retried after the backoff, so nothing else is due yet
+	drainOutbox(t, app)
+	emails, err := app.models.Outbox.GetAll("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 1 || emails[0].Status != data.EmailPending || emails[0].Attempts != 1 || emails[0].LastError == "" {
+		t.Fatalf("got %+v, want one pending email with a failed attempt", emails[0])
+	}
+	if !emails[0].NextAttemptAt.After(time.Now().Add(10 * time.Second)) {
+		t.Errorf("next attempt at %s, want it backed off", emails[0].NextAttemptAt)
+	}
+
+	//the second failure uses up the attempts
+	_, err = app.db.Exec("UPDATE email_outbox SET next_attempt_at = NOW()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drainOutbox(t, app)
+
+	res = ts.do(t, http.MethodGet, "/v1/admin/emails?status=dead", admin, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want 200", res.status)
+	}
+	var list struct {
+		Emails []data.Email `json:"emails"`
+	}
+	res.decode(t, &list)
+	if len(list.Emails) != 1 || list.Emails[0].Attempts != 2 {
+		t.Fatalf("got %+v, want the email dead-lettered after 2 attempts", list.Emails)
+	}
+	notifications, err := app.models.Notifications.GetAllForUser(applicant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notifications[0].Status != data.NotificationFailed || notifications[0].Error == "" {
+		t.Errorf("got notification %+v, want failed with the error", notifications[0])
+	}
+
+	//an admin re-sends it once the mail server is back
+	app.mailer.failWith(nil)
+	path := fmt.Sprintf("/v1/admin/emails/%d/resend", list.Emails[0].ID)
+	res = ts.do(t, http.MethodPost, path, admin, nil)
+	if res.status != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202: %s", res.status, res.body)
+	}
+	res = ts.do(t, http.MethodPost, path, admin, nil)
+	if res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d, want 422 re-sending a queued email", res.status)
+	}
+
+	drainOutbox(t, app)
+	sent := app.mailer.emails()
+	if len(sent) != 1 || sent[0].recipient != applicant.Email {
+		t.Fatalf("got emails %+v", sent)
+	}
+	//numbers must survive the trip through the outbox's json unchanged
+	if got := fmt.Sprint(sent[0].data.(map[string]interface{})["formNumber"]); got != "343434" {
+		t.Errorf("got form number %s, want 343434", got)
+	}
+	email, err := app.models.Outbox.Get(list.Emails[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Status != data.EmailSent || email.SentAt == nil {
+		t.Errorf("got %+v, want sent", email)
+	}
+	notifications, err = app.models.Notifications.GetAllForUser(applicant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notifications[0].Status != data.NotificationSent || notifications[0].Error != "" {
+		t.Errorf("got notification %+v, want sent", notifications[0])
+	}
+}
+
+func TestOutboxClearsDeadSecrets(t *testing.T) {
+	cfg := testConfig()
+	cfg.outbox.maxAttempts = 1
+	app := newTestApplication(t, testdb.Open(t), cfg)
+	ts := newTestServer(t, app)
+
+	admin := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "admin@example.com", "emails:write"))
+	email := &data.Email{
+		Recipient: "staff@example.com",
+		Template:  "password_reset.en.tmpl",
+		Data:      map[string]interface{}{"passwordResetToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU", "minutes": 45},
+	}
+	err := app.models.Outbox.Insert(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.mailer.failWith(errors.New("smtp: connection refused"))
+	drainOutbox(t, app)
+
+	//the token is gone once the email is dead-lettered, the rest of its data is kept
+	var stored string
+	err = app.db.QueryRow("SELECT data FROM email_outbox WHERE id = $1", email.ID).Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != `{"minutes": 45}` {
+		t.Errorf("got data %s for the dead-lettered email, want the token cleared", stored)
+	}
+
+	res := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/admin/emails/%d/resend", email.ID), admin, nil)
+	if res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d re-sending a password reset email, want 422", res.status)
+	}
+}
+
+func TestOutboxUnreadableData(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+
+	email := &data.Email{Recipient: "someone@example.com", Template: "form_verified.en.tmpl"}
+	if err := app.models.Outbox.Insert(email); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.db.Exec(`UPDATE email_outbox SET data = '[1]' WHERE id = $1`, email.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	//the bad row costs an attempt and backs off instead of being picked again on every poll
+	send := func(*data.Email) error {
+		t.Error("sent an email whose data couldn't be read")
+		return nil
+	}
+	for i, want := range []bool{true, false} {
+		processed, err := app.models.Outbox.Process(2, app.outboxBackoff, send)
+		if err != nil || processed != want {
+			t.Fatalf("poll %d: got %t, %v, want %t", i, processed, err, want)
+		}
+	}
+	stored, err := app.models.Outbox.Get(email.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != data.EmailPending || stored.Attempts != 1 || stored.LastError == "" {
+		t.Errorf("got %+v, want a failed attempt recorded", stored)
+	}
+}
+
+func TestOutboxSkipLocked(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+
+	queued := &data.Email{Recipient: "someone@example.com", Template: "form_verified.en.tmpl"}
+	err := app.models.Outbox.Insert(queued)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//hold the only email in one worker while another looks for work, its lease keeps it held while it sends
+	claimed := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := app.models.Outbox.Process(1, app.outboxBackoff, func(*data.Email) error {
+			close(claimed)
+			<-release
+			return nil
+		})
+		done <- err
+	}()
+	<-claimed
+
+	processed, err := app.models.Outbox.Process(1, app.outboxBackoff, func(*data.Email) error {
+		t.Error("sent an email another worker holds")
+		return nil
+	})
+	if err != nil || processed {
+		t.Errorf("got %t, %v, want the locked email skipped", processed, err)
+	}
+
+	email, err := app.models.Outbox.Get(queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Status != data.EmailSending {
+		t.Errorf("got %+v mid-send, want it sending", email)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutboxExpiredLease(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+
+	email := &data.Email{Recipient: "someone@example.com", Template: "password_reset.en.tmpl", Data: map[string]interface{}{"passwordResetToken": "secret"}}
+	if err := app.models.Outbox.Insert(email); err != nil {
+		t.Fatal(err)
+	}
+
+	//a worker died mid-send on each attempt, it may or may not have gone out so it isn't sent straight away
+	send := func(*data.Email) error {
+		t.Error("sent an email straight after its lease ran out")
+		return nil
+	}
+	for i, want := range []string{data.EmailPending, data.EmailDead} {
+		query := `UPDATE email_outbox SET status = 'sending', attempts = $1, leased_until = NOW() - interval '1 minute' WHERE id = $2`
+		if _, err := app.db.Exec(query, i+1, email.ID); err != nil {
+			t.Fatal(err)
+		}
+		processed, err := app.models.Outbox.Process(2, app.outboxBackoff, send)
+		if err != nil || !processed {
+			t.Fatalf("got %t, %v, want the expired lease processed", processed, err)
+		}
+		stored, err := app.models.Outbox.Get(email.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != want || stored.Attempts != i+1 || stored.LastError == "" {
+			t.Errorf("got %+v, want %s with the lost attempt recorded", stored, want)
+		}
+	}
+}
+
+func TestListEmailsPermissions(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	reader := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "reader@example.com", "emails:read"))
+	nobody := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "nobody@example.com"))
+
+	if res := ts.do(t, http.MethodGet, "/v1/admin/emails", nobody, nil); res.status != http.StatusForbidden {
+		t.Errorf("got status %d listing without emails:read, want 403", res.status)
+	}
+	if res := ts.do(t, http.MethodPost, "/v1/admin/emails/1/resend", reader, nil); res.status != http.StatusForbidden {
+		t.Errorf("got status %d re-sending without emails:write, want 403", res.status)
+	}
+	if res := ts.do(t, http.MethodGet, "/v1/admin/emails?status=lost", reader, nil); res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for an unknown status, want 422", res.status)
+	}
+}
@@ -7,15 +7,71 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
-func (app *application) routers() http.Handler {
+func (app *application) routes() http.Handler {
 	//httprouter instance and the paths for each handler function
 
 	//the router instance
 	router := httprouter.New()
 
+	//unknown paths and methods get the same error format as everything else
+	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
 	//paths
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/live", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/ready", app.readinessHandler)
+
+	//form paths
+	router.HandlerFunc(http.MethodGet, "/v1/forms/:id/status", app.requirePermission("forms:read", app.showFormStatusHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/forms/:id/status", app.requirePermission("forms:verify", app.updateFormStatusHandler))
+
+	//token paths
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/two-factor", app.createTwoFactorAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	if app.config.auth.mode == authModeJWT {
+		router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.createRefreshTokenHandler)
+		router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.showJWKSHandler)
+	}
+	if app.sso != nil {
+		router.HandlerFunc(http.MethodPost, "/v1/tokens/sso", app.createSSOLoginHandler)
+		router.HandlerFunc(http.MethodPost, "/v1/tokens/sso/callback", app.createSSOCallbackHandler)
+		router.HandlerFunc(http.MethodPost, "/v1/users/me/sso", app.requireActivatedUser(app.createSSOLinkHandler))
+	}
+
+	//user paths
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/preferences", app.requireActivatedUser(app.showPreferencesHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/preferences", app.requireActivatedUser(app.updatePreferencesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAutheniticatedUser(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions", app.requireAutheniticatedUser(app.deleteAllSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:session_id", app.requireAutheniticatedUser(app.deleteSessionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/two-factor", app.requireActivatedUser(app.showTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/two-factor", app.requireActivatedUser(app.createTwoFactorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/two-factor", app.requireActivatedUser(app.deleteTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/two-factor/confirm", app.requireActivatedUser(app.confirmTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/two-factor/recovery-codes", app.requireActivatedUser(app.regenerateRecoveryCodesHandler))
+
+	//admin paths
+	router.HandlerFunc(http.MethodGet, "/v1/admin/log-level", app.requirePermission("logs:read", app.showLogLevelHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/log-level", app.requirePermission("logs:write", app.updateLogLevelHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/jobs", app.requirePermission("jobs:read", app.showJobsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/emails", app.requirePermission("emails:read", app.listEmailsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/emails/:id/resend", app.requirePermission("emails:write", app.resendEmailHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/lockouts", app.requirePermission("users:unlock", app.listLockoutsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:kind/:id/unlock", app.requirePermission("users:unlock", app.unlockUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:kind/:id/sessions", app.requirePermission("sessions:read", app.listUserSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:kind/:id/sessions", app.requirePermission("sessions:write", app.deleteUserSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:kind/:id/sessions/:session_id", app.requirePermission("sessions:write", app.deleteUserSessionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/api-keys", app.requirePermission("api_keys:read", app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/api-keys", app.requirePermission("api_keys:write", app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/api-keys/:id/rotate", app.requirePermission("api_keys:write", app.rotateAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/api-keys/:id", app.requirePermission("api_keys:write", app.deleteAPIKeyHandler))
 
 	//return; with all middleware layered on
-	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
+	//requests are limited per ip before authenticate, so bad tokens and API keys are limited too,
+	//and per user or key after it once we know who is asking
+	//recoverPanic sits inside logRequest so requests that panic are still access logged
+	return app.requestID(app.realIP(app.logRequest(app.recoverPanic(app.secureHeaders(app.enableCORS(app.rateLimitIP(app.authenticate(app.rateLimit(router)))))))))
 }
@@ -24,6 +24,38 @@ func (app *application) serve() error {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	//serve https when a certificate has been configured
+	var redirectSrv *http.Server
+	if app.config.tls.enabled() {
+		certs, err := newCertReloader(app.config.tls.certFile, app.config.tls.keyFile)
+		if err != nil {
+			return err
+		}
+		go app.watchCertificate(certs, 30*time.Second)
+		srv.TLSConfig = newTLSConfig(certs)
+
+		//optionally send plain http clients over to https
+		if app.config.tls.redirectAddr != "" {
+			redirectSrv = &http.Server{
+				Addr:         app.config.tls.redirectAddr,
+				Handler:      app.redirectToHTTPS(),
+				ErrorLog:     log.New(app.logger, "", 0),
+				IdleTimeout:  10 * time.Second,
+				ReadTimeout:  5 * time.Second,
+				WriteTimeout: 5 * time.Second,
+			}
+			go func() {
+				err := redirectSrv.ListenAndServe()
+				if !errors.Is(err, http.ErrServerClosed) {
+					app.logger.PrintError(err, map[string]interface{}{"addr": redirectSrv.Addr})
+				}
+			}()
+		}
+	}
+
+	//the background jobs run until the server shuts down
+	app.jobs.Start()
+
 	//The shutdown() function should return its error to this channel
 	shutdownError := make(chan error)
 
@@ -40,36 +72,28 @@ func (app *application) serve() error {
 		s := <-quit
 
 		//log a message
-		app.logger.PrintInfo("shuttding down server", map[string]string{
+		app.logger.PrintInfo("shuttding down server", map[string]interface{}{
 			"signal": s.String(),
 		})
 
-		//create a context with a 20-second timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		//call the shutdown function
-		err := srv.Shutdown(ctx)
-		if err != nil {
-			shutdownError <- err
-		}
-
-		//log a message about that Goroutine
-		app.logger.PrintInfo("completing background tasks", map[string]string{
-			"addr": srv.Addr,
-		})
-		app.wg.wait()
-		shutdownError <- nil
+		shutdownError <- app.shutdown(srv, redirectSrv)
 	}()
 
 	//starting our server
-	app.logger.PrintInfo("starting server", map[string]string{
+	app.logger.PrintInfo("starting server", map[string]interface{}{
 		"addr": srv.Addr,
 		"env":  app.config.env,
+		"tls":  app.config.tls.enabled(),
 	})
 
 	//check if the shutdown process has been initiated
-	err := srv.ListenAndServe()
+	var err error
+	if app.config.tls.enabled() {
+		//the certificate comes from the TLSConfig, so no files are passed here
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -81,8 +105,44 @@ func (app *application) serve() error {
 	}
 
 	//graceful shutdown was successful
-	app.logger.PrintInfo("stopped server", map[string]string{
+	app.logger.PrintInfo("stopped server", map[string]interface{}{
 		"addr": srv.Addr,
 	})
 	return nil
 }
+
+// shutdown() - takes the instance out of rotation, then stops the servers, the jobs and saves the last activity
+func (app *application) shutdown(srv, redirectSrv *http.Server) error {
+	//readiness probes report unavailable from here on, the listeners stay open long enough for the load
+	//balancer to see that and stop sending traffic
+	app.shuttingDown.Store(true)
+	time.Sleep(app.config.healthcheck.drainDelay)
+
+	//create a context with a 20-second timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	//the redirect listener has nothing in flight worth waiting for
+	if redirectSrv != nil {
+		redirectSrv.Close()
+	}
+
+	//call the shutdown function, requests outlasting the deadline still leave the jobs and activity to finish below
+	srvErr := srv.Shutdown(ctx)
+
+	//log a message about that Goroutine
+	app.logger.PrintInfo("completing background tasks", map[string]interface{}{
+		"addr": srv.Addr,
+	})
+	//cancel the running jobs, then run what is still queued until the deadline, queued emails stay in the outbox for the next start
+	jobsErr := app.jobs.Shutdown(ctx)
+
+	//nothing uses a session or API key from here on, so the last of their activity can be saved
+	if saveErr := app.saveSessions(); saveErr != nil {
+		app.logger.PrintError(saveErr, nil)
+	}
+	if saveErr := app.saveAPIKeyActivity(); saveErr != nil {
+		app.logger.PrintError(saveErr, nil)
+	}
+	return errors.Join(srvErr, jobsErr)
+}
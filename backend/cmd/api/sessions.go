@@ -0,0 +1,259 @@
+// BIOAFF/backend/cmd/api/sessions.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/julienschmidt/httprouter"
+)
+
+// sessionActivity - the latest use of each session since the last save, authenticate records every
+// use here so a busy session costs one write per save instead of one per request
+type sessionActivity struct {
+	mu      sync.Mutex
+	pending map[string]data.SessionActivity //keyed by the token hash
+}
+
+// newSessionActivity() - an empty batch of session activity
+func newSessionActivity() *sessionActivity {
+	return &sessionActivity{pending: make(map[string]data.SessionActivity)}
+}
+
+// touch() - records that the session was used just now from ip
+func (s *sessionActivity) touch(hash []byte, ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[string(hash)] = data.SessionActivity{Hash: hash, UsedAt: time.Now(), IP: ip}
+}
+
+// lastUsed() - the session's use waiting to be saved, if there is one
+func (s *sessionActivity) lastUsed(hash []byte) (data.SessionActivity, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.pending[string(hash)]
+	return a, ok
+}
+
+// take() - empties the batch, returning what was in it
+func (s *sessionActivity) take() []data.SessionActivity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch := make([]data.SessionActivity, 0, len(s.pending))
+	for _, a := range s.pending {
+		batch = append(batch, a)
+	}
+	s.pending = make(map[string]data.SessionActivity)
+	return batch
+}
+
+// putBack() - returns a batch which couldn't be saved, keeping any newer use recorded in the meantime
+func (s *sessionActivity) putBack(batch []data.SessionActivity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range batch {
+		if newer, ok := s.pending[string(a.Hash)]; ok && newer.UsedAt.After(a.UsedAt) {
+			continue
+		}
+		s.pending[string(a.Hash)] = a
+	}
+}
+
+// saveSessions() - saves the session activity recorded since the last save
+func (app *application) saveSessions() error {
+	batch := app.sessions.take()
+	err := app.models.Sessions.Touch(batch)
+	if err != nil {
+		app.sessions.putBack(batch)
+		return err
+	}
+	return nil
+}
+
+// saveSessionsJob() - saves the session and API key activity every sessions-flush-interval
+func (app *application) saveSessionsJob(ctx context.Context, arg interface{}) error {
+	return errors.Join(app.saveSessions(), app.saveAPIKeyActivity())
+}
+
+// sessionsForUser() - the user's sessions with their latest unsaved use filled in, and the request's own marked
+func (app *application) sessionsForUser(r *http.Request, user *data.User) ([]*data.Session, error) {
+	sessions, err := app.models.Sessions.GetAllForUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	current := app.contextGetSession(r)
+	for _, s := range sessions {
+		if a, ok := app.sessions.lastUsed(s.Hash); ok && (s.LastUsedAt == nil || a.UsedAt.After(*s.LastUsedAt)) {
+			usedAt := a.UsedAt
+			s.LastUsedAt, s.IP = &usedAt, a.IP
+		}
+		s.Current = (current.hash != nil && bytes.Equal(s.Hash, current.hash)) || (current.id != 0 && s.ID == current.id)
+	}
+	return sessions, nil
+}
+
+// readSessionIDParam() - the session id from the :session_id parameter
+func (app *application) readSessionIDParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("session_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid session id parameter")
+	}
+	return id, nil
+}
+
+// revokedEvent() - the audit event for sessions revoked by the request's user, from their own account or another's
+func (app *application) revokedEvent(r *http.Request, user *data.User, sessionID int64) *data.AuditEvent {
+	event := &data.AuditEvent{
+		Event:    data.AuditSessionsRevoked,
+		UserID:   user.ID,
+		UserKind: user.Kind,
+		IP:       app.contextGetClientIP(r).String(),
+	}
+	//only admins can revoke another account's sessions
+	if actor := app.contextGetUser(r); actor.ID != user.ID || actor.Kind != user.Kind {
+		event.ActorID = actor.ID
+	}
+	if sessionID != 0 {
+		event.Details = map[string]interface{}{"session_id": sessionID}
+	}
+	return event
+}
+
+// listSessionsHandler() - the signed in user's sessions
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.sessionsForUser(r, app.contextGetUser(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSessionHandler() - signs one of the user's sessions out, which may be the one making the request
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	id, err := app.readSessionIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Sessions.Delete(user, id, app.revokedEvent(r, user, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "the session was signed out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllSessionsHandler() - logs the user out everywhere, including the session making the request
+func (app *application) deleteAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	n, err := app.models.Sessions.DeleteAllForUser(user, app.revokedEvent(r, user, 0))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you were signed out everywhere", "sessions": n}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listUserSessionsHandler() - another account's sessions, for admins helping someone who lost a device
+func (app *application) listUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.readUserParams(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	sessions, err := app.sessionsForUser(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteUserSessionsHandler() - force-revokes another account's sessions, every one of them or just
+// the one in the :session_id parameter
+func (app *application) deleteUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.readUserParams(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var n int64 = 1
+	if httprouter.ParamsFromContext(r.Context()).ByName("session_id") != "" {
+		id, err := app.readSessionIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		err = app.models.Sessions.Delete(user, id, app.revokedEvent(r, user, id))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	} else {
+		n, err = app.models.Sessions.DeleteAllForUser(user, app.revokedEvent(r, user, 0))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.contextGetLogger(r).PrintInfo("sessions revoked", map[string]interface{}{"revoked_user_id": user.ID, "revoked_user_kind": user.Kind, "sessions": n})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "the sessions were signed out", "sessions": n}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
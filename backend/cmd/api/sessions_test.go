@@ -0,0 +1,208 @@
+// BIOAFF/backend/cmd/api/sessions_test.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+// sessionList - the body of the session listings
+type sessionList struct {
+	Sessions []data.Session `json:"sessions"`
+}
+
+// loginAs() - logs in to an applicant's account from the given browser, returning the session token
+func loginAs(t *testing.T, ts *testServer, email, password, userAgent string) string {
+	t.Helper()
+
+	res := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{"email": email, "password": password}, "User-Agent", userAgent)
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d logging in, want 201: %s", res.status, res.body)
+	}
+	var body struct {
+		Token data.Token `json:"authentication_token"`
+	}
+	res.decode(t, &body)
+	return body.Token.Plaintext
+}
+
+func TestSessionActivity(t *testing.T) {
+	s := newSessionActivity()
+
+	s.touch([]byte("a"), "192.0.2.1")
+	s.touch([]byte("b"), "192.0.2.2")
+	s.touch([]byte("a"), "192.0.2.3")
+	if a, ok := s.lastUsed([]byte("a")); !ok || a.IP != "192.0.2.3" {
+		t.Errorf("got %+v, want the latest use", a)
+	}
+
+	batch := s.take()
+	if len(batch) != 2 {
+		t.Fatalf("got %d sessions in the batch, want one per session", len(batch))
+	}
+	if _, ok := s.lastUsed([]byte("a")); ok {
+		t.Error("the batch wasn't emptied")
+	}
+
+	//a failed save is put back without overwriting a newer use
+	time.Sleep(time.Millisecond)
+	s.touch([]byte("b"), "192.0.2.9")
+	s.putBack(batch)
+	if a, _ := s.lastUsed([]byte("b")); a.IP != "192.0.2.9" {
+		t.Errorf("got %+v, want the newer use kept", a)
+	}
+	if _, ok := s.lastUsed([]byte("a")); !ok {
+		t.Error("the failed batch wasn't put back")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	applicant := insertUser(t, app, data.UserKindPublic, "applicant@example.com")
+	_, err := app.db.Exec("UPDATE public_user SET pu_password = 'Legacy-Pass-1' WHERE id = $1", applicant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop := loginAs(t, ts, applicant.Email, "Legacy-Pass-1", "front-desk-laptop")
+	phone := loginAs(t, ts, applicant.Email, "Legacy-Pass-1", "phone")
+
+	res := ts.do(t, http.MethodGet, "/v1/users/me/sessions", phone, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want 200", res.status)
+	}
+	var list sessionList
+	res.decode(t, &list)
+	if len(list.Sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(list.Sessions))
+	}
+	var laptopID int64
+	for _, s := range list.Sessions {
+		if s.IP != "127.0.0.1" {
+			t.Errorf("got %+v, want the client's ip", s)
+		}
+		if s.UserAgent == "phone" && s.LastUsedAt == nil {
+			t.Errorf("got %+v, want the unsaved use of the listing request", s)
+		}
+		if s.Current != (s.UserAgent == "phone") {
+			t.Errorf("got %+v, want only the phone's session marked current", s)
+		}
+		if s.UserAgent == "front-desk-laptop" {
+			laptopID = s.ID
+		}
+	}
+
+	//uses are saved in batches
+	var lastUsed *time.Time
+	err = app.db.QueryRow("SELECT last_used_at FROM tokens WHERE hash = $1", data.HashToken(phone)).Scan(&lastUsed)
+	if err != nil || lastUsed != nil {
+		t.Errorf("got last used %v (%v), want nothing saved before the batch", lastUsed, err)
+	}
+	if err := app.saveSessions(); err != nil {
+		t.Fatal(err)
+	}
+	err = app.db.QueryRow("SELECT last_used_at FROM tokens WHERE hash = $1", data.HashToken(phone)).Scan(&lastUsed)
+	if err != nil || lastUsed == nil {
+		t.Errorf("got last used %v (%v), want it saved with the batch", lastUsed, err)
+	}
+
+	//a batch saved late, e.g. after a failed save, leaves the newer use's time and ip alone
+	stale := []data.SessionActivity{{Hash: data.HashToken(phone), UsedAt: lastUsed.Add(-time.Hour), IP: "10.9.9.9"}}
+	if err := app.models.Sessions.Touch(stale); err != nil {
+		t.Fatal(err)
+	}
+	var ip string
+	var afterStale time.Time
+	err = app.db.QueryRow("SELECT last_used_at, ip FROM tokens WHERE hash = $1", data.HashToken(phone)).Scan(&afterStale, &ip)
+	if err != nil || !afterStale.Equal(*lastUsed) || ip != "127.0.0.1" {
+		t.Errorf("got %v %q (%v) after a stale batch, want %v from 127.0.0.1", afterStale, ip, err, lastUsed)
+	}
+
+	//the lost laptop is signed out from the phone
+	path := fmt.Sprintf("/v1/users/me/sessions/%d", laptopID)
+	if res := ts.do(t, http.MethodDelete, path, phone, nil); res.status != http.StatusOK {
+		t.Fatalf("got status %d revoking the laptop, want 200", res.status)
+	}
+	if res := ts.do(t, http.MethodGet, "/v1/users/me/sessions", laptop, nil); res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d with the revoked session, want 401", res.status)
+	}
+	if res := ts.do(t, http.MethodDelete, path, phone, nil); res.status != http.StatusNotFound {
+		t.Errorf("got status %d revoking it twice, want 404", res.status)
+	}
+
+	//someone else's session can't be revoked
+	other := insertUser(t, app, data.UserKindPublic, "other@example.com")
+	otherToken := authenticationToken(t, app, other)
+	var otherID int64
+	err = app.db.QueryRow("SELECT id FROM tokens WHERE hash = $1", data.HashToken(otherToken)).Scan(&otherID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := ts.do(t, http.MethodDelete, fmt.Sprintf("/v1/users/me/sessions/%d", otherID), phone, nil); res.status != http.StatusNotFound {
+		t.Errorf("got status %d revoking another account's session, want 404", res.status)
+	}
+
+	loginAs(t, ts, applicant.Email, "Legacy-Pass-1", "tablet")
+	if res := ts.do(t, http.MethodDelete, "/v1/users/me/sessions", phone, nil); res.status != http.StatusOK {
+		t.Fatalf("got status %d logging out everywhere, want 200", res.status)
+	}
+	if res := ts.do(t, http.MethodGet, "/v1/users/me/sessions", phone, nil); res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d after logging out everywhere, want 401", res.status)
+	}
+	if n := countAuditEvents(t, app, data.AuditSessionsRevoked); n != 2 {
+		t.Errorf("got %d revocation events, want 2", n)
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	admin := insertUser(t, app, data.UserKindAdmin, "staff@example.com", "sessions:read", "sessions:write")
+	session := authenticationToken(t, app, admin)
+	nobody := authenticationToken(t, app, insertUser(t, app, data.UserKindAdmin, "nobody@example.com"))
+
+	clerk := insertUser(t, app, data.UserKindAdmin, "clerk@example.com")
+	first := authenticationToken(t, app, clerk)
+	second := authenticationToken(t, app, clerk)
+
+	path := fmt.Sprintf("/v1/admin/users/admin/%d/sessions", clerk.ID)
+	if res := ts.do(t, http.MethodDelete, path, nobody, nil); res.status != http.StatusForbidden {
+		t.Errorf("got status %d without the permission, want 403", res.status)
+	}
+
+	res := ts.do(t, http.MethodGet, path, session, nil)
+	var list sessionList
+	res.decode(t, &list)
+	if res.status != http.StatusOK || len(list.Sessions) != 2 {
+		t.Fatalf("got status %d with %d sessions, want 200 with 2", res.status, len(list.Sessions))
+	}
+
+	if res := ts.do(t, http.MethodDelete, fmt.Sprintf("%s/%d", path, list.Sessions[0].ID), session, nil); res.status != http.StatusOK {
+		t.Fatalf("got status %d revoking one session, want 200: %s", res.status, res.body)
+	}
+	if res := ts.do(t, http.MethodDelete, path, session, nil); res.status != http.StatusOK {
+		t.Fatalf("got status %d revoking the rest, want 200: %s", res.status, res.body)
+	}
+	for _, token := range []string{first, second} {
+		if res := ts.do(t, http.MethodGet, "/v1/users/me/sessions", token, nil); res.status != http.StatusUnauthorized {
+			t.Errorf("got status %d with a revoked session, want 401", res.status)
+		}
+	}
+
+	var n int
+	err := app.db.QueryRow("SELECT count(*) FROM audit_events WHERE event = $1 AND admin_id = $2 AND actor_admin_id = $3", data.AuditSessionsRevoked, clerk.ID, admin.ID).Scan(&n)
+	if err != nil || n != 2 {
+		t.Errorf("got %d revocation events by the admin (%v), want 2", n, err)
+	}
+
+	if res := ts.do(t, http.MethodGet, "/v1/admin/users/admin/999999/sessions", session, nil); res.status != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown account, want 404", res.status)
+	}
+}
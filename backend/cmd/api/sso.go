@@ -0,0 +1,281 @@
+// BIOAFF/backend/cmd/api/sso.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+	"github.com/jinzhu/gorm/backend/internal/oidc"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// ssoLoginTTL - how long staff have to log in with the directory before they have to start again
+const ssoLoginTTL = 10 * time.Minute
+
+// ssoClientTimeout - how long each request to the directory may take
+const ssoClientTimeout = 10 * time.Second
+
+// groupPermissions - the permissions the members of a directory group hold, from a -oidc-group-permissions flag
+type groupPermissions struct {
+	group       string
+	permissions []string
+}
+
+// String() - the mapping in the form of its flag
+func (g groupPermissions) String() string {
+	return g.group + "=" + strings.Join(g.permissions, " ")
+}
+
+// parseGroupPermissions() - parses a -oidc-group-permissions flag value such as "bioaff-verifiers=forms:verify",
+// split at the last = as directory groups such as "CN=Verifiers,OU=Registry" may contain one themselves
+func parseGroupPermissions(val string) (groupPermissions, error) {
+	i := strings.LastIndex(val, "=")
+	if i < 1 {
+		return groupPermissions{}, fmt.Errorf("group permissions %q: must be group=permission ...", val)
+	}
+	permissions := strings.Fields(val[i+1:])
+	if len(permissions) == 0 {
+		return groupPermissions{}, fmt.Errorf("group permissions %q: must name at least one permission", val)
+	}
+	return groupPermissions{group: strings.TrimSpace(val[:i]), permissions: permissions}, nil
+}
+
+// newSSOProvider() - the directory staff log in with, nil when single sign-on isn't configured
+func newSSOProvider(cfg config, logger *jsonlog.Logger) *oidc.Provider {
+	if cfg.oidc.issuer == "" {
+		return nil
+	}
+	return oidc.New(oidc.Config{
+		Issuer:       cfg.oidc.issuer,
+		ClientID:     cfg.oidc.clientID,
+		ClientSecret: cfg.oidc.clientSecret,
+		RedirectURL:  cfg.oidc.redirectURL,
+		Scopes:       cfg.oidc.scopes,
+		Logger:       logger,
+	}, &http.Client{Timeout: ssoClientTimeout})
+}
+
+// validateSSOConfig() - checks the single sign-on settings once an issuer is configured
+func validateSSOConfig(v *validator.Validator, cfg config) {
+	if cfg.oidc.issuer == "" {
+		return
+	}
+
+	//the provider's metadata and keys are trusted because of where they came from
+	u, err := url.Parse(cfg.oidc.issuer)
+	v.Check(err == nil && u.Host != "" && (u.Scheme == "https" || (u.Scheme == "http" && cfg.env == "development")), "oidc-issuer", "must be an https URL")
+	v.Check(cfg.oidc.clientID != "", "oidc-client-id", "must be provided")
+	redirect, err := url.Parse(cfg.oidc.redirectURL)
+	v.Check(err == nil && redirect.Host != "" && validator.In(redirect.Scheme, "http", "https"), "oidc-redirect-url", "must be an absolute URL")
+	v.Check(validator.In("openid", cfg.oidc.scopes...), "oidc-scopes", "must include openid")
+	v.Check(cfg.oidc.groupsClaim != "", "oidc-groups-claim", "must be provided")
+	v.Check(len(cfg.oidc.groupPermissions) > 0, "oidc-group-permissions", "must map at least one group")
+}
+
+// ssoPermissions() - the permissions granted by the directory groups the user is in
+func (app *application) ssoPermissions(groups []string) []string {
+	var permissions []string
+	for _, mapping := range app.config.oidc.groupPermissions {
+		if !validator.In(mapping.group, groups...) {
+			continue
+		}
+		for _, code := range mapping.permissions {
+			if !validator.In(code, permissions...) {
+				permissions = append(permissions, code)
+			}
+		}
+	}
+	return permissions
+}
+
+// ssoMFA() - reports if the directory says the user passed a second factor, by an amr or acr value
+// configured with -oidc-mfa-values
+func (app *application) ssoMFA(idToken *oidc.IDToken) bool {
+	for _, claim := range []string{"amr", "acr"} {
+		for _, value := range idToken.Strings(claim) {
+			if validator.In(value, app.config.oidc.mfaValues...) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// createSSOLoginHandler() - starts a login with the directory, the client sends the browser to the
+// authorization url and posts the state and code it comes back with to the callback, along with the
+// verifier. The verifier never goes near the browser's address bar, so the code is no use to anyone who
+// sees the redirect.
+func (app *application) createSSOLoginHandler(w http.ResponseWriter, r *http.Request) {
+	app.startSSOLogin(w, r, 0)
+}
+
+// createSSOLinkHandler() - starts a login with the directory which links the signed in staff account to the
+// directory account once it comes back to the callback. Accounts are only ever linked this way, never by email.
+func (app *application) createSSOLinkHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if user.Kind != data.UserKindAdmin {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	linked, err := app.ssoLinked(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if linked {
+		app.ssoLinkedResponse(w, r)
+		return
+	}
+
+	app.startSSOLogin(w, r, user.ID)
+}
+
+// startSSOLogin() - stores a new login, linking to the account with linkAdminID unless it is 0,
+// and responds with where to send the browser
+func (app *application) startSSOLogin(w http.ResponseWriter, r *http.Request, linkAdminID int64) {
+	login := data.SSOLogin{LinkAdminID: linkAdminID, Expiry: time.Now().Add(ssoLoginTTL)}
+	var err error
+	for _, value := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		*value, err = oidc.RandomString()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	authURL, err := app.sso.AuthCodeURL(r.Context(), login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.SSO.InsertLogin(&login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"authorization_url": authURL, "state": login.State, "verifier": login.Verifier, "expiry": login.Expiry}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createSSOCallbackHandler() - finishes a login with the directory, the staff account is created the first
+// time, or linked when the login was started to link it, and the permissions from its groups are replaced with
+// the ones they grant every time
+func (app *application) createSSOCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		State    string `json:"state"`
+		Code     string `json:"code"`
+		Verifier string `json:"verifier"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.State != "", "state", "must be provided")
+	v.Check(len(input.State) <= 100, "state", "must not be more than 100 bytes long")
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(len(input.Code) <= 2048, "code", "must not be more than 2048 bytes long")
+	v.Check(input.Verifier != "", "verifier", "must be provided")
+	v.Check(len(input.Verifier) <= 128, "verifier", "must not be more than 128 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//taking the login means a state can only be used once, and only by the client that started the login
+	login, err := app.models.SSO.TakeLogin(input.State, input.Verifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired login, please start again")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	rawIDToken, err := app.sso.Exchange(r.Context(), input.Code, login.Verifier)
+	if err != nil {
+		app.logError(r, err)
+		v.AddError("code", "could not be exchanged with the directory, please start again")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	idToken, err := app.sso.VerifyIDToken(r.Context(), rawIDToken, login.Nonce, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidIDToken), errors.Is(err, oidc.ErrExpiredIDToken):
+			app.logError(r, err)
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !validator.Matches(idToken.Email, validator.EmailRX) {
+		app.ssoNotPermittedResponse(w, r, "your directory account has no email address")
+		return
+	}
+	permissions := app.ssoPermissions(idToken.Strings(app.config.oidc.groupsClaim))
+	if len(permissions) == 0 {
+		app.ssoNotPermittedResponse(w, r, "your directory groups don't grant access to BioAff")
+		return
+	}
+
+	identity := data.SSOIdentity{
+		Issuer:  app.config.oidc.issuer,
+		Subject: idToken.Subject,
+		Email:   idToken.Email,
+	}
+	if login.LinkAdminID != 0 {
+		err = app.models.SSO.Link(login.LinkAdminID, identity, app.contextGetClientIP(r).String())
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.ssoLinkedResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	user, err := app.models.SSO.Provision(identity, permissions, app.contextGetClientIP(r).String())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.ssoAccountExistsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//the directory took care of the password, and of the second factor when its claims say so
+	app.completeLogin(w, r, user, data.AccountThrottleKey(user.Kind, user.Email), app.ssoMFA(idToken))
+}
+
+// ssoLinked() - reports if the user logs in with the directory, which is never the case without single sign-on
+func (app *application) ssoLinked(user *data.User) (bool, error) {
+	if app.sso == nil {
+		return false, nil
+	}
+	return app.models.SSO.Linked(user)
+}
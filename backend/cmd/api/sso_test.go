@@ -0,0 +1,342 @@
+// BIOAFF/backend/cmd/api/sso_test.go
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/oidc"
+	"github.com/jinzhu/gorm/backend/internal/oidctest"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// ssoConfig() - the test config with single sign-on through the mock provider
+func ssoConfig(mock *oidctest.Provider) config {
+	cfg := testConfig()
+	cfg.oidc.issuer = mock.Issuer()
+	cfg.oidc.clientID = mock.ClientID
+	cfg.oidc.clientSecret = mock.ClientSecret
+	cfg.oidc.redirectURL = "https://bioaff.example.gov/sso/callback"
+	cfg.oidc.groupPermissions = []groupPermissions{
+		{group: "bioaff-verifiers", permissions: []string{"forms:verify"}},
+		{group: "bioaff-support", permissions: []string{"users:unlock", "sessions:read"}},
+	}
+	cfg.oidc.mfaValues = []string{"mfa"}
+	return cfg
+}
+
+// useSSO() - points the application at the mock provider
+func useSSO(app *testApp, mock *oidctest.Provider) {
+	app.sso = oidc.New(oidc.Config{
+		Issuer:       app.config.oidc.issuer,
+		ClientID:     app.config.oidc.clientID,
+		ClientSecret: app.config.oidc.clientSecret,
+		RedirectURL:  app.config.oidc.redirectURL,
+		Scopes:       app.config.oidc.scopes,
+	}, mock.Client())
+}
+
+// ssoLogin() - logs in as the mock provider's current user, returning the callback's response
+func ssoLogin(t *testing.T, ts *testServer, mock *oidctest.Provider) testResponse {
+	t.Helper()
+
+	res := ts.do(t, http.MethodPost, "/v1/tokens/sso", "", nil)
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d starting a login, want 201: %s", res.status, res.body)
+	}
+	var start struct {
+		AuthorizationURL string `json:"authorization_url"`
+		State            string `json:"state"`
+		Verifier         string `json:"verifier"`
+	}
+	res.decode(t, &start)
+
+	code, state := mock.Login(t, start.AuthorizationURL)
+	if state != start.State {
+		t.Fatalf("got state %q back from the provider, want %q", state, start.State)
+	}
+	return ts.do(t, http.MethodPost, "/v1/tokens/sso/callback", "", map[string]string{"state": state, "code": code, "verifier": start.Verifier})
+}
+
+func TestParseGroupPermissions(t *testing.T) {
+	g, err := parseGroupPermissions("CN=Verifiers,OU=Registry=forms:verify logs:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.group != "CN=Verifiers,OU=Registry" || len(g.permissions) != 2 || g.permissions[1] != "logs:read" {
+		t.Errorf("got %+v", g)
+	}
+
+	for _, val := range []string{"", "verifiers", "=forms:verify", "verifiers=", "verifiers=  "} {
+		if _, err := parseGroupPermissions(val); err == nil {
+			t.Errorf("%q was accepted", val)
+		}
+	}
+}
+
+func TestSSOConfig(t *testing.T) {
+	mock := oidctest.NewProvider(t, "bioaff", "s3cret")
+	app := newTestApplication(t, nil, ssoConfig(mock))
+
+	v := validator.New()
+	validateSSOConfig(v, app.config)
+	if !v.Valid() {
+		t.Fatalf("got %v for a valid config", v.Errors)
+	}
+
+	//a plain http issuer is only good enough in development
+	cfg := app.config
+	cfg.env = "production"
+	cfg.oidc.scopes = []string{"email"}
+	cfg.oidc.groupPermissions = nil
+	v = validator.New()
+	validateSSOConfig(v, cfg)
+	for _, key := range []string{"oidc-issuer", "oidc-scopes", "oidc-group-permissions"} {
+		if _, ok := v.Errors[key]; !ok {
+			t.Errorf("%s was accepted", key)
+		}
+	}
+
+	perms := app.ssoPermissions([]string{"all-staff", "bioaff-support", "bioaff-verifiers"})
+	if len(perms) != 3 || perms[0] != "forms:verify" {
+		t.Errorf("got permissions %v", perms)
+	}
+	if perms := app.ssoPermissions([]string{"all-staff"}); len(perms) != 0 {
+		t.Errorf("got permissions %v for unmapped groups", perms)
+	}
+
+	tokens := []struct {
+		claims map[string]interface{}
+		want   bool
+	}{
+		{map[string]interface{}{"amr": []interface{}{"pwd", "mfa"}}, true},
+		{map[string]interface{}{"acr": "mfa"}, true},
+		{map[string]interface{}{"amr": []interface{}{"pwd"}, "acr": "urn:registry:loa:1"}, false},
+		{map[string]interface{}{}, false},
+	}
+	for _, tt := range tokens {
+		if got := app.ssoMFA(&oidc.IDToken{Claims: tt.claims}); got != tt.want {
+			t.Errorf("ssoMFA(%v) = %t, want %t", tt.claims, got, tt.want)
+		}
+	}
+
+	//without an issuer there are no sso routes at all
+	ts := newTestServer(t, newTestApplication(t, nil, testConfig()))
+	if res := ts.do(t, http.MethodPost, "/v1/tokens/sso", "", nil); res.status != http.StatusNotFound {
+		t.Errorf("got status %d without single sign-on, want 404", res.status)
+	}
+}
+
+func TestSSOLogin(t *testing.T) {
+	mock := oidctest.NewProvider(t, "bioaff", "s3cret")
+	app := newTestApplication(t, testdb.Open(t), ssoConfig(mock))
+	useSSO(app, mock)
+	ts := newTestServer(t, app)
+
+	mock.SetUser(map[string]interface{}{
+		"sub":            "u-123",
+		"email":          "verifier@registry.gov",
+		"email_verified": true,
+		"groups":         []string{"all-staff", "bioaff-verifiers"},
+		"amr":            []string{"pwd", "mfa"},
+	})
+
+	//the first login creates the account, with the permissions of the user's groups
+	res := ssoLogin(t, ts, mock)
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d from the callback, want 201: %s", res.status, res.body)
+	}
+	var login struct {
+		Token data.Token `json:"authentication_token"`
+	}
+	res.decode(t, &login)
+
+	user, err := app.models.Users.GetByEmail(data.UserKindAdmin, "verifier@registry.gov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	perms, err := app.models.Permissions.GetAllForUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(perms) != 1 || !perms.Include("forms:verify") {
+		t.Errorf("got permissions %v, want forms:verify", perms)
+	}
+
+	//the directory checked a second factor, so the permission is usable straight away
+	if res := ts.do(t, http.MethodPatch, "/v1/forms/0/status", login.Token.Plaintext, map[string]string{"status": "verified"}); res.status == http.StatusForbidden {
+		t.Errorf("got status 403 using forms:verify, want the two-factor policy to be met: %s", res.body)
+	}
+
+	//a login where it didn't, or a session the account had before, doesn't meet the policy
+	mock.SetUser(map[string]interface{}{
+		"sub":            "u-123",
+		"email":          "verifier@registry.gov",
+		"email_verified": true,
+		"groups":         []string{"all-staff", "bioaff-verifiers"},
+		"amr":            []string{"pwd"},
+		"acr":            "urn:registry:loa:1",
+	})
+	res = ssoLogin(t, ts, mock)
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d from the callback, want 201: %s", res.status, res.body)
+	}
+	var passwordOnly struct {
+		Token data.Token `json:"authentication_token"`
+	}
+	res.decode(t, &passwordOnly)
+	for _, token := range []string{passwordOnly.Token.Plaintext, authenticationToken(t, app, user)} {
+		res := ts.do(t, http.MethodPatch, "/v1/forms/0/status", token, map[string]string{"status": "verified"})
+		var problem problem
+		res.decode(t, &problem)
+		if res.status != http.StatusForbidden || problem.Code != codeTwoFactorRequired {
+			t.Errorf("got status %d %q without a second factor, want 403 %s", res.status, problem.Code, codeTwoFactorRequired)
+		}
+	}
+
+	//moving groups in the directory moves the permissions with the next login, the ones granted in BioAff stay
+	err = app.models.Permissions.AddForUser(user.ID, "logs:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.SetUser(map[string]interface{}{
+		"sub":            "u-123",
+		"email":          "verifier@registry.gov",
+		"email_verified": true,
+		"groups":         []string{"bioaff-support"},
+	})
+	if res := ssoLogin(t, ts, mock); res.status != http.StatusCreated {
+		t.Fatalf("got status %d logging in again, want 201: %s", res.status, res.body)
+	}
+	perms, _ = app.models.Permissions.GetAllForUser(user)
+	if len(perms) != 3 || perms.Include("forms:verify") || !perms.Include("logs:read") {
+		t.Errorf("got permissions %v after changing groups", perms)
+	}
+	if n := countAuditEvents(t, app, data.AuditSSOLinked); n != 1 {
+		t.Errorf("got %d link events, want 1", n)
+	}
+
+	//the redirect's state and code are no use without the verifier the client was given,
+	//and a wrong one doesn't use the login up
+	res = ts.do(t, http.MethodPost, "/v1/tokens/sso", "", nil)
+	var start struct {
+		AuthorizationURL string `json:"authorization_url"`
+		Verifier         string `json:"verifier"`
+	}
+	res.decode(t, &start)
+	code, state := mock.Login(t, start.AuthorizationURL)
+	for _, verifier := range []string{"", "intercepted-without-the-verifier"} {
+		res := ts.do(t, http.MethodPost, "/v1/tokens/sso/callback", "", map[string]string{"state": state, "code": code, "verifier": verifier})
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d with verifier %q, want 422", res.status, verifier)
+		}
+	}
+	callback := map[string]string{"state": state, "code": code, "verifier": start.Verifier}
+	if res := ts.do(t, http.MethodPost, "/v1/tokens/sso/callback", "", callback); res.status != http.StatusCreated {
+		t.Errorf("got status %d with the verifier, want 201: %s", res.status, res.body)
+	}
+
+	//a state can't be used twice
+	if res := ts.do(t, http.MethodPost, "/v1/tokens/sso/callback", "", callback); res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d reusing a state, want 422", res.status)
+	}
+
+	//users outside the mapped groups aren't let in
+	mock.SetUser(map[string]interface{}{"sub": "u-456", "email": "clerk@registry.gov", "email_verified": true, "groups": []string{"all-staff"}})
+	if res := ssoLogin(t, ts, mock); res.status != http.StatusForbidden {
+		t.Errorf("got status %d without a mapped group, want 403", res.status)
+	}
+	if _, err := app.models.Users.GetByEmail(data.UserKindAdmin, "clerk@registry.gov"); err == nil {
+		t.Error("an account was created for a user without a mapped group")
+	}
+}
+
+func TestSSOLinking(t *testing.T) {
+	mock := oidctest.NewProvider(t, "bioaff", "s3cret")
+	app := newTestApplication(t, testdb.Open(t), ssoConfig(mock))
+	useSSO(app, mock)
+	ts := newTestServer(t, app)
+
+	existing := insertUser(t, app, data.UserKindAdmin, "reviewer@registry.gov", "logs:read")
+	_, err := app.db.Exec("UPDATE admin_users SET au_password = 'Legacy-Pass-1' WHERE id = $1", existing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := map[string]string{"email": existing.Email, "password": "Legacy-Pass-1", "kind": data.UserKindAdmin}
+	res := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", password)
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d logging in with the password before linking, want 201", res.status)
+	}
+	var session struct {
+		Token data.Token `json:"authentication_token"`
+	}
+	res.decode(t, &session)
+
+	//a directory login with the same email, verified or not, never takes the account over
+	for _, verified := range []bool{false, true} {
+		mock.SetUser(map[string]interface{}{"sub": "u-789", "email": "Reviewer@registry.gov", "email_verified": verified, "groups": []string{"bioaff-verifiers"}, "amr": []string{"mfa"}})
+		res := ssoLogin(t, ts, mock)
+		var problem problem
+		res.decode(t, &problem)
+		if res.status != http.StatusConflict || problem.Code != codeAccountExists {
+			t.Errorf("got status %d %q with email_verified %t, want 409 %s", res.status, problem.Code, verified, codeAccountExists)
+		}
+	}
+	if linked, err := app.models.SSO.Linked(existing); err != nil || linked {
+		t.Fatalf("got %v %v, want the account left unlinked", linked, err)
+	}
+
+	//only applicants and anonymous requests are turned away from linking
+	applicant := authenticationToken(t, app, insertUser(t, app, data.UserKindPublic, "applicant@example.com"))
+	if res := ts.do(t, http.MethodPost, "/v1/users/me/sso", applicant, nil); res.status != http.StatusForbidden {
+		t.Errorf("got status %d linking an applicant, want 403", res.status)
+	}
+	if res := ts.do(t, http.MethodPost, "/v1/users/me/sso", "", nil); res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d linking without signing in, want 401", res.status)
+	}
+
+	//the owner links it while signed in
+	res = ts.do(t, http.MethodPost, "/v1/users/me/sso", session.Token.Plaintext, nil)
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d starting a link, want 201: %s", res.status, res.body)
+	}
+	var start struct {
+		AuthorizationURL string `json:"authorization_url"`
+		Verifier         string `json:"verifier"`
+	}
+	res.decode(t, &start)
+	code, state := mock.Login(t, start.AuthorizationURL)
+	res = ts.do(t, http.MethodPost, "/v1/tokens/sso/callback", "", map[string]string{"state": state, "code": code, "verifier": start.Verifier})
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d from the link's callback, want 201: %s", res.status, res.body)
+	}
+	if linked, err := app.models.SSO.Linked(existing); err != nil || !linked {
+		t.Fatalf("got %v %v, want the account linked", linked, err)
+	}
+
+	//the sessions started with the password are revoked, and from now on the password is no use
+	if res := ts.do(t, http.MethodGet, "/v1/users/me/sessions", session.Token.Plaintext, nil); res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d with a session from before the link, want 401", res.status)
+	}
+	res = ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", password)
+	var problem problem
+	res.decode(t, &problem)
+	if res.status != http.StatusForbidden || problem.Code != codeSSORequired {
+		t.Errorf("got status %d %q logging in with the password after linking, want 403 %s", res.status, problem.Code, codeSSORequired)
+	}
+
+	//and the account can't be linked a second time
+	res = ssoLogin(t, ts, mock)
+	var login struct {
+		Token data.Token `json:"authentication_token"`
+	}
+	res.decode(t, &login)
+	res = ts.do(t, http.MethodPost, "/v1/users/me/sso", login.Token.Plaintext, nil)
+	problem.Code = ""
+	res.decode(t, &problem)
+	if res.status != http.StatusConflict || problem.Code != codeSSOLinked {
+		t.Errorf("got status %d %q linking again, want 409 %s", res.status, problem.Code, codeSSOLinked)
+	}
+}
@@ -0,0 +1,338 @@
+// BIOAFF/backend/cmd/api/testutils_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/jobs"
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+	"github.com/jinzhu/gorm/backend/internal/limiter"
+	"github.com/jinzhu/gorm/backend/internal/migrate"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+	"github.com/jinzhu/gorm/backend/internal/totp"
+	"github.com/jinzhu/gorm/backend/migrations"
+)
+
+func TestMain(m *testing.M) {
+	testdb.Main(m)
+}
+
+// sentEmail - an email the fake mailer was asked to send
+type sentEmail struct {
+	recipient    string
+	templateFile string
+	data         interface{}
+}
+
+// fakeMailer - records emails instead of sending them
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []sentEmail
+	err  error //returned by Send instead of sending while set
+}
+
+func (m *fakeMailer) Send(recipient, templateFile string, data interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, sentEmail{recipient: recipient, templateFile: templateFile, data: data})
+	return nil
+}
+
+// failWith() - makes every Send fail with err until it is called with nil
+func (m *fakeMailer) failWith(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+// emails() - a copy of the emails sent so far
+func (m *fakeMailer) emails() []sentEmail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]sentEmail(nil), m.sent...)
+}
+
+// logBuffer - collects log lines, the handlers may still be logging while a test reads it
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// entries() - the log lines written so far, decoded
+func (b *logBuffer) entries(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatalf("log line %q: %s", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// testApp - the application under test along with what it sent and logged
+type testApp struct {
+	*application
+	mailer *fakeMailer
+	logs   *logBuffer
+}
+
+// testConfig() - the flag defaults, with the rate limiter off so tests can make as many requests as they like
+func testConfig() config {
+	var cfg config
+	cfg.port = 4000
+	cfg.env = "development"
+	cfg.db.maxOpenConns = 25
+	cfg.db.maxIdleConns = 25
+	cfg.db.maxIdleTime = "15m"
+	cfg.limiter.rps = 2
+	cfg.limiter.burst = 4
+	cfg.limiter.userRPS = 4
+	cfg.limiter.userBurst = 8
+	cfg.limiter.store = "memory"
+	cfg.smtp.host = "localhost"
+	cfg.smtp.port = 25
+	cfg.smtp.sender = "BioAff <no-reply@bioaff.test>"
+	cfg.cors.allowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	cfg.cors.allowedHeaders = []string{"Authorization", "Content-Type"}
+	cfg.cors.exposedHeaders = []string{"X-Request-ID", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"}
+	cfg.cors.maxAge = time.Hour
+	cfg.jobs.workers = 4
+	cfg.jobs.queueSize = 100
+	cfg.outbox.workers = 2
+	cfg.outbox.pollInterval = 5 * time.Second
+	cfg.outbox.maxAttempts = 8
+	cfg.outbox.backoff = 30 * time.Second
+	cfg.outbox.maxBackoff = time.Hour
+	cfg.login.maxFailures = 10
+	cfg.login.ipMaxFailures = 100
+	cfg.login.delayAfter = 3
+	cfg.login.delay = time.Second
+	cfg.login.maxDelay = 30 * time.Second
+	cfg.login.lockout = 15 * time.Minute
+	cfg.login.window = 15 * time.Minute
+	cfg.sessions.flushInterval = time.Minute
+	cfg.auth.mode = authModeOpaque
+	cfg.jwt.issuer = "bioaff"
+	cfg.jwt.accessTTL = 15 * time.Minute
+	cfg.jwt.refreshTTL = 30 * 24 * time.Hour
+	cfg.oidc.scopes = []string{"openid", "email", "profile"}
+	cfg.oidc.groupsClaim = "groups"
+	cfg.apiKeys.permissions = []string{"forms:read"}
+	cfg.twoFactor.issuer = "BioAff"
+	cfg.twoFactor.requiredPermissions = []string{"forms:verify"}
+	cfg.healthcheck.timeout = 2 * time.Second
+	cfg.healthcheck.drainDelay = 200 * time.Millisecond
+	cfg.tls.hstsMaxAge = 365 * 24 * time.Hour
+	cfg.trustedProxyHeader = proxyHeaderXForwardedFor
+	return cfg
+}
+
+// newTestApplication() - builds the full application around db, which may be nil for tests that don't need it
+func newTestApplication(t *testing.T, db *sql.DB, cfg config) *testApp {
+	t.Helper()
+
+	if db != nil && cfg.db.schemaVersion == 0 {
+		schema, err := migrate.New(db, migrations.Schema, schemaMigrationsTable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.db.schemaVersion = schema.Latest()
+	}
+
+	logs := &logBuffer{}
+	mailer := &fakeMailer{}
+	app := &application{
+		config:   cfg,
+		logger:   jsonlog.New(logs, jsonlog.LevelDebug),
+		db:       db,
+		limiter:  limiter.NewMemoryStore(),
+		models:   data.NewModels(db),
+		mailer:   mailer,
+		sessions: newSessionActivity(),
+		apiKeys:  newAPIKeyActivity(),
+	}
+	//tests register and start the jobs they need, so nothing runs behind their back
+	app.jobs = jobs.New(app.logger, cfg.jobs.workers, cfg.jobs.queueSize)
+
+	//background work must finish before the next test starts
+	t.Cleanup(func() {
+		err := app.jobs.Shutdown(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	return &testApp{application: app, mailer: mailer, logs: logs}
+}
+
+// testServer - the application's routes served over a real http connection
+type testServer struct {
+	*httptest.Server
+}
+
+// newTestServer() - serves the routes of the application until the test ends
+func newTestServer(t *testing.T, app *testApp) *testServer {
+	t.Helper()
+
+	ts := httptest.NewServer(app.routes())
+	t.Cleanup(ts.Close)
+	return &testServer{ts}
+}
+
+// testResponse - what the server sent back
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// decode() - unmarshals the json body into dst
+func (r testResponse) decode(t *testing.T, dst interface{}) {
+	t.Helper()
+	err := json.Unmarshal(r.body, dst)
+	if err != nil {
+		t.Fatalf("decoding %q: %s", r.body, err)
+	}
+}
+
+// do() - sends a request, the body is sent as json unless it is already a string
+// the token is sent as a bearer token when it isn't empty
+func (ts *testServer) do(t *testing.T, method, path, token string, body interface{}, headers ...string) testResponse {
+	t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		js, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	content, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testResponse{status: res.StatusCode, header: res.Header, body: content}
+}
+
+// insertUser() - creates an activated account of the given kind, admins are granted the permissions
+func insertUser(t *testing.T, app *testApp, kind, email string, permissions ...string) *data.User {
+	t.Helper()
+
+	query := `
+		INSERT INTO admin_users (email, au_password, activated)
+		VALUES ($1, '', true)
+		RETURNING id`
+	if kind == data.UserKindPublic {
+		query = `
+			INSERT INTO public_user (email, pu_password, activated)
+			VALUES ($1, '', true)
+			RETURNING id`
+	}
+
+	var id int64
+	err := app.db.QueryRow(query, email).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) > 0 {
+		err = app.models.Permissions.AddForUser(id, permissions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	user, err := app.models.Users.Get(kind, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// authenticationToken() - issues a bearer token for the user
+func authenticationToken(t *testing.T, app *testApp, user *data.User) string {
+	t.Helper()
+
+	token, err := app.models.Tokens.New(user, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.Plaintext
+}
+
+// enableTwoFactor() - turns two-factor authentication on for an admin, returning the secret to make codes with
+// and the recovery codes
+func enableTwoFactor(t *testing.T, app *testApp, user *data.User) ([]byte, []string) {
+	t.Helper()
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.TOTP.Begin(user.ID, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//an old step, so codes from now on are all accepted
+	err = app.models.TOTP.Confirm(user.ID, 1, codes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret, codes
+}
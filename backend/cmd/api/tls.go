@@ -0,0 +1,173 @@
+// BIOAFF/backend/cmd/api/tls.go
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certReloader - holds the server's certificate and swaps it for a new one when the files change
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// newCertReloader() - loads the certificate and key pair for the first time
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	err := cr.reload()
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload() - reads the certificate and key pair from disk, the old pair stays in use if they are invalid
+func (cr *certReloader) reload() error {
+	certMod, keyMod, err := cr.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.cert = &cert
+	cr.certMod = certMod
+	cr.keyMod = keyMod
+	return nil
+}
+
+// changed() - reports if either file was modified since the pair was last loaded
+func (cr *certReloader) changed() bool {
+	certMod, keyMod, err := cr.modTimes()
+	if err != nil {
+		//a renewal in progress may have moved the files away for a moment
+		return false
+	}
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return !certMod.Equal(cr.certMod) || !keyMod.Equal(cr.keyMod)
+}
+
+// modTimes() - the modification times of the certificate and key files
+func (cr *certReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// getCertificate() - hands the current certificate to every new tls handshake
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// watchCertificate() - reloads the certificate on SIGHUP and whenever its files change on disk
+func (app *application) watchCertificate(cr *certReloader, interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hangup:
+		case <-ticker.C:
+			if !cr.changed() {
+				continue
+			}
+		}
+
+		err := cr.reload()
+		if err != nil {
+			app.logger.PrintError(fmt.Errorf("reloading tls certificate: %w", err), nil)
+			continue
+		}
+		app.logger.PrintInfo("tls certificate reloaded", map[string]interface{}{
+			"cert_file": cr.certFile,
+		})
+	}
+}
+
+// newTLSConfig() - a modern configuration, TLS 1.2 with forward secret AEAD suites or TLS 1.3
+func newTLSConfig(cr *certReloader) *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		GetCertificate: cr.getCertificate,
+	}
+}
+
+// redirectToHTTPS() - sends every plain http request to the same url on our https port
+func (app *application) redirectToHTTPS() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			//the Host header doesn't always carry a port, an ipv6 address keeps its brackets without one
+			host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+		}
+		if app.config.port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+// secureHeaders() - tells browsers to only ever use https once they have reached us over tls
+func (app *application) secureHeaders(next http.Handler) http.Handler {
+	hsts := fmt.Sprintf("max-age=%d", int(app.config.tls.hstsMaxAge.Seconds()))
+	if app.config.tls.hstsSubdomains {
+		hsts += "; includeSubDomains"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", hsts)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,194 @@
+// BIOAFF/backend/cmd/api/tls_test.go
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert() - writes a certificate for 127.0.0.1 with the given serial number, and its key,
+// returning the certificate for clients to trust
+func writeSelfSignedCert(t *testing.T, certFile, keyFile string, serial int64) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "bioaff.test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		port int
+		host string
+		want string
+	}{
+		{4000, "bioaff.test", "https://bioaff.test:4000/v1/forms?page=2"},
+		{4000, "bioaff.test:80", "https://bioaff.test:4000/v1/forms?page=2"},
+		{4000, "[::1]", "https://[::1]:4000/v1/forms?page=2"},
+		{4000, "[::1]:80", "https://[::1]:4000/v1/forms?page=2"},
+		{443, "bioaff.test:80", "https://bioaff.test/v1/forms?page=2"},
+		{443, "[::1]", "https://[::1]/v1/forms?page=2"},
+		{443, "[2001:db8::1]:80", "https://[2001:db8::1]/v1/forms?page=2"},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.port = tt.port
+		app := newTestApplication(t, nil, cfg)
+
+		r := httptest.NewRequest(http.MethodGet, "/v1/forms?page=2", nil)
+		r.Host = tt.host
+		rr := httptest.NewRecorder()
+		app.redirectToHTTPS().ServeHTTP(rr, r)
+
+		if rr.Code != http.StatusPermanentRedirect || rr.Header().Get("Location") != tt.want {
+			t.Errorf("port %d host %q: got %d to %q, want a redirect to %q", tt.port, tt.host, rr.Code, rr.Header().Get("Location"), tt.want)
+		}
+	}
+}
+
+func TestSecureHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		tls        bool
+		subdomains bool
+		want       string
+	}{
+		{"plain http", false, true, ""},
+		{"https", true, false, "max-age=3600"},
+		{"https with subdomains", true, true, "max-age=3600; includeSubDomains"},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.tls.hstsMaxAge = time.Hour
+		cfg.tls.hstsSubdomains = tt.subdomains
+		app := newTestApplication(t, nil, cfg)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		rr := httptest.NewRecorder()
+		app.secureHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, r)
+
+		if got := rr.Header().Get("Strict-Transport-Security"); got != tt.want {
+			t.Errorf("%s: got Strict-Transport-Security %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := writeSelfSignedCert(t, certFile, keyFile, 1)
+
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApplication(t, nil, testConfig())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: app.routes()}
+	go srv.Serve(tls.NewListener(l, newTLSConfig(certs)))
+	t.Cleanup(func() { srv.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(first)
+	//a new connection for every request so each one sees the current certificate
+	transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, DisableKeepAlives: true}
+	client := &http.Client{Transport: transport}
+
+	get := func() *http.Response {
+		t.Helper()
+		res, err := client.Get("https://" + l.Addr().String() + "/v1/healthcheck")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	res := get()
+	if res.StatusCode != http.StatusOK || res.TLS.PeerCertificates[0].SerialNumber.Int64() != 1 {
+		t.Fatalf("got status %d with certificate %s", res.StatusCode, res.TLS.PeerCertificates[0].SerialNumber)
+	}
+	if res.Header.Get("Strict-Transport-Security") == "" {
+		t.Error("no Strict-Transport-Security header over https")
+	}
+
+	//a renewed certificate is picked up without a restart
+	time.Sleep(10 * time.Millisecond)
+	second := writeSelfSignedCert(t, certFile, keyFile, 2)
+	roots.AddCert(second)
+	if !certs.changed() {
+		t.Fatal("the renewed certificate wasn't noticed")
+	}
+	err = certs.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := get(); res.TLS.PeerCertificates[0].SerialNumber.Int64() != 2 {
+		t.Errorf("got certificate %s after reloading, want 2", res.TLS.PeerCertificates[0].SerialNumber)
+	}
+
+	//a broken renewal leaves the current certificate in place
+	err = os.WriteFile(keyFile, []byte("not a key"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := certs.reload(); err == nil {
+		t.Error("reloaded a broken key")
+	}
+	if res := get(); res.TLS.PeerCertificates[0].SerialNumber.Int64() != 2 {
+		t.Errorf("got certificate %s after a failed reload, want 2", res.TLS.PeerCertificates[0].SerialNumber)
+	}
+}
@@ -0,0 +1,339 @@
+// BIOAFF/backend/cmd/api/tokens.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/mailer"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// token lifetimes
+const (
+	authenticationTTL = 24 * time.Hour
+	twoFactorTTL      = 5 * time.Minute
+	passwordResetTTL  = 45 * time.Minute
+)
+
+// passwordResetResponseTime - how long a password reset request takes at least, so the response
+// doesn't give away whether any accounts have the email
+const passwordResetResponseTime = 500 * time.Millisecond
+
+// createAuthenticationTokenHandler() - logs a user in with their email and password, failed attempts are
+// throttled per account and per client ip so passwords can't be guessed or stuffed
+func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Kind     string `json:"kind"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Kind == "" {
+		input.Kind = data.UserKindPublic
+	}
+
+	v := validator.New()
+	v.Check(input.Email != "", "email", "must be provided")
+	v.Check(validator.Matches(input.Email, validator.EmailRX), "email", "must be a valid email address")
+	v.Check(input.Password != "", "password", "must be provided")
+	v.Check(validator.In(input.Kind, data.UserKindAdmin, data.UserKindPublic), "kind", "must be admin or public")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	accountKey := data.AccountThrottleKey(input.Kind, input.Email)
+	ipKey := data.IPThrottleKey(app.contextGetClientIP(r).String())
+
+	//a throttled login is refused before the password is checked, so guesses made while locked out tell nothing
+	wait, err := app.loginWait(accountKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.loginThrottledResponse(w, r, wait)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Kind, input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	match := false
+	if user == nil {
+		data.SimulatePasswordMatch(input.Password)
+	} else {
+		match, err = user.Password.Matches(input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !match {
+		err = app.recordLoginFailure(r, user, accountKey, ipKey)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	//once staff are linked to the directory their password, which may still be known to someone, is no use
+	linked, err := app.ssoLinked(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if linked {
+		app.ssoRequiredResponse(w, r)
+		return
+	}
+
+	//accounts from before passwords were hashed get a hash the first time they log in
+	if user.Password.IsLegacy() {
+		err = app.upgradePassword(user, input.Password)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	//with two-factor on the password only earns a short-lived token to send along with a code, the failures
+	//aren't cleared until then so codes can't be guessed in between logging in with the password
+	enabled, err := app.models.TOTP.Enabled(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if enabled {
+		token, err := app.models.Tokens.New(user, twoFactorTTL, data.ScopeTwoFactor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env := envelope{"two_factor_token": token, "message": "enter the code from your authenticator app, or a recovery code"}
+		err = app.writeJSON(w, http.StatusOK, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.completeLogin(w, r, user, accountKey, false)
+}
+
+// createTwoFactorAuthenticationTokenHandler() - the second step of logging in with two-factor on, trades the
+// token from the first step and a code from the authenticator app, or a recovery code, for a session
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	validateSecondFactor(v, input.Code, input.RecoveryCode)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactor, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired two-factor token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//wrong codes count as failed logins, so they are throttled and lock the account out the same way
+	accountKey := data.AccountThrottleKey(user.Kind, user.Email)
+	ipKey := data.IPThrottleKey(app.contextGetClientIP(r).String())
+
+	wait, err := app.loginWait(accountKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.loginThrottledResponse(w, r, wait)
+		return
+	}
+
+	ok, err := app.checkSecondFactor(user, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		err = app.recordLoginFailure(r, user, accountKey, ipKey)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactor, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user, accountKey, true)
+}
+
+// completeLogin() - forgets the account's failed logins and issues a session token, or in the jwt auth mode
+// an access token and the refresh token to get the next one with, mfa records that a second factor was checked
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User, accountKey string, mfa bool) {
+	err := app.models.LoginThrottle.Clear(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.config.auth.mode == authModeJWT {
+		refresh, err := app.models.RefreshTokens.New(user, app.config.jwt.refreshTTL, app.contextGetClientIP(r).String(), r.UserAgent(), mfa)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.writeTokenPair(w, r, user, refresh)
+		return
+	}
+
+	token, err := app.models.Tokens.NewSession(user, authenticationTTL, app.contextGetClientIP(r).String(), r.UserAgent(), mfa)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// upgradePassword() - hashes a legacy plaintext password which has just been checked,
+// losing a race with a password reset is fine as the reset set a hash too
+func (app *application) upgradePassword(user *data.User, plaintextPassword string) error {
+	err := user.Password.Set(plaintextPassword)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Users.UpgradePassword(user)
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		return err
+	}
+	return nil
+}
+
+// createPasswordResetTokenHandler() - emails a password reset token to the accounts with the given email,
+// the response is the same whether or not there are any so it can't be used to find out which emails exist
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Email != "", "email", "must be provided")
+	v.Check(validator.Matches(input.Email, validator.EmailRX), "email", "must be a valid email address")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the request is padded to the same length whether or not any accounts were found
+	started := time.Now()
+	err = app.queuePasswordResetEmails(input.Email)
+	time.Sleep(time.Until(started.Add(passwordResetResponseTime)))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// queuePasswordResetEmails() - queues a password reset email for every account, staff or applicant, with the address
+func (app *application) queuePasswordResetEmails(address string) error {
+	for _, kind := range []string{data.UserKindAdmin, data.UserKindPublic} {
+		user, err := app.models.Users.GetByEmail(kind, address)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				continue
+			}
+			return err
+		}
+
+		token, err := app.models.Tokens.New(user, passwordResetTTL, data.ScopePasswordReset)
+		if err != nil {
+			return err
+		}
+		language, err := app.userLanguage(user)
+		if err != nil {
+			return err
+		}
+
+		err = app.models.Outbox.Insert(&data.Email{
+			Recipient: user.Email,
+			Template:  mailer.TemplateFor("password_reset", language),
+			Data: map[string]interface{}{
+				"passwordResetToken": token.Plaintext,
+				"minutes":            int(passwordResetTTL.Minutes()),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userLanguage() - the language to email the user in, only applicants choose theirs
+func (app *application) userLanguage(user *data.User) (string, error) {
+	if user.Kind != data.UserKindPublic {
+		return mailer.Languages[0], nil
+	}
+	prefs, err := app.models.Notifications.GetPreferences(user.ID)
+	if err != nil {
+		return "", err
+	}
+	return prefs.Language, nil
+}
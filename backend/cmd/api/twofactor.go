@@ -0,0 +1,377 @@
+// BIOAFF/backend/cmd/api/twofactor.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/totp"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// codeRX - a code from an authenticator app
+var codeRX = regexp.MustCompile(`^[0-9]{6}$`)
+
+// requiresTwoFactor() - reports if the permissions include one the two-factor policy guards
+func (app *application) requiresTwoFactor(permissions data.Permissions) bool {
+	for _, code := range app.config.twoFactor.requiredPermissions {
+		if permissions.Include(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSecondFactor() - checks that exactly one of a code and a recovery code was given
+func validateSecondFactor(v *validator.Validator, code, recoveryCode string) {
+	v.Check(code != "" || recoveryCode != "", "code", "must be provided, or a recovery code instead")
+	v.Check(code == "" || recoveryCode == "", "recovery_code", "must not be provided along with a code")
+	v.Check(code == "" || validator.Matches(code, codeRX), "code", "must be 6 digits")
+	v.Check(len(recoveryCode) <= 20, "recovery_code", "must not be more than 20 bytes long")
+}
+
+// checkSecondFactor() - checks a code from the user's authenticator app, or one of their recovery codes, using it
+// up so it can't be replayed. Users without two-factor turned on have nothing to match.
+func (app *application) checkSecondFactor(user *data.User, code, recoveryCode string) (bool, error) {
+	if user.Kind != data.UserKindAdmin {
+		return false, nil
+	}
+
+	t, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	if !t.Enabled() {
+		return false, nil
+	}
+
+	if code != "" {
+		step, ok := totp.Default.Validate(t.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		err = app.models.TOTP.UseStep(user.ID, step)
+		if errors.Is(err, data.ErrEditConflict) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	err = app.models.TOTP.UseRecoveryCode(user.ID, recoveryCode)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// showTwoFactorHandler() - whether the admin has two-factor on, has to have it on, and how many recovery codes are left
+func (app *application) showTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if user.Kind != data.UserKindAdmin {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	enabled, err := app.models.TOTP.Enabled(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	remaining := 0
+	if enabled {
+		remaining, err = app.models.TOTP.RemainingRecoveryCodes(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"two_factor": map[string]interface{}{
+		"enabled":                  enabled,
+		"required":                 app.requiresTwoFactor(permissions),
+		"recovery_codes_remaining": remaining,
+	}}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createTwoFactorHandler() - starts turning two-factor on, the secret is returned once for the admin to add
+// to their authenticator app and does nothing until a code from it is confirmed
+func (app *application) createTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if user.Kind != data.UserKindAdmin {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Password != "", "password", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the user of a JWT access token comes from its claims, without the password hash
+	user, err = app.models.Users.Get(user.Kind, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//a stolen session alone mustn't be enough to tie the account to someone else's authenticator
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.TOTP.Begin(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.twoFactorEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"two_factor": map[string]interface{}{
+		"secret": totp.EncodeSecret(secret),
+		"uri":    totp.Default.URI(app.config.twoFactor.issuer, user.Email, secret),
+	}}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTwoFactorHandler() - turns two-factor on with a code from the authenticator app,
+// the recovery codes are returned this once and never again
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if user.Kind != data.UserKindAdmin {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.Matches(input.Code, codeRX), "code", "must be 6 digits")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	t, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if t.Enabled() {
+		app.twoFactorEnabledResponse(w, r)
+		return
+	}
+
+	step, ok := totp.Default.Validate(t.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "is incorrect, check the time on your device")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.TOTP.Confirm(user.ID, step, codes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.twoFactorEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.contextGetLogger(r).PrintInfo("two-factor authentication turned on", nil)
+
+	env := envelope{"recovery_codes": codes, "message": "keep these recovery codes somewhere safe, each can be used once instead of a code"}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// regenerateRecoveryCodesHandler() - replaces the admin's recovery codes, for when they run low or are lost
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if user.Kind != data.UserKindAdmin {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if validateSecondFactor(v, input.Code, input.RecoveryCode); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ok, err := app.checkSecondFactor(user, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	codes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.TOTP.RegenerateRecoveryCodes(user.ID, codes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"recovery_codes": codes, "message": "your previous recovery codes no longer work"}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTwoFactorHandler() - turns two-factor off with a code, unless the admin's permissions require it
+func (app *application) deleteTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if user.Kind != data.UserKindAdmin {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if validateSecondFactor(v, input.Code, input.RecoveryCode); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.requiresTwoFactor(permissions) {
+		message := "your user account's permissions require two-factor authentication, it can't be turned off"
+		app.errorResponse(w, r, http.StatusForbidden, codeTwoFactorRequired, message, nil)
+		return
+	}
+
+	ok, err := app.checkSecondFactor(user, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.contextGetLogger(r).PrintInfo("two-factor authentication turned off", nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication was turned off"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
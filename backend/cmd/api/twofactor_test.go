@@ -0,0 +1,183 @@
+// BIOAFF/backend/cmd/api/twofactor_test.go
+package main
+
+import (
+	"encoding/base32"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+	"github.com/jinzhu/gorm/backend/internal/totp"
+)
+
+// adminLogin() - the first step of logging in to a staff account
+func adminLogin(t *testing.T, ts *testServer, email, password string) testResponse {
+	t.Helper()
+	return ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{"email": email, "password": password, "kind": "admin"})
+}
+
+// twoFactorToken() - logs in with the password and returns the token for the second step
+func twoFactorToken(t *testing.T, ts *testServer, email, password string) string {
+	t.Helper()
+
+	res := adminLogin(t, ts, email, password)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want 200 and a two-factor token: %s", res.status, res.body)
+	}
+	var body struct {
+		Token data.Token `json:"two_factor_token"`
+	}
+	res.decode(t, &body)
+	return body.Token.Plaintext
+}
+
+func TestTwoFactorEnrollment(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	admin := insertUser(t, app, data.UserKindAdmin, "staff@example.com", "logs:read")
+	_, err := app.db.Exec("UPDATE admin_users SET au_password = 'Staff-Pass-123' WHERE id = $1", admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := authenticationToken(t, app, admin)
+
+	res := ts.do(t, http.MethodPost, "/v1/users/me/two-factor", session, map[string]string{"password": "wrong-password"})
+	if res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d with the wrong password, want 401", res.status)
+	}
+	res = ts.do(t, http.MethodPost, "/v1/users/me/two-factor", session, map[string]string{"password": "Staff-Pass-123"})
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", res.status, res.body)
+	}
+	var setup struct {
+		TwoFactor struct {
+			Secret string `json:"secret"`
+			URI    string `json:"uri"`
+		} `json:"two_factor"`
+	}
+	res.decode(t, &setup)
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(setup.TwoFactor.Secret)
+	if err != nil || setup.TwoFactor.URI == "" {
+		t.Fatalf("got secret %q (%v) and uri %q", setup.TwoFactor.Secret, err, setup.TwoFactor.URI)
+	}
+
+	//logins don't need a code until it is confirmed
+	if res := adminLogin(t, ts, admin.Email, "Staff-Pass-123"); res.status != http.StatusCreated {
+		t.Errorf("got status %d before confirming, want 201", res.status)
+	}
+
+	now := time.Now()
+	step := totp.Default.Step(now)
+	res = ts.do(t, http.MethodPost, "/v1/users/me/two-factor/confirm", session, map[string]string{"code": totp.Default.Code(secret, step+5)})
+	if res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d with a wrong code, want 422", res.status)
+	}
+	res = ts.do(t, http.MethodPost, "/v1/users/me/two-factor/confirm", session, map[string]string{"code": totp.Default.Code(secret, step)})
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d confirming, want 200: %s", res.status, res.body)
+	}
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	res.decode(t, &confirmed)
+	if len(confirmed.RecoveryCodes) != data.RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(confirmed.RecoveryCodes), data.RecoveryCodeCount)
+	}
+	if res := ts.do(t, http.MethodPost, "/v1/users/me/two-factor", session, map[string]string{"password": "Staff-Pass-123"}); res.status != http.StatusConflict {
+		t.Errorf("got status %d starting again while it is on, want 409", res.status)
+	}
+
+	//the password alone now only earns a two-factor token, which isn't a session
+	token := twoFactorToken(t, ts, admin.Email, "Staff-Pass-123")
+	if res := ts.do(t, http.MethodGet, "/v1/admin/log-level", token, nil); res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d using the two-factor token as a session, want 401", res.status)
+	}
+
+	second := func(token, code, recoveryCode string) testResponse {
+		return ts.do(t, http.MethodPost, "/v1/tokens/two-factor", "", map[string]string{"token": token, "code": code, "recovery_code": recoveryCode})
+	}
+	if res := second(token, totp.Default.Code(secret, step), ""); res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d replaying the confirmation code, want 401", res.status)
+	}
+	if res := second(token, totp.Default.Code(secret, step+1), ""); res.status != http.StatusCreated {
+		t.Fatalf("got status %d with the next code, want 201: %s", res.status, res.body)
+	}
+	if res := second(token, totp.Default.Code(secret, step+1), ""); res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d reusing the two-factor token, want 422", res.status)
+	}
+
+	//each recovery code works once, however it is typed
+	token = twoFactorToken(t, ts, admin.Email, "Staff-Pass-123")
+	code := confirmed.RecoveryCodes[0]
+	if res := second(token, "", " "+code[:5]+code[6:]+" "); res.status != http.StatusCreated {
+		t.Fatalf("got status %d with a recovery code, want 201: %s", res.status, res.body)
+	}
+	token = twoFactorToken(t, ts, admin.Email, "Staff-Pass-123")
+	if res := second(token, "", code); res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d reusing a recovery code, want 401", res.status)
+	}
+
+	res = ts.do(t, http.MethodGet, "/v1/users/me/two-factor", session, nil)
+	var status struct {
+		TwoFactor struct {
+			Enabled   bool `json:"enabled"`
+			Required  bool `json:"required"`
+			Remaining int  `json:"recovery_codes_remaining"`
+		} `json:"two_factor"`
+	}
+	res.decode(t, &status)
+	if !status.TwoFactor.Enabled || status.TwoFactor.Required || status.TwoFactor.Remaining != data.RecoveryCodeCount-1 {
+		t.Errorf("got %+v, want enabled, not required, with one recovery code used", status.TwoFactor)
+	}
+
+	res = ts.do(t, http.MethodDelete, "/v1/users/me/two-factor", session, map[string]string{"recovery_code": confirmed.RecoveryCodes[1]})
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d turning it off, want 200: %s", res.status, res.body)
+	}
+	if res := adminLogin(t, ts, admin.Email, "Staff-Pass-123"); res.status != http.StatusCreated {
+		t.Errorf("got status %d once turned off, want 201", res.status)
+	}
+}
+
+func TestTwoFactorPolicy(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	verifier := insertUser(t, app, data.UserKindAdmin, "reviewer@example.com", "forms:verify", "logs:read")
+	session := authenticationToken(t, app, verifier)
+
+	//every permission is held back until two-factor is on, not just the guarded one
+	for _, path := range []string{"/v1/admin/log-level", "/v1/forms/1/status"} {
+		method := http.MethodGet
+		if path == "/v1/forms/1/status" {
+			method = http.MethodPatch
+		}
+		res := ts.do(t, method, path, session, map[string]string{"status": "verified"})
+		var problem problem
+		res.decode(t, &problem)
+		if res.status != http.StatusForbidden || problem.Code != codeTwoFactorRequired {
+			t.Errorf("%s: got status %d %q, want 403 %s", path, res.status, problem.Code, codeTwoFactorRequired)
+		}
+	}
+
+	secret, _ := enableTwoFactor(t, app, verifier)
+	if res := ts.do(t, http.MethodGet, "/v1/admin/log-level", session, nil); res.status != http.StatusOK {
+		t.Errorf("got status %d with two-factor on, want 200", res.status)
+	}
+
+	//and it can't be turned off again while the permission is held
+	code := totp.Default.Code(secret, totp.Default.Step(time.Now()))
+	res := ts.do(t, http.MethodDelete, "/v1/users/me/two-factor", session, map[string]string{"code": code})
+	if res.status != http.StatusForbidden {
+		t.Errorf("got status %d turning off required two-factor, want 403", res.status)
+	}
+
+	//applicants can't turn it on
+	applicant := authenticationToken(t, app, insertUser(t, app, data.UserKindPublic, "applicant@example.com"))
+	if res := ts.do(t, http.MethodPost, "/v1/users/me/two-factor", applicant, map[string]string{"password": "whatever"}); res.status != http.StatusForbidden {
+		t.Errorf("got status %d for an applicant, want 403", res.status)
+	}
+}
@@ -0,0 +1,86 @@
+// BIOAFF/backend/cmd/api/users.go
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/mailer"
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// updateUserPasswordHandler() - sets a new password with a password reset token, which signs the user out everywhere
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//the strength rules need to know whose password it is
+	if data.ValidatePasswordStrength(v, input.Password, user.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	language, err := app.userLanguage(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	confirmation := &data.Email{
+		Recipient: user.Email,
+		Template:  mailer.TemplateFor("password_changed", language),
+	}
+
+	err = app.models.Users.ResetPassword(user, confirmation)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.contextGetLogger(r).PrintInfo("password reset", map[string]interface{}{"user_id": user.ID, "user_kind": user.Kind})
+
+	env := envelope{"message": "your password was successfully reset"}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
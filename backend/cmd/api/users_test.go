@@ -0,0 +1,132 @@
+// BIOAFF/backend/cmd/api/users_test.go
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/data"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+// requestPasswordReset() - asks for a password reset and returns the token emailed to the account, if any
+func requestPasswordReset(t *testing.T, app *testApp, ts *testServer, email string) (string, string) {
+	t.Helper()
+
+	before := len(app.mailer.emails())
+	started := time.Now()
+	res := ts.do(t, http.MethodPost, "/v1/tokens/password-reset", "", map[string]string{"email": email})
+	if res.status != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202", res.status)
+	}
+	if took := time.Since(started); took < passwordResetResponseTime {
+		t.Errorf("the request took %s, want it padded to %s", took, passwordResetResponseTime)
+	}
+	drainOutbox(t, app)
+
+	emails := app.mailer.emails()[before:]
+	if len(emails) == 0 {
+		return "", ""
+	}
+	token, _ := emails[0].data.(map[string]interface{})["passwordResetToken"].(string)
+	return token, emails[0].templateFile
+}
+
+func TestPasswordReset(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	admin := insertUser(t, app, data.UserKindAdmin, "staff@example.com", "logs:read")
+	session := authenticationToken(t, app, admin)
+
+	//unknown emails get the same answer and no email
+	if token, _ := requestPasswordReset(t, app, ts, "nobody@example.com"); token != "" {
+		t.Fatal("emailed an address without an account")
+	}
+
+	token, template := requestPasswordReset(t, app, ts, "STAFF@example.com")
+	if token == "" || template != "password_reset.en.tmpl" {
+		t.Fatalf("got token %q with %s, want one in the english template", token, template)
+	}
+
+	weak := []struct {
+		name     string
+		password string
+	}{
+		{"too short", "Ab1!"},
+		{"one kind of character", "aaaaaaaaaaaaaa"},
+		{"contains the email", "Staff-12345678"},
+	}
+	for _, tt := range weak {
+		res := ts.do(t, http.MethodPut, "/v1/users/password", "", map[string]string{"password": tt.password, "token": token})
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d, want 422", tt.name, res.status)
+		}
+	}
+
+	before := len(app.mailer.emails())
+	res := ts.do(t, http.MethodPut, "/v1/users/password", "", map[string]string{"password": "Correct-Horse-42", "token": token})
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", res.status, res.body)
+	}
+
+	//the reset signs the account out everywhere and tells the owner
+	if res := ts.do(t, http.MethodGet, "/v1/admin/log-level", session, nil); res.status != http.StatusUnauthorized {
+		t.Errorf("got status %d with a token issued before the reset, want 401", res.status)
+	}
+	drainOutbox(t, app)
+	if emails := app.mailer.emails()[before:]; len(emails) != 1 || emails[0].templateFile != "password_changed.en.tmpl" {
+		t.Errorf("got emails %+v, want the confirmation", emails)
+	}
+
+	user, err := app.models.Users.Get(data.UserKindAdmin, admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := user.Password.Matches("Correct-Horse-42"); !ok || err != nil {
+		t.Errorf("the new password doesn't match: %v", err)
+	}
+
+	//the token can only be used once
+	res = ts.do(t, http.MethodPut, "/v1/users/password", "", map[string]string{"password": "Another-Horse-43", "token": token})
+	if res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d reusing the token, want 422", res.status)
+	}
+
+	res = ts.do(t, http.MethodPost, "/v1/tokens/password-reset", "", map[string]string{"email": "not an email"})
+	if res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for a malformed email, want 422", res.status)
+	}
+}
+
+func TestPasswordResetPublicUser(t *testing.T) {
+	app := newTestApplication(t, testdb.Open(t), testConfig())
+	ts := newTestServer(t, app)
+
+	applicant := insertUser(t, app, data.UserKindPublic, "applicant@example.com")
+	err := app.models.Notifications.UpdatePreferences(applicant.ID, &data.Preferences{FormStatusEmails: true, Language: "es"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, template := requestPasswordReset(t, app, ts, applicant.Email)
+	if token == "" || template != "password_reset.es.tmpl" {
+		t.Fatalf("got token %q with %s, want one in the spanish template", token, template)
+	}
+
+	res := ts.do(t, http.MethodPut, "/v1/users/password", "", map[string]string{"password": "Caballo-Correcto-7", "token": token})
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", res.status, res.body)
+	}
+
+	//the legacy plaintext password is gone once a hash is set
+	var legacy string
+	err = app.db.QueryRow("SELECT pu_password FROM public_user WHERE id = $1", applicant.ID).Scan(&legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy != "" {
+		t.Errorf("got legacy password %q, want it cleared", legacy)
+	}
+}
@@ -1,3 +1,5 @@
+package data
+
 /*package main
 
 import (
@@ -201,4 +203,4 @@ func getAdminUser( w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(&admin_user)
 }
 
-*/
\ No newline at end of file
+*/
@@ -0,0 +1,415 @@
+// BIOAFF/backend/internal/data/apikeys.go
+
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/validator"
+	"github.com/lib/pq"
+)
+
+// apiKeyTag - API keys start with it, so a key pasted somewhere it shouldn't be is easy for secret scanners to spot
+const apiKeyTag = "bioaff"
+
+// apiKeyEncoding - lowercase unpadded base32, the keys never contain the underscores separating their parts
+var apiKeyEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// APIKey is a key a partner agency's system calls the api with, it belongs to no account and holds
+// its own permissions and rate limit. The plaintext is only known when the key is created or rotated.
+type APIKey struct {
+	ID             int64       `json:"id"`
+	Name           string      `json:"name"`
+	Prefix         string      `json:"prefix"` //identifies the key in lists and logs, it is not a secret
+	Plaintext      string      `json:"key,omitempty"`
+	Permissions    Permissions `json:"permissions"`
+	RateLimitRPS   float64     `json:"rate_limit_rps"`
+	RateLimitBurst int         `json:"rate_limit_burst"`
+	CreatedBy      int64       `json:"created_by,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+	ExpiresAt      *time.Time  `json:"expires_at,omitempty"`
+	RotatedAt      *time.Time  `json:"rotated_at,omitempty"`
+	PreviousExpiry *time.Time  `json:"previous_expiry,omitempty"` //until when the secret from before the last rotation still works
+	RevokedAt      *time.Time  `json:"revoked_at,omitempty"`
+	LastUsedAt     *time.Time  `json:"last_used_at,omitempty"`
+	LastUsedIP     string      `json:"last_used_ip,omitempty"`
+	Version        int         `json:"version"`
+}
+
+// APIKeyActivity is the latest use of a key, saved in batches like the sessions' activity
+type APIKeyActivity struct {
+	ID     int64
+	UsedAt time.Time
+	IP     string
+}
+
+// ValidateAPIKey() - checks the name, permissions and rate limit of a key, permissions must be ones keys may hold
+func ValidateAPIKey(v *validator.Validator, key *APIKey, allowed []string) {
+	v.Check(strings.TrimSpace(key.Name) != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least one permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range key.Permissions {
+		v.Check(validator.In(code, allowed...), "permissions", "must only contain permissions API keys may hold: "+strings.Join(allowed, ", "))
+	}
+	v.Check(key.RateLimitRPS > 0, "rate_limit_rps", "must be greater than zero")
+	v.Check(key.RateLimitRPS <= 1000, "rate_limit_rps", "must not be more than 1000")
+	v.Check(key.RateLimitBurst > 0, "rate_limit_burst", "must be greater than zero")
+	v.Check(key.RateLimitBurst <= 10000, "rate_limit_burst", "must not be more than 10000")
+	v.Check(key.ExpiresAt == nil || key.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+}
+
+// generateAPIKeySecret() - a random secret and the plaintext key made from it and the prefix
+func generateAPIKeySecret(prefix string) (string, []byte, error) {
+	//32 random bytes encode to 52 characters
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	secret := apiKeyEncoding.EncodeToString(randomBytes)
+	return apiKeyTag + "_" + prefix + "_" + secret, HashToken(secret), nil
+}
+
+// generateAPIKeyPrefix() - a random prefix, 5 bytes encode to 8 characters
+func generateAPIKeyPrefix() (string, error) {
+	randomBytes := make([]byte, 5)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyEncoding.EncodeToString(randomBytes), nil
+}
+
+// ParseAPIKey() - splits a plaintext key into its prefix and secret, ok is false when it isn't a key we generated
+func ParseAPIKey(plaintext string) (prefix, secret string, ok bool) {
+	parts := strings.Split(plaintext, "_")
+	if len(parts) != 3 || parts[0] != apiKeyTag || len(parts[1]) != 8 || len(parts[2]) != 52 {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// APIKeyModel wraps the connection pool for the api_keys tables
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// apiKeyColumns - the columns every query returning keys selects, in the order scanAPIKey reads them
+const apiKeyColumns = `
+	k.id, k.name, k.prefix, k.rate_limit_rps, k.rate_limit_burst, COALESCE(k.created_by, 0), k.created_at,
+	k.expires_at, k.rotated_at, k.previous_expiry, k.revoked_at, k.last_used_at, COALESCE(k.last_used_ip, ''), k.version,
+	ARRAY(SELECT p.code FROM permissions p INNER JOIN api_keys_permissions kp ON kp.permission_id = p.id WHERE kp.api_key_id = k.id ORDER BY p.code)`
+
+// scanner - a row or rows being scanned
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey() - reads the apiKeyColumns, followed by any extra destinations
+func scanAPIKey(row scanner, key *APIKey, extra ...interface{}) error {
+	dest := []interface{}{
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.RateLimitRPS,
+		&key.RateLimitBurst,
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&key.RotatedAt,
+		&key.PreviousExpiry,
+		&key.RevokedAt,
+		&key.LastUsedAt,
+		&key.LastUsedIP,
+		&key.Version,
+		pq.Array((*[]string)(&key.Permissions)),
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// Insert() - creates the key with a new prefix and secret, recording the event in the same transaction
+func (m APIKeyModel) Insert(key *APIKey, event *AuditEvent) error {
+	prefix, err := generateAPIKeyPrefix()
+	if err != nil {
+		return err
+	}
+	plaintext, hash, err := generateAPIKeySecret(prefix)
+	if err != nil {
+		return err
+	}
+	key.Prefix = prefix
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO api_keys (name, prefix, secret_hash, rate_limit_rps, rate_limit_burst, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7)
+		RETURNING id, created_at, version`
+	args := []interface{}{key.Name, prefix, hash, key.RateLimitRPS, key.RateLimitBurst, key.CreatedBy, key.ExpiresAt}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt, &key.Version)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO api_keys_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	_, err = tx.ExecContext(ctx, query, key.ID, pq.Array(key.Permissions))
+	if err != nil {
+		return err
+	}
+
+	event.Details = apiKeyEventDetails(key, event.Details)
+	err = insertAuditEvent(ctx, tx, event)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	key.Plaintext = plaintext
+	return nil
+}
+
+// GetAll() - every key, the revoked ones included, newest first
+func (m APIKeyModel) GetAll() ([]*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys k
+		ORDER BY k.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := scanAPIKey(rows, &key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Get() - finds a key by its id
+func (m APIKeyModel) Get(id int64) (*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys k
+		WHERE k.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	err := scanAPIKey(m.DB.QueryRowContext(ctx, query, id), &key)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+// GetForKey() - the key a request was made with, ErrRecordNotFound when it isn't a key, is wrong,
+// revoked or expired. After a rotation the previous secret works until previous_expiry.
+func (m APIKeyModel) GetForKey(plaintext string) (*APIKey, error) {
+	prefix, secret, ok := ParseAPIKey(plaintext)
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + apiKeyColumns + `, k.secret_hash,
+			CASE WHEN k.previous_expiry > NOW() THEN k.previous_secret_hash END
+		FROM api_keys k
+		WHERE k.prefix = $1
+		AND k.revoked_at IS NULL
+		AND (k.expires_at IS NULL OR k.expires_at > NOW())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	var hash, previousHash []byte
+	err := scanAPIKey(m.DB.QueryRowContext(ctx, query, prefix), &key, &hash, &previousHash)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	//the prefix isn't secret, so the secret is compared in constant time
+	given := HashToken(secret)
+	if subtle.ConstantTimeCompare(given, hash) == 1 {
+		return &key, nil
+	}
+	if previousHash != nil && subtle.ConstantTimeCompare(given, previousHash) == 1 {
+		return &key, nil
+	}
+	return nil, ErrRecordNotFound
+}
+
+// Rotate() - gives the key a new secret, the current one keeps working for the grace period so the agency
+// can switch over without an outage. ErrEditConflict means the key changed or was revoked in the meantime.
+func (m APIKeyModel) Rotate(key *APIKey, grace time.Duration, event *AuditEvent) error {
+	plaintext, hash, err := generateAPIKeySecret(key.Prefix)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//a rotation with no grace period shuts the previous secret out straight away
+	query := `
+		UPDATE api_keys
+		SET previous_secret_hash = CASE WHEN $3::integer > 0 THEN secret_hash END,
+			previous_expiry = CASE WHEN $3::integer > 0 THEN NOW() + make_interval(secs => $3::integer) END,
+			secret_hash = $4, rotated_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $2 AND revoked_at IS NULL
+		RETURNING rotated_at, previous_expiry, version`
+	args := []interface{}{key.ID, key.Version, int64(grace.Seconds()), hash}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&key.RotatedAt, &key.PreviousExpiry, &key.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	event.Details = apiKeyEventDetails(key, event.Details)
+	err = insertAuditEvent(ctx, tx, event)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	key.Plaintext = plaintext
+	return nil
+}
+
+// Revoke() - stops the key working for good, it is kept so the audit log still makes sense.
+// ErrEditConflict means it was already revoked.
+func (m APIKeyModel) Revoke(key *APIKey, event *AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE api_keys
+		SET revoked_at = NOW(), previous_secret_hash = NULL, previous_expiry = NULL, version = version + 1
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING revoked_at, version`
+
+	err = tx.QueryRowContext(ctx, query, key.ID).Scan(&key.RevokedAt, &key.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	key.PreviousExpiry = nil
+
+	event.Details = apiKeyEventDetails(key, event.Details)
+	err = insertAuditEvent(ctx, tx, event)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Touch() - saves a batch of the keys' latest use
+func (m APIKeyModel) Touch(activity []APIKeyActivity) error {
+	if len(activity) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(activity))
+	usedAt := make([]string, len(activity))
+	ips := make([]string, len(activity))
+	for i, a := range activity {
+		ids[i] = a.ID
+		usedAt[i] = a.UsedAt.UTC().Format(time.RFC3339Nano)
+		ips[i] = a.IP
+	}
+
+	//a batch saved late mustn't move last_used_at backwards, nor put back the ip of an older use
+	query := `
+		UPDATE api_keys AS k
+		SET last_used_at = GREATEST(k.last_used_at, u.used_at),
+			last_used_ip = CASE WHEN k.last_used_at IS NULL OR u.used_at >= k.last_used_at THEN COALESCE(NULLIF(u.ip, ''), k.last_used_ip) ELSE k.last_used_ip END
+		FROM unnest($1::bigint[], $2::timestamptz[], $3::text[]) AS u (id, used_at, ip)
+		WHERE k.id = u.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(ids), pq.StringArray(usedAt), pq.StringArray(ips))
+	return err
+}
+
+// apiKeyEventDetails() - the details of an audit event about a key, added to the ones the caller gave
+func apiKeyEventDetails(key *APIKey, details map[string]interface{}) map[string]interface{} {
+	if details == nil {
+		details = make(map[string]interface{})
+	}
+	details["api_key_id"] = key.ID
+	details["api_key_name"] = key.Name
+	details["api_key_prefix"] = key.Prefix
+	return details
+}
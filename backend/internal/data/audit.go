@@ -0,0 +1,64 @@
+// BIOAFF/backend/internal/data/audit.go
+
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// audit events
+const (
+	AuditAccountLocked   = "account_locked"
+	AuditAccountUnlocked = "account_unlocked"
+	AuditIPLocked        = "ip_locked"
+	AuditSessionsRevoked = "sessions_revoked"
+	AuditRefreshReused   = "refresh_token_reused"
+	AuditSSOLinked       = "sso_linked"
+	AuditAPIKeyCreated   = "api_key_created"
+	AuditAPIKeyRotated   = "api_key_rotated"
+	AuditAPIKeyRevoked   = "api_key_revoked"
+)
+
+// AuditEvent records something security relevant that happened to an account,
+// UserID and UserKind name the account and ActorID the admin who did it, either may be zero
+type AuditEvent struct {
+	ID        int64                  `json:"id"`
+	Event     string                 `json:"event"`
+	UserID    int64                  `json:"user_id,omitempty"`
+	UserKind  string                 `json:"user_kind,omitempty"`
+	ActorID   int64                  `json:"actor_id,omitempty"`
+	IP        string                 `json:"ip,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// insertAuditEvent() - records an event, inside the transaction of the change it is about
+func insertAuditEvent(ctx context.Context, q queryer, event *AuditEvent) error {
+	details := event.Details
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	var adminID, publicUserID int64
+	switch event.UserKind {
+	case UserKindAdmin:
+		adminID = event.UserID
+	case UserKindPublic:
+		publicUserID = event.UserID
+	}
+
+	query := `
+		INSERT INTO audit_events (event, admin_id, public_user_id, actor_admin_id, ip, details)
+		VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), NULLIF($4, 0), NULLIF($5, ''), $6)
+		RETURNING id, created_at`
+	//as a string, pq would send a []byte as bytea
+	args := []interface{}{event.Event, adminID, publicUserID, event.ActorID, event.IP, string(data)}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
@@ -0,0 +1,131 @@
+// BIOAFF/backend/internal/data/forms.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// the statuses a form moves through, reviewers move pending forms to verified or returned
+const (
+	FormStatusNew      = "new"
+	FormStatusPending  = "pending"
+	FormStatusVerified = "verified"
+	FormStatusReturned = "returned"
+)
+
+// Form is an affidavit filed by an applicant
+type Form struct {
+	ID              int64     `json:"id"`
+	UserID          int64     `json:"user_id"`
+	FormNumber      int64     `json:"form_number"`
+	Status          string    `json:"status"`
+	Archived        bool      `json:"archived"`
+	AffiantFullName string    `json:"affiant_full_name"`
+	CreatedOn       time.Time `json:"created_on"`
+	Version         int       `json:"version"`
+}
+
+// ValidateStatusChange() - checks that a reviewer may move the form to status,
+// a returned form must say why so the applicant can fix it
+func ValidateStatusChange(v *validator.Validator, form *Form, status, reason string) {
+	v.Check(validator.In(status, FormStatusVerified, FormStatusReturned), "status", "must be verified or returned")
+	v.Check(validator.In(form.Status, FormStatusNew, FormStatusPending), "status", "only new or pending forms can be reviewed")
+	if status == FormStatusReturned {
+		v.Check(reason != "", "reason", "must be provided when returning a form")
+	}
+	v.Check(len(reason) <= 1000, "reason", "must not be more than 1000 bytes long")
+}
+
+// FormModel wraps the connection pool for the form table
+type FormModel struct {
+	DB *sql.DB
+}
+
+// Get() - finds a form by its id
+func (m FormModel) Get(id int64) (*Form, error) {
+	query := `
+		SELECT id, user_id, form_id, form_status, archive_status, affiant_full_name, created_on, version
+		FROM form
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var form Form
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&form.ID,
+		&form.UserID,
+		&form.FormNumber,
+		&form.Status,
+		&form.Archived,
+		&form.AffiantFullName,
+		&form.CreatedOn,
+		&form.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &form, nil
+}
+
+// UpdateStatus() - saves the form's new status, records the review in the form's history and the applicant's
+// notification, and queues the email when there is one. Everything is written in one transaction so the email
+// goes out if and only if the review is saved, ErrEditConflict means the form changed since it was read
+func (m FormModel) UpdateStatus(form *Form, reviewerID int64, comment string, n *Notification, email *Email) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE form
+		SET form_status = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+	err = tx.QueryRowContext(ctx, query, form.Status, form.ID, form.Version).Scan(&form.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query = `
+		INSERT INTO history (admin_id, form_id, comments)
+		VALUES ($1, $2, $3)`
+	_, err = tx.ExecContext(ctx, query, reviewerID, form.ID, comment)
+	if err != nil {
+		return err
+	}
+
+	err = insertNotification(ctx, tx, n)
+	if err != nil {
+		return err
+	}
+	if email != nil {
+		email.NotificationID = n.ID
+		err = insertEmail(ctx, tx, email)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
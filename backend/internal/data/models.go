@@ -0,0 +1,48 @@
+// BIOAFF/backend/internal/data/models.go
+
+package data
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrRecordNotFound = errors.New("record not found")
+	ErrEditConflict   = errors.New("edit conflict")
+	ErrTokenReused    = errors.New("token reused")
+)
+
+// Models wraps every model, handlers reach them through app.models
+type Models struct {
+	APIKeys       APIKeyModel
+	Forms         FormModel
+	LoginThrottle LoginThrottleModel
+	Notifications NotificationModel
+	Outbox        OutboxModel
+	Permissions   PermissionModel
+	RefreshTokens RefreshTokenModel
+	SSO           SSOModel
+	Sessions      SessionModel
+	TOTP          TOTPModel
+	Tokens        TokenModel
+	Users         UserModel
+}
+
+// NewModels() - creates the models sharing the given connection pool
+func NewModels(db *sql.DB) Models {
+	return Models{
+		APIKeys:       APIKeyModel{DB: db},
+		Forms:         FormModel{DB: db},
+		LoginThrottle: LoginThrottleModel{DB: db},
+		Notifications: NotificationModel{DB: db},
+		Outbox:        OutboxModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		RefreshTokens: RefreshTokenModel{DB: db},
+		SSO:           SSOModel{DB: db},
+		Sessions:      SessionModel{DB: db},
+		TOTP:          TOTPModel{DB: db},
+		Tokens:        TokenModel{DB: db},
+		Users:         UserModel{DB: db},
+	}
+}
@@ -0,0 +1,164 @@
+// BIOAFF/backend/internal/data/notifications.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// the kinds of notification sent to applicants
+const (
+	NotificationFormVerified = "form_verified"
+	NotificationFormReturned = "form_returned"
+)
+
+// the outcome of a notification, queued ones wait in the email outbox
+const (
+	NotificationQueued   = "queued"
+	NotificationSent     = "sent"
+	NotificationFailed   = "failed"
+	NotificationOptedOut = "opted_out"
+)
+
+// Notification records an email we sent, or chose not to send, to an applicant
+type Notification struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	FormID    int64     `json:"form_id,omitempty"`
+	Kind      string    `json:"kind"`
+	Template  string    `json:"template"`
+	Recipient string    `json:"recipient"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Preferences are the applicant's choices about the emails we send them
+type Preferences struct {
+	FormStatusEmails bool   `json:"form_status_emails"`
+	Language         string `json:"language"`
+}
+
+// ValidatePreferences() - checks the language is one our templates are written in
+func ValidatePreferences(v *validator.Validator, prefs *Preferences, languages ...string) {
+	v.Check(validator.In(prefs.Language, languages...), "language", "must be one of the supported languages")
+}
+
+// NotificationModel wraps the connection pool for the notifications table and the applicants' preferences
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+// insertNotification() - records a notification, inside the transaction of the change it is about when q is one
+func insertNotification(ctx context.Context, q queryer, n *Notification) error {
+	query := `
+		INSERT INTO notifications (public_user_id, form_id, kind, template, recipient, status, error)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, NULLIF($7, ''))
+		RETURNING id, created_at`
+	args := []interface{}{n.UserID, n.FormID, n.Kind, n.Template, n.Recipient, n.Status, n.Error}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&n.ID, &n.CreatedAt)
+}
+
+// updateNotificationStatus() - records the outcome of a queued notification
+func updateNotificationStatus(ctx context.Context, tx *sql.Tx, id int64, status, errMessage string) error {
+	query := `
+		UPDATE notifications
+		SET status = $1, error = NULLIF($2, '')
+		WHERE id = $3`
+
+	_, err := tx.ExecContext(ctx, query, status, errMessage, id)
+	return err
+}
+
+// Insert() - records a notification
+func (m NotificationModel) Insert(n *Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertNotification(ctx, m.DB, n)
+}
+
+// GetAllForUser() - every notification of an applicant, newest first
+func (m NotificationModel) GetAllForUser(userID int64) ([]*Notification, error) {
+	query := `
+		SELECT id, public_user_id, COALESCE(form_id, 0), kind, template, recipient, status, COALESCE(error, ''), created_at
+		FROM notifications
+		WHERE public_user_id = $1
+		ORDER BY id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*Notification{}
+	for rows.Next() {
+		var n Notification
+		err := rows.Scan(&n.ID, &n.UserID, &n.FormID, &n.Kind, &n.Template, &n.Recipient, &n.Status, &n.Error, &n.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, &n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// GetPreferences() - the applicant's notification preferences
+func (m NotificationModel) GetPreferences(userID int64) (*Preferences, error) {
+	query := `
+		SELECT form_status_emails, language
+		FROM public_user
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var prefs Preferences
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&prefs.FormStatusEmails, &prefs.Language)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &prefs, nil
+}
+
+// UpdatePreferences() - saves the applicant's notification preferences
+func (m NotificationModel) UpdatePreferences(userID int64, prefs *Preferences) error {
+	query := `
+		UPDATE public_user
+		SET form_status_emails = $1, language = $2
+		WHERE id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, prefs.FormStatusEmails, prefs.Language, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
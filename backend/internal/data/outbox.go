@@ -0,0 +1,353 @@
+// BIOAFF/backend/internal/data/outbox.go
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// the statuses of a queued email, sending ones are held by a worker until their lease runs out,
+// dead emails ran out of attempts and wait for an admin to re-send them
+const (
+	EmailPending = "pending"
+	EmailSending = "sending"
+	EmailSent    = "sent"
+	EmailDead    = "dead"
+)
+
+// emailLease - how long a worker has to send an email it claimed, long enough for the smtp server's own timeouts
+// and retries, after that the worker is taken to have died mid-send
+const emailLease = time.Minute
+
+// errEmailLeaseExpired - the failure recorded for an attempt whose worker never reported back
+var errEmailLeaseExpired = errors.New("the send did not finish before its lease ran out")
+
+// secretEmailData - the data keys holding secrets, which are cleared from an email once it is dead-lettered
+var secretEmailData = []string{"passwordResetToken"}
+
+// Email is a templated email waiting in, or sent from, the outbox
+type Email struct {
+	ID             int64                  `json:"id"`
+	Recipient      string                 `json:"recipient"`
+	Template       string                 `json:"template"`
+	Data           map[string]interface{} `json:"-"`
+	NotificationID int64                  `json:"notification_id,omitempty"`
+	Status         string                 `json:"status"`
+	Attempts       int                    `json:"attempts"`
+	NextAttemptAt  time.Time              `json:"next_attempt_at"`
+	LastError      string                 `json:"last_error,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+	SentAt         *time.Time             `json:"sent_at,omitempty"`
+	leasedUntil    time.Time              //the lease the worker sending it claimed it with
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx, so emails can be queued inside another model's transaction
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertEmail() - queues an email to be sent as soon as a worker picks it up
+func insertEmail(ctx context.Context, q queryer, email *Email) error {
+	data, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO email_outbox (recipient, template, data, notification_id)
+		VALUES ($1, $2, $3, NULLIF($4, 0))
+		RETURNING id, status, next_attempt_at, created_at`
+	//as a string, pq would send a []byte as bytea
+	args := []interface{}{email.Recipient, email.Template, string(data), email.NotificationID}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&email.ID, &email.Status, &email.NextAttemptAt, &email.CreatedAt)
+}
+
+// OutboxModel wraps the connection pool for the email_outbox table
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// Insert() - queues an email on its own, changes that trigger an email should queue it in their own transaction
+func (m OutboxModel) Insert(email *Email) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertEmail(ctx, m.DB, email)
+}
+
+// Get() - finds a queued email by its id
+func (m OutboxModel) Get(id int64) (*Email, error) {
+	query := `
+		SELECT id, recipient, template, COALESCE(notification_id, 0), status, attempts, next_attempt_at,
+			COALESCE(last_error, ''), created_at, sent_at
+		FROM email_outbox
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	email, err := scanEmail(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return email, nil
+}
+
+// GetAll() - the newest emails with the given status, or with any status when it is empty
+func (m OutboxModel) GetAll(status string, limit int) ([]*Email, error) {
+	query := `
+		SELECT id, recipient, template, COALESCE(notification_id, 0), status, attempts, next_attempt_at,
+			COALESCE(last_error, ''), created_at, sent_at
+		FROM email_outbox
+		WHERE status = $1 OR $1 = ''
+		ORDER BY id DESC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*Email{}
+	for rows.Next() {
+		email, err := scanEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
+
+// scanEmail() - reads the columns selected by Get() and GetAll()
+func scanEmail(row interface{ Scan(...interface{}) error }) (*Email, error) {
+	var email Email
+	err := row.Scan(
+		&email.ID,
+		&email.Recipient,
+		&email.Template,
+		&email.NotificationID,
+		&email.Status,
+		&email.Attempts,
+		&email.NextAttemptAt,
+		&email.LastError,
+		&email.CreatedAt,
+		&email.SentAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &email, nil
+}
+
+// Process() - claims the oldest due email and sends it. The claim marks it sending with a lease in a short
+// transaction, so the send runs outside any transaction and its result is saved in a second one, a send that
+// finishes as a context runs out can't be rolled back and go out again. A failed send is retried after
+// backoff(attempts) until maxAttempts is reached, then the email is dead-lettered. Reports false when nothing was due.
+func (m OutboxModel) Process(maxAttempts int, backoff func(attempts int) time.Duration, send func(*Email) error) (bool, error) {
+	email, data, err := m.claim(maxAttempts, backoff)
+	if err != nil || email == nil {
+		return false, err
+	}
+	//the claim found a lease its worker never reported back on and already recorded the failed attempt
+	if email.Status != EmailSending {
+		return true, nil
+	}
+
+	//numbers stay as they were written, a form number must not come back as 3.43434e+05
+	//data that can't be read fails the attempt like a failed send, so the row backs off instead of blocking the queue
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	sendErr := dec.Decode(&email.Data)
+	if sendErr != nil {
+		sendErr = fmt.Errorf("decoding email data: %w", sendErr)
+	} else {
+		sendErr = send(email)
+	}
+
+	//the time the send took doesn't eat into saving its result
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	err = finishEmail(ctx, tx, email, maxAttempts, backoff, sendErr)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// claim() - marks the oldest due email sending and counts the attempt, returning it with its raw data. Skipped
+// locks keep two workers off the same row. An email whose lease ran out is recorded as a failed attempt instead,
+// its worker died mid-send so whether it went out is unknown, and it comes back no longer sending.
+func (m OutboxModel) claim(maxAttempts int, backoff func(attempts int) time.Duration) (*Email, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, recipient, template, data, COALESCE(notification_id, 0), status, attempts, created_at, leased_until
+		FROM email_outbox
+		WHERE (status = 'pending' AND next_attempt_at <= NOW()) OR (status = 'sending' AND leased_until <= NOW())
+		ORDER BY next_attempt_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`
+
+	var email Email
+	var data []byte
+	var lease sql.NullTime
+	err = tx.QueryRowContext(ctx, query).Scan(&email.ID, &email.Recipient, &email.Template, &data, &email.NotificationID, &email.Status, &email.Attempts, &email.CreatedAt, &lease)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, nil
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if email.Status == EmailSending {
+		email.leasedUntil = lease.Time
+		err = finishEmail(ctx, tx, &email, maxAttempts, backoff, errEmailLeaseExpired)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &email, nil, tx.Commit()
+	}
+
+	query = `
+		UPDATE email_outbox
+		SET status = 'sending', attempts = attempts + 1, leased_until = NOW() + $1 * interval '1 millisecond'
+		WHERE id = $2
+		RETURNING status, attempts, leased_until`
+	err = tx.QueryRowContext(ctx, query, emailLease.Milliseconds(), email.ID).Scan(&email.Status, &email.Attempts, &email.leasedUntil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &email, data, tx.Commit()
+}
+
+// finishEmail() - records how an attempt went, sendErr is nil when the email went out. The email must still hold
+// the lease it was claimed with, ErrEditConflict means it ran out and another worker took the email over.
+func finishEmail(ctx context.Context, tx *sql.Tx, email *Email, maxAttempts int, backoff func(attempts int) time.Duration, sendErr error) error {
+	var query string
+	var args []interface{}
+	notificationStatus := NotificationSent
+	switch {
+	//a sent email's data is cleared, it may hold secrets such as a password reset token
+	case sendErr == nil:
+		email.Status = EmailSent
+		query = `
+			UPDATE email_outbox
+			SET status = $1, last_error = NULL, sent_at = NOW(), data = '{}', leased_until = NULL
+			WHERE id = $2 AND status = 'sending' AND leased_until = $3`
+		args = []interface{}{email.Status, email.ID, email.leasedUntil}
+	case email.Attempts >= maxAttempts:
+		email.Status = EmailDead
+		email.LastError = sendErr.Error()
+		notificationStatus = NotificationFailed
+		//its secrets aren't kept waiting for an admin, a password reset token has expired by then anyway
+		query = `
+			UPDATE email_outbox
+			SET status = $1, last_error = $2, data = data - $3::text[], leased_until = NULL
+			WHERE id = $4 AND status = 'sending' AND leased_until = $5`
+		args = []interface{}{email.Status, email.LastError, pq.Array(secretEmailData), email.ID, email.leasedUntil}
+	default:
+		email.Status = EmailPending
+		email.LastError = sendErr.Error()
+		query = `
+			UPDATE email_outbox
+			SET status = $1, last_error = $2, next_attempt_at = NOW() + $3 * interval '1 millisecond', leased_until = NULL
+			WHERE id = $4 AND status = 'sending' AND leased_until = $5`
+		args = []interface{}{email.Status, email.LastError, backoff(email.Attempts).Milliseconds(), email.ID, email.leasedUntil}
+	}
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	//the applicant's notification follows the email once its fate is known
+	if email.NotificationID != 0 && email.Status != EmailPending {
+		err = updateNotificationStatus(ctx, tx, email.NotificationID, notificationStatus, email.LastError)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resend() - puts a dead-lettered email back in the queue with a fresh set of attempts,
+// ErrEditConflict means it is no longer dead, e.g. another admin already re-sent it
+func (m OutboxModel) Resend(email *Email) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+		WHERE id = $1 AND status = 'dead'
+		RETURNING status, attempts, next_attempt_at`
+	err = tx.QueryRowContext(ctx, query, email.ID).Scan(&email.Status, &email.Attempts, &email.NextAttemptAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if email.NotificationID != 0 {
+		err = updateNotificationStatus(ctx, tx, email.NotificationID, NotificationQueued, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
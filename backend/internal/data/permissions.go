@@ -0,0 +1,83 @@
+// BIOAFF/backend/internal/data/permissions.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Permissions holds permission codes such as "logs:read"
+type Permissions []string
+
+// Include() - checks if a permission code is in the slice
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionModel wraps the connection pool for the permissions tables
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+// GetAllForUser() - returns every permission code granted to the user, in BioAff or through their directory groups
+// only staff accounts are granted permissions, applicants never hold any
+func (m PermissionModel) GetAllForUser(user *User) (Permissions, error) {
+	if user.Kind != UserKindAdmin {
+		return Permissions{}, nil
+	}
+
+	query := `
+		SELECT p.code
+		FROM permissions p
+		WHERE p.id IN (
+			SELECT permission_id FROM admin_users_permissions WHERE admin_id = $1
+			UNION
+			SELECT permission_id FROM admin_directory_permissions WHERE admin_id = $1
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// AddForUser() - grants permission codes to a staff account
+func (m PermissionModel) AddForUser(adminID int64, codes ...string) error {
+	query := `
+		INSERT INTO admin_users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, adminID, pq.Array(codes))
+	return err
+}
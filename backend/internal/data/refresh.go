@@ -0,0 +1,175 @@
+// BIOAFF/backend/internal/data/refresh.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RefreshTokenModel wraps the connection pool for the refresh tokens in the tokens table. A refresh token is
+// swapped for a new one every time it is used, the tokens replaced along the way make up the session's family
+// and are kept until they expire, so one presented twice shows it was stolen and the whole session is revoked
+type RefreshTokenModel struct {
+	DB *sql.DB
+}
+
+// New() - starts a session for the user with its first refresh token, the family is the token's own id.
+// mfa records that a second factor was checked, the session's later tokens inherit it.
+func (m RefreshTokenModel) New(user *User, ttl time.Duration, ip, userAgent string, mfa bool) (*Token, error) {
+	column, err := ownerColumn(user.Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateToken(user, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	token.IP, token.UserAgent, token.MFA = ip, userAgent, mfa
+
+	query := fmt.Sprintf(`
+		WITH next AS (SELECT nextval(pg_get_serial_sequence('tokens', 'id')) AS id)
+		INSERT INTO tokens (id, family, hash, %s, expiry, scope, ip, user_agent, mfa)
+		SELECT next.id, next.id, $1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7
+		FROM next
+		RETURNING family`, column)
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent, token.MFA}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&token.Family)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Rotate() - trades a refresh token for a new one in the same session, valid for ttl from now.
+// ErrRecordNotFound means the token is unknown or expired, ErrTokenReused that it had already been
+// traded, in which case the session is revoked and the event recorded
+func (m RefreshTokenModel) Rotate(tokenPlaintext string, ttl time.Duration, ip, userAgent string) (*Token, error) {
+	hash := HashToken(tokenPlaintext)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	//the row lock makes a concurrent rotation of the same token wait, then find it used
+	query := `
+		UPDATE tokens
+		SET used_at = NOW()
+		WHERE hash = $1 AND scope = $2 AND used_at IS NULL AND expiry > NOW()
+		RETURNING admin_id, public_user_id, family, created_at, mfa`
+
+	var adminID, publicUserID sql.NullInt64
+	var family int64
+	var createdAt time.Time
+	var mfa bool
+	err = tx.QueryRowContext(ctx, query, hash, ScopeRefresh).Scan(&adminID, &publicUserID, &family, &createdAt, &mfa)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			err = m.revokeReused(ctx, tx, hash, ip)
+			if err != nil {
+				return nil, err
+			}
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	user := &User{ID: publicUserID.Int64, Kind: UserKindPublic}
+	if adminID.Valid {
+		user = &User{ID: adminID.Int64, Kind: UserKindAdmin}
+	}
+	column, err := ownerColumn(user.Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateToken(user, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	token.IP, token.UserAgent, token.Family, token.MFA = ip, userAgent, family, mfa
+
+	//the session keeps its start time and second factor, its last use is the rotation
+	query = fmt.Sprintf(`
+		INSERT INTO tokens (hash, %s, expiry, scope, ip, user_agent, family, created_at, last_used_at, mfa)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, NOW(), $9)`, column)
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent, token.Family, createdAt, token.MFA}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, tx.Commit()
+}
+
+// revokeReused() - when the hash is of a refresh token which was already traded, revokes its session and records
+// the event, committing the transaction and returning ErrTokenReused. A token which was never issued is left alone.
+func (m RefreshTokenModel) revokeReused(ctx context.Context, tx *sql.Tx, hash []byte, ip string) error {
+	query := `
+		SELECT admin_id, public_user_id, family
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND used_at IS NOT NULL`
+
+	var adminID, publicUserID sql.NullInt64
+	var family int64
+	err := tx.QueryRowContext(ctx, query, hash, ScopeRefresh).Scan(&adminID, &publicUserID, &family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	query = `
+		DELETE FROM tokens
+		WHERE family = $1 AND scope = $2`
+	_, err = tx.ExecContext(ctx, query, family, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	event := &AuditEvent{
+		Event:    AuditRefreshReused,
+		UserID:   publicUserID.Int64,
+		UserKind: UserKindPublic,
+		IP:       ip,
+		Details:  map[string]interface{}{"session_id": family},
+	}
+	if adminID.Valid {
+		event.UserID, event.UserKind = adminID.Int64, UserKindAdmin
+	}
+	err = insertAuditEvent(ctx, tx, event)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return ErrTokenReused
+}
@@ -0,0 +1,194 @@
+// BIOAFF/backend/internal/data/sessions.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Session is a user's authentication token, or the latest refresh token of a session in the jwt auth mode,
+// as they see it. The token itself is never shown again, a refresh token session's id is its family.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	IP         string     `json:"ip,omitempty"` //where it was last used from
+	UserAgent  string     `json:"user_agent,omitempty"`
+	Expiry     time.Time  `json:"expiry"`
+	Current    bool       `json:"current"` //the session the request was made with
+	Hash       []byte     `json:"-"`
+}
+
+// SessionActivity is the latest use of a session, saved in batches
+type SessionActivity struct {
+	Hash   []byte
+	UsedAt time.Time
+	IP     string
+}
+
+// SessionModel wraps the connection pool for the authentication and refresh tokens in the tokens table
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// GetAllForUser() - the user's sessions which haven't expired, the most recently used first
+func (m SessionModel) GetAllForUser(user *User) ([]*Session, error) {
+	column, err := ownerColumn(user.Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT COALESCE(family, id), created_at, last_used_at, COALESCE(ip, ''), COALESCE(user_agent, ''), expiry, hash
+		FROM tokens
+		WHERE %s = $1 AND (scope = $2 OR (scope = $3 AND used_at IS NULL)) AND expiry > NOW()
+		ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC`, column)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, user.ID, ScopeAuthentication, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var s Session
+		err := rows.Scan(&s.ID, &s.CreatedAt, &s.LastUsedAt, &s.IP, &s.UserAgent, &s.Expiry, &s.Hash)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &s)
+	}
+	return sessions, rows.Err()
+}
+
+// MFA() - reports if a second factor was checked when the session was started, the session is the one with the
+// authentication token's hash or, for a JWT access token, the refresh token family with the id
+func (m SessionModel) MFA(hash []byte, id int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM tokens
+			WHERE mfa AND expiry > NOW()
+			AND ((hash = $1 AND scope = $2) OR (family = $3 AND scope = $4 AND used_at IS NULL))
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var mfa bool
+	err := m.DB.QueryRowContext(ctx, query, hash, ScopeAuthentication, id, ScopeRefresh).Scan(&mfa)
+	return mfa, err
+}
+
+// Delete() - revokes one of the user's sessions and records the event, which may be nil.
+// ErrRecordNotFound means it isn't one of theirs.
+func (m SessionModel) Delete(user *User, id int64, event *AuditEvent) error {
+	column, err := ownerColumn(user.Kind)
+	if err != nil {
+		return err
+	}
+
+	//revoking a refresh token session takes the tokens it replaced with it
+	query := fmt.Sprintf(`
+		DELETE FROM tokens
+		WHERE %s = $2 AND ((scope = $3 AND id = $1) OR (scope = $4 AND family = $1))`, column)
+
+	n, err := m.revoke(event, query, id, user.ID, ScopeAuthentication, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser() - revokes every session of the user and records the event, which may be nil,
+// reporting how many sessions there were
+func (m SessionModel) DeleteAllForUser(user *User, event *AuditEvent) (int64, error) {
+	column, err := ownerColumn(user.Kind)
+	if err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf(`
+		DELETE FROM tokens
+		WHERE %s = $1 AND scope IN ($2, $3)`, column)
+
+	return m.revoke(event, query, user.ID, ScopeAuthentication, ScopeRefresh)
+}
+
+// revoke() - deletes sessions with the query, recording the event with how many were revoked
+// in the same transaction when any were. Refresh tokens which were already replaced aren't counted.
+func (m SessionModel) revoke(event *AuditEvent, query string, args ...interface{}) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query = fmt.Sprintf(`
+		WITH deleted AS (%s RETURNING used_at)
+		SELECT count(*) FROM deleted WHERE used_at IS NULL`, query)
+
+	var n int64
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	if event != nil && n > 0 {
+		if event.Details == nil {
+			event.Details = map[string]interface{}{}
+		}
+		event.Details["sessions"] = n
+		err = insertAuditEvent(ctx, tx, event)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return n, tx.Commit()
+}
+
+// Touch() - saves a batch of session activity in one statement, sessions revoked since are skipped
+func (m SessionModel) Touch(activity []SessionActivity) error {
+	if len(activity) == 0 {
+		return nil
+	}
+
+	hashes := make([][]byte, len(activity))
+	usedAt := make([]string, len(activity))
+	ips := make([]string, len(activity))
+	for i, a := range activity {
+		hashes[i] = a.Hash
+		usedAt[i] = a.UsedAt.UTC().Format(time.RFC3339Nano)
+		ips[i] = a.IP
+	}
+
+	//a batch saved late mustn't move last_used_at backwards, nor put back the ip of an older use
+	query := `
+		UPDATE tokens AS t
+		SET last_used_at = GREATEST(t.last_used_at, u.used_at),
+			ip = CASE WHEN t.last_used_at IS NULL OR u.used_at >= t.last_used_at THEN COALESCE(NULLIF(u.ip, ''), t.ip) ELSE t.ip END
+		FROM unnest($1::bytea[], $2::timestamptz[], $3::text[]) AS u (hash, used_at, ip)
+		WHERE t.hash = u.hash AND t.scope = $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.ByteaArray(hashes), pq.StringArray(usedAt), pq.StringArray(ips), ScopeAuthentication)
+	return err
+}
@@ -0,0 +1,266 @@
+// BIOAFF/backend/internal/data/sso.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SSOLogin is a single sign-on login waiting for the browser to come back from the provider,
+// State is only known to the browser and the provider once it is stored
+type SSOLogin struct {
+	State       string
+	Nonce       string
+	Verifier    string //the PKCE code verifier, only the client which started the login has it, only its hash is stored
+	LinkAdminID int64  //the signed in staff account the login links to the directory, 0 for a plain login
+	Expiry      time.Time
+}
+
+// SSOIdentity is who the provider says logged in
+type SSOIdentity struct {
+	Issuer  string
+	Subject string
+	Email   string
+}
+
+// SSOModel wraps the connection pool for the single sign-on logins and the staff accounts linked to the directory
+type SSOModel struct {
+	DB *sql.DB
+}
+
+// InsertLogin() - stores a login until the browser comes back with its state
+func (m SSOModel) InsertLogin(login *SSOLogin) error {
+	query := `
+		INSERT INTO sso_logins (state_hash, nonce, verifier_hash, link_admin_id, expiry)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5)`
+	args := []interface{}{HashToken(login.State), login.Nonce, HashToken(login.Verifier), login.LinkAdminID, login.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// TakeLogin() - removes and returns the login with the state and the verifier the client was given so it can
+// only be completed once, by that client. ErrRecordNotFound means there is none, it expired or the verifier is
+// wrong, which leaves the login for the client that has the right one.
+func (m SSOModel) TakeLogin(state, verifier string) (*SSOLogin, error) {
+	query := `
+		DELETE FROM sso_logins
+		WHERE state_hash = $1 AND verifier_hash = $2
+		RETURNING nonce, COALESCE(link_admin_id, 0), expiry`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	login := SSOLogin{State: state, Verifier: verifier}
+	err := m.DB.QueryRowContext(ctx, query, HashToken(state), HashToken(verifier)).Scan(&login.Nonce, &login.LinkAdminID, &login.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if time.Now().After(login.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+	return &login, nil
+}
+
+// DeleteExpiredLogins() - deletes the logins the browser never came back from, reporting how many there were
+func (m SSOModel) DeleteExpiredLogins() (int64, error) {
+	query := `
+		DELETE FROM sso_logins
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Linked() - reports if the account logs in through the directory, applicants never do
+func (m SSOModel) Linked(user *User) (bool, error) {
+	if user.Kind != UserKindAdmin {
+		return false, nil
+	}
+
+	query := `
+		SELECT EXISTS (SELECT 1 FROM admin_identities WHERE admin_id = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var linked bool
+	err := m.DB.QueryRowContext(ctx, query, user.ID).Scan(&linked)
+	return linked, err
+}
+
+// Link() - links the identity to the staff account which asked for it while signed in, revoking the account's
+// sessions as they were started without the directory, and records the event. ErrEditConflict means the account
+// or the identity is already linked.
+func (m SSOModel) Link(adminID int64, identity SSOIdentity, ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO admin_identities (admin_id, issuer, subject)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		RETURNING admin_id`
+	err = tx.QueryRowContext(ctx, query, adminID, identity.Issuer, identity.Subject).Scan(&adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query = `
+		DELETE FROM tokens
+		WHERE admin_id = $1 AND scope IN ($2, $3, $4)`
+	result, err := tx.ExecContext(ctx, query, adminID, ScopeAuthentication, ScopeRefresh, ScopeTwoFactor)
+	if err != nil {
+		return err
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEvent(ctx, tx, &AuditEvent{
+		Event:    AuditSSOLinked,
+		UserID:   adminID,
+		UserKind: UserKindAdmin,
+		IP:       ip,
+		Details:  map[string]interface{}{"issuer": identity.Issuer, "subject": identity.Subject, "provisioned": false, "sessions_revoked": revoked},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Provision() - the staff account of the identity, created the first time it logs in, with its directory
+// permissions replaced by the ones its groups grant. An existing account is never linked by its email, ErrEditConflict
+// means the email belongs to an account which has to be linked by its owner with Link().
+func (m SSOModel) Provision(identity SSOIdentity, permissions []string, ip string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT admin_id
+		FROM admin_identities
+		WHERE issuer = $1 AND subject = $2`
+
+	var adminID int64
+	err = tx.QueryRowContext(ctx, query, identity.Issuer, identity.Subject).Scan(&adminID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if adminID == 0 {
+		adminID, err = m.provision(ctx, tx, identity, ip)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query = `
+		UPDATE admin_identities
+		SET last_login_at = NOW()
+		WHERE admin_id = $1`
+	_, err = tx.ExecContext(ctx, query, adminID)
+	if err != nil {
+		return nil, err
+	}
+
+	//only what the groups granted is replaced, permissions granted in BioAff are kept
+	query = `
+		DELETE FROM admin_directory_permissions
+		WHERE admin_id = $1`
+	_, err = tx.ExecContext(ctx, query, adminID)
+	if err != nil {
+		return nil, err
+	}
+	query = `
+		INSERT INTO admin_directory_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	_, err = tx.ExecContext(ctx, query, adminID, pq.Array(permissions))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return UserModel{DB: m.DB}.Get(UserKindAdmin, adminID)
+}
+
+// provision() - creates an account linked to the identity, recording the event
+func (m SSOModel) provision(ctx context.Context, tx *sql.Tx, identity SSOIdentity, ip string) (int64, error) {
+	//the account has no password of its own, it can only log in through the directory
+	query := `
+		INSERT INTO admin_users (email, au_password, activated)
+		VALUES ($1, '', true)
+		ON CONFLICT DO NOTHING
+		RETURNING id`
+
+	var adminID int64
+	err := tx.QueryRowContext(ctx, query, identity.Email).Scan(&adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrEditConflict
+		default:
+			return 0, err
+		}
+	}
+
+	query = `
+		INSERT INTO admin_identities (admin_id, issuer, subject)
+		VALUES ($1, $2, $3)`
+	_, err = tx.ExecContext(ctx, query, adminID, identity.Issuer, identity.Subject)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertAuditEvent(ctx, tx, &AuditEvent{
+		Event:    AuditSSOLinked,
+		UserID:   adminID,
+		UserKind: UserKindAdmin,
+		IP:       ip,
+		Details:  map[string]interface{}{"issuer": identity.Issuer, "subject": identity.Subject, "provisioned": true},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return adminID, nil
+}
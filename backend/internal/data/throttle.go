@@ -0,0 +1,205 @@
+// BIOAFF/backend/internal/data/throttle.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// LoginThrottle counts the recent failed logins for an account or a client ip
+type LoginThrottle struct {
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// Locked() - reports if logins are refused at the given time
+func (t *LoginThrottle) Locked(now time.Time) bool {
+	return t.LockedUntil != nil && now.Before(*t.LockedUntil)
+}
+
+// AccountThrottleKey() - the key failed logins to an account are counted under, it doesn't need the account to exist
+func AccountThrottleKey(kind, email string) string {
+	return "account:" + kind + ":" + strings.ToLower(email)
+}
+
+// IPThrottleKey() - the key failed logins from a client ip are counted under
+func IPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginThrottleModel wraps the connection pool for the login_throttle table
+type LoginThrottleModel struct {
+	DB *sql.DB
+}
+
+// Get() - the failures counted under key, a key without any is returned with none
+func (m LoginThrottleModel) Get(key string) (*LoginThrottle, error) {
+	query := `
+		SELECT key, failures, last_failure_at, locked_until
+		FROM login_throttle
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t LoginThrottle
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&t.Key, &t.Failures, &t.LastFailureAt, &t.LockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return &LoginThrottle{Key: key}, nil
+		default:
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// RecordFailure() - counts a failed login under key, the count starts over when the last failure
+// is older than window or a lockout has run out
+func (m LoginThrottleModel) RecordFailure(key string, window time.Duration) (*LoginThrottle, error) {
+	query := `
+		INSERT INTO login_throttle (key, failures, last_failure_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttle.last_failure_at < NOW() - make_interval(secs => $2) OR login_throttle.locked_until <= NOW() THEN 1
+				ELSE login_throttle.failures + 1
+			END,
+			locked_until = CASE WHEN login_throttle.locked_until <= NOW() THEN NULL ELSE login_throttle.locked_until END,
+			last_failure_at = NOW()
+		RETURNING key, failures, last_failure_at, locked_until`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t LoginThrottle
+	err := m.DB.QueryRowContext(ctx, query, key, window.Seconds()).Scan(&t.Key, &t.Failures, &t.LastFailureAt, &t.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Lock() - refuses logins under the throttle's key until the given time, recording the event and queueing
+// the email (either may be nil) in the same transaction. ErrEditConflict means it was already locked.
+func (m LoginThrottleModel) Lock(t *LoginThrottle, until time.Time, event *AuditEvent, email *Email) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//only one of the concurrent failures that crossed the limit gets to lock, and so to email
+	query := `
+		UPDATE login_throttle
+		SET locked_until = $2
+		WHERE key = $1 AND (locked_until IS NULL OR locked_until <= NOW())
+		RETURNING locked_until`
+
+	err = tx.QueryRowContext(ctx, query, t.Key, until).Scan(&t.LockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if event != nil {
+		err = insertAuditEvent(ctx, tx, event)
+		if err != nil {
+			return err
+		}
+	}
+	if email != nil {
+		err = insertEmail(ctx, tx, email)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Clear() - forgets the failures counted under key, after a successful login
+func (m LoginThrottleModel) Clear(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM login_throttle WHERE key = $1`, key)
+	return err
+}
+
+// Unlock() - lifts the lockout under key and records the event, ErrRecordNotFound means it wasn't locked
+func (m LoginThrottleModel) Unlock(key string, event *AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM login_throttle
+		WHERE key = $1 AND locked_until > NOW()
+		RETURNING key`
+
+	err = tx.QueryRowContext(ctx, query, key).Scan(&key)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = insertAuditEvent(ctx, tx, event)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetLocked() - the accounts and client ips which are locked out right now, those locked longest first
+func (m LoginThrottleModel) GetLocked() ([]*LoginThrottle, error) {
+	query := `
+		SELECT key, failures, last_failure_at, locked_until
+		FROM login_throttle
+		WHERE locked_until > NOW()
+		ORDER BY locked_until DESC, key`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	throttles := []*LoginThrottle{}
+	for rows.Next() {
+		var t LoginThrottle
+		err := rows.Scan(&t.Key, &t.Failures, &t.LastFailureAt, &t.LockedUntil)
+		if err != nil {
+			return nil, err
+		}
+		throttles = append(throttles, &t)
+	}
+	return throttles, rows.Err()
+}
@@ -0,0 +1,157 @@
+// BIOAFF/backend/internal/data/tokens.go
+
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// token scopes
+const (
+	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeTwoFactor      = "two-factor" //a password was checked, the second factor has yet to be
+	ScopeRefresh        = "refresh"    //traded for JWT access tokens in the jwt auth mode, rotated on every use
+)
+
+// Token is a bearer token, only its hash is ever stored
+type Token struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	UserKind  string    `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+	IP        string    `json:"-"` //where a session was last used from, where it was started until its activity is saved
+	UserAgent string    `json:"-"`
+	Family    int64     `json:"-"` //the session a refresh token belongs to
+	MFA       bool      `json:"-"` //a second factor was checked when the session was started
+}
+
+// maxUserAgentLength - longer user agents are cut short before they are stored with a session
+const maxUserAgentLength = 512
+
+// HashToken() - the stored form of a token, tokens are looked up by their hash
+func HashToken(tokenPlaintext string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
+
+// generateToken() - creates a random token for a user, valid for the given time to live
+func generateToken(user *User, ttl time.Duration, scope string) (*Token, error) {
+	token := &Token{
+		UserID:   user.ID,
+		UserKind: user.Kind,
+		Expiry:   time.Now().Add(ttl),
+		Scope:    scope,
+	}
+
+	//16 random bytes encode to 26 characters once the padding is dropped
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	token.Hash = HashToken(token.Plaintext)
+	return token, nil
+}
+
+// ValidateTokenPlaintext() - checks that a token looks like one we generated
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+// TokenModel wraps the connection pool for the tokens table
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// New() - generates a token for the user and stores it
+func (m TokenModel) New(user *User, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(user, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// NewSession() - generates an authentication token for the user, remembering where it was started from
+// and whether a second factor was checked
+func (m TokenModel) NewSession(user *User, ttl time.Duration, ip, userAgent string, mfa bool) (*Token, error) {
+	token, err := generateToken(user, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	token.IP, token.UserAgent, token.MFA = ip, userAgent, mfa
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// Insert() - stores a token against its owner
+func (m TokenModel) Insert(token *Token) error {
+	column, err := ownerColumn(token.UserKind)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO tokens (hash, %s, expiry, scope, ip, user_agent, mfa)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)`, column)
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent, token.MFA}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// DeleteAllForUser() - removes every token of a scope belonging to the user
+func (m TokenModel) DeleteAllForUser(scope string, user *User) error {
+	column, err := ownerColumn(user.Kind)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		DELETE FROM tokens
+		WHERE scope = $1 AND %s = $2`, column)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, scope, user.ID)
+	return err
+}
+
+// DeleteExpired() - deletes every expired token, reporting how many there were
+func (m TokenModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,300 @@
+// BIOAFF/backend/internal/data/totp.go
+
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// RecoveryCodeCount - how many recovery codes an admin is given when they turn on two-factor authentication
+const RecoveryCodeCount = 10
+
+// TOTP is an admin's authenticator secret, it only counts once it has been confirmed with a code
+type TOTP struct {
+	AdminID      int64
+	Secret       []byte
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+// Enabled() - reports if logins need a code
+func (t *TOTP) Enabled() bool {
+	return t.ConfirmedAt != nil
+}
+
+// GenerateRecoveryCodes() - new random recovery codes, formatted like "abcde-fghij" to be easy to copy down
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		//10 random bytes encode to 16 characters, of which 10 are kept
+		randomBytes := make([]byte, 10)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode() - the stored form of a recovery code, ignoring case, spaces and dashes as people type them
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// TOTPModel wraps the connection pool for the admin_totp and admin_recovery_codes tables
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// Get() - the admin's secret, confirmed or not
+func (m TOTPModel) Get(adminID int64) (*TOTP, error) {
+	query := `
+		SELECT admin_id, secret, confirmed_at, last_used_step, created_at
+		FROM admin_totp
+		WHERE admin_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t TOTP
+	err := m.DB.QueryRowContext(ctx, query, adminID).Scan(&t.AdminID, &t.Secret, &t.ConfirmedAt, &t.LastUsedStep, &t.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// Enabled() - reports if the user has confirmed two-factor authentication, applicants never have it
+func (m TOTPModel) Enabled(user *User) (bool, error) {
+	if user.Kind != UserKindAdmin {
+		return false, nil
+	}
+
+	t, err := m.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return t.Enabled(), nil
+}
+
+// Begin() - stores a new secret waiting to be confirmed, replacing an unconfirmed one.
+// ErrEditConflict means the admin already has two-factor authentication turned on.
+func (m TOTPModel) Begin(adminID int64, secret []byte) error {
+	query := `
+		INSERT INTO admin_totp (admin_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (admin_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE admin_totp.confirmed_at IS NULL
+		RETURNING admin_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, adminID, secret).Scan(&adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Confirm() - turns two-factor authentication on with the step of the code that confirmed it,
+// replacing any recovery codes with the new ones. ErrEditConflict means it was already confirmed.
+func (m TOTPModel) Confirm(adminID, step int64, recoveryCodes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE admin_totp
+		SET confirmed_at = NOW(), last_used_step = $2
+		WHERE admin_id = $1 AND confirmed_at IS NULL
+		RETURNING admin_id`
+
+	err = tx.QueryRowContext(ctx, query, adminID, step).Scan(&adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, adminID, recoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// replaceRecoveryCodes() - swaps every recovery code of the admin for new ones
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, adminID int64, codes []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM admin_recovery_codes WHERE admin_id = $1`, adminID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO admin_recovery_codes (admin_id, hash) VALUES ($1, $2)`, adminID, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseStep() - records that a code from the time step was accepted, ErrEditConflict means a code from
+// the step, or a later one, was already used so the code is a replay
+func (m TOTPModel) UseStep(adminID, step int64) error {
+	query := `
+		UPDATE admin_totp
+		SET last_used_step = $2
+		WHERE admin_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2
+		RETURNING admin_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, adminID, step).Scan(&adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode() - uses up one of the admin's recovery codes, ErrRecordNotFound means it isn't one
+// of theirs or it was already used
+func (m TOTPModel) UseRecoveryCode(adminID int64, code string) error {
+	query := `
+		UPDATE admin_recovery_codes
+		SET used_at = NOW()
+		WHERE admin_id = $1 AND hash = $2 AND used_at IS NULL
+		RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query, adminID, hashRecoveryCode(code)).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// RemainingRecoveryCodes() - how many of the admin's recovery codes haven't been used
+func (m TOTPModel) RemainingRecoveryCodes(adminID int64) (int, error) {
+	query := `
+		SELECT count(*)
+		FROM admin_recovery_codes
+		WHERE admin_id = $1 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var n int
+	err := m.DB.QueryRowContext(ctx, query, adminID).Scan(&n)
+	return n, err
+}
+
+// RegenerateRecoveryCodes() - replaces the admin's recovery codes, ErrRecordNotFound means two-factor
+// authentication isn't turned on
+func (m TOTPModel) RegenerateRecoveryCodes(adminID int64, codes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `SELECT admin_id FROM admin_totp WHERE admin_id = $1 AND confirmed_at IS NOT NULL FOR UPDATE`, adminID).Scan(&adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, adminID, codes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete() - turns two-factor authentication off, removing the secret and the recovery codes
+func (m TOTPModel) Delete(adminID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM admin_recovery_codes WHERE admin_id = $1`, adminID)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM admin_totp WHERE admin_id = $1`, adminID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,385 @@
+// BIOAFF/backend/internal/data/users.go
+
+package data
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jinzhu/gorm/backend/internal/validator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the kinds of account, staff and applicants are kept in separate tables
+const (
+	UserKindAdmin  = "admin"  //staff reviewing affidavits, stored in admin_users
+	UserKindPublic = "public" //applicants filing affidavits, stored in public_user
+)
+
+// AnonymousUser is set on requests which carry no authentication token
+var AnonymousUser = &User{}
+
+// User is a staff or applicant account
+type User struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Email     string    `json:"email"`
+	Activated bool      `json:"activated"`
+	CreatedAt time.Time `json:"created_at"`
+	Password  password  `json:"-"`
+	Version   int       `json:"-"`
+}
+
+// password holds the bcrypt hash of a password, and the plaintext while it is being set
+// legacy is the plaintext password of an account which was created before passwords were hashed
+type password struct {
+	plaintext *string
+	hash      []byte
+	legacy    string
+}
+
+// Set() - hashes the plaintext password
+func (p *password) Set(plaintextPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	if err != nil {
+		return err
+	}
+	p.plaintext = &plaintextPassword
+	p.hash = hash
+	return nil
+}
+
+// Matches() - checks the plaintext password against the hash, or the legacy password of an account without one
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	if p.hash == nil {
+		//a bcrypt comparison is made anyway, so the response time doesn't show which accounts aren't hashed yet
+		SimulatePasswordMatch(plaintextPassword)
+		if p.legacy == "" {
+			return false, nil
+		}
+		return subtle.ConstantTimeCompare([]byte(p.legacy), []byte(plaintextPassword)) == 1, nil
+	}
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// IsLegacy() - reports if the password is still stored in plaintext and should be hashed
+func (p *password) IsLegacy() bool {
+	return p.hash == nil && p.legacy != ""
+}
+
+// dummyHash - compared against when there is no account, generated on first use
+var dummyHash struct {
+	once sync.Once
+	hash []byte
+}
+
+// SimulatePasswordMatch() - takes as long as checking a password, so a login to an account that doesn't
+// exist can't be told apart by its response time
+func SimulatePasswordMatch(plaintextPassword string) {
+	dummyHash.once.Do(func() {
+		dummyHash.hash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), 12)
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyHash.hash, []byte(plaintextPassword))
+}
+
+// ValidatePasswordPlaintext() - checks the password's length, bcrypt only looks at the first 72 bytes
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 10, "password", "must be at least 10 bytes long")
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+}
+
+// ValidatePasswordStrength() - on top of the length, a new password has to mix at least three kinds of
+// character and must not contain the account's email, which is the first thing an attacker tries
+func ValidatePasswordStrength(v *validator.Validator, password, email string) {
+	ValidatePasswordPlaintext(v, password)
+
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	kinds := 0
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			kinds++
+		}
+	}
+	v.Check(kinds >= 3, "password", "must contain at least three of lowercase letters, uppercase letters, digits and symbols")
+
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	v.Check(len(local) < 3 || !strings.Contains(strings.ToLower(password), local), "password", "must not contain your email address")
+}
+
+// IsAnonymous() - checks if the user is the anonymous user
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+// userTable() - the table holding the accounts of a kind
+func userTable(kind string) (string, error) {
+	switch kind {
+	case UserKindAdmin:
+		return "admin_users", nil
+	case UserKindPublic:
+		return "public_user", nil
+	default:
+		return "", fmt.Errorf("unknown user kind %q", kind)
+	}
+}
+
+// ownerColumn() - the column which references the accounts of a kind from tables such as tokens
+func ownerColumn(kind string) (string, error) {
+	switch kind {
+	case UserKindAdmin:
+		return "admin_id", nil
+	case UserKindPublic:
+		return "public_user_id", nil
+	default:
+		return "", fmt.Errorf("unknown user kind %q", kind)
+	}
+}
+
+// legacyPasswordColumn() - the column holding the plaintext passwords of accounts which haven't been hashed yet
+func legacyPasswordColumn(kind string) (string, error) {
+	switch kind {
+	case UserKindAdmin:
+		return "au_password", nil
+	case UserKindPublic:
+		return "pu_password", nil
+	default:
+		return "", fmt.Errorf("unknown user kind %q", kind)
+	}
+}
+
+// UserModel wraps the connection pool for both kinds of account
+type UserModel struct {
+	DB *sql.DB
+}
+
+// Get() - finds an account by its kind and id
+func (m UserModel) Get(kind string, id int64) (*User, error) {
+	table, err := userTable(kind)
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := legacyPasswordColumn(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, email, activated, created_at, password_hash, %s, version
+		FROM %s
+		WHERE id = $1`, legacy, table)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user := User{Kind: kind}
+	err = m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Activated,
+		&user.CreatedAt,
+		&user.Password.hash,
+		&user.Password.legacy,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// GetByEmail() - finds an account by its kind and email, ignoring the case of the email
+func (m UserModel) GetByEmail(kind, email string) (*User, error) {
+	table, err := userTable(kind)
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := legacyPasswordColumn(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, email, activated, created_at, password_hash, %s, version
+		FROM %s
+		WHERE lower(email) = lower($1)`, legacy, table)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user := User{Kind: kind}
+	err = m.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Activated,
+		&user.CreatedAt,
+		&user.Password.hash,
+		&user.Password.legacy,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// GetForToken() - finds the account owning an unexpired token of the given scope
+func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	//the token belongs to exactly one of the two tables
+	query := `
+		SELECT t.admin_id, t.public_user_id
+		FROM tokens t
+		WHERE t.hash = $1
+		AND t.scope = $2
+		AND t.expiry > $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var adminID, publicUserID sql.NullInt64
+	err := m.DB.QueryRowContext(ctx, query, HashToken(tokenPlaintext), tokenScope, time.Now()).Scan(&adminID, &publicUserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if adminID.Valid {
+		return m.Get(UserKindAdmin, adminID.Int64)
+	}
+	return m.Get(UserKindPublic, publicUserID.Int64)
+}
+
+// ResetPassword() - saves the user's new password and revokes their authentication, refresh and password reset tokens,
+// queueing the confirmation email in the same transaction. ErrEditConflict means the account changed since it was read
+func (m UserModel) ResetPassword(user *User, email *Email) error {
+	table, err := userTable(user.Kind)
+	if err != nil {
+		return err
+	}
+	column, err := ownerColumn(user.Kind)
+	if err != nil {
+		return err
+	}
+	legacy, err := legacyPasswordColumn(user.Kind)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//the old plaintext password goes for good
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET password_hash = $1, %s = '', version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`, table, legacy)
+	err = tx.QueryRowContext(ctx, query, user.Password.hash, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query = fmt.Sprintf(`
+		DELETE FROM tokens
+		WHERE %s = $1 AND scope IN ($2, $3, $4)`, column)
+	_, err = tx.ExecContext(ctx, query, user.ID, ScopeAuthentication, ScopeRefresh, ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	if email != nil {
+		err = insertEmail(ctx, tx, email)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpgradePassword() - replaces the legacy plaintext password with the hash set on the user,
+// ErrEditConflict means the account changed since it was read
+func (m UserModel) UpgradePassword(user *User) error {
+	table, err := userTable(user.Kind)
+	if err != nil {
+		return err
+	}
+	legacy, err := legacyPasswordColumn(user.Kind)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET password_hash = $1, %s = '', version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`, table, legacy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, user.Password.hash, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	user.Password.legacy = ""
+	return nil
+}
@@ -0,0 +1,439 @@
+// BIOAFF/backend/internal/jobs/jobs.go
+
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+)
+
+var (
+	ErrUnknownJob = errors.New("jobs: unknown job type")
+	ErrQueueFull  = errors.New("jobs: queue is full")
+	ErrStopped    = errors.New("jobs: runner is stopped")
+)
+
+// the statuses of a run
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// recentRuns - how many finished runs the runner remembers for its status
+const recentRuns = 50
+
+// Func does the work of a job, it should return once ctx is done
+type Func func(ctx context.Context, arg interface{}) error
+
+// jobType is a registered kind of job
+type jobType struct {
+	name    string
+	timeout time.Duration
+	fn      Func
+}
+
+// Run describes one run of a job
+type Run struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	QueuedAt   time.Time  `json:"queued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Schedule describes a job waiting to be queued at a set time, or every interval
+type Schedule struct {
+	Name    string    `json:"name"`
+	Every   string    `json:"every,omitempty"`
+	NextRun time.Time `json:"next_run"`
+}
+
+// Status is a snapshot of what the runner is doing
+type Status struct {
+	Workers   int        `json:"workers"`
+	Types     []string   `json:"types"`
+	Running   []Run      `json:"running"`
+	Queued    []Run      `json:"queued"`
+	Scheduled []Schedule `json:"scheduled"`
+	Recent    []Run      `json:"recent"`
+}
+
+// entry is a queued or running job
+type entry struct {
+	run      Run
+	arg      interface{}
+	schedule *schedule //the schedule which queued the run, if any
+}
+
+// schedule queues a job once at a set time, or every interval when it is recurring
+type schedule struct {
+	name    string
+	arg     interface{}
+	every   time.Duration
+	nextRun time.Time
+	active  *entry //the schedule's run which is queued or running, recurring jobs never overlap
+}
+
+// Runner runs registered jobs on a bounded pool of workers
+type Runner struct {
+	logger  *jsonlog.Logger
+	workers int
+	queue   chan *entry
+	ctx     context.Context
+	cancel  context.CancelFunc
+	drain   context.Context //the shutdown's context, runs still queued at shutdown run under it
+	wg      sync.WaitGroup
+
+	mu        sync.Mutex
+	types     map[string]jobType
+	nextID    int64
+	queued    map[int64]*entry
+	running   map[int64]*entry
+	recent    []Run
+	schedules []*schedule
+	started   bool
+}
+
+// New() - creates a runner with the given number of workers, at most queueSize runs may wait for a worker
+func New(logger *jsonlog.Logger, workers, queueSize int) *Runner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runner{
+		logger:  logger,
+		workers: workers,
+		queue:   make(chan *entry, queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		types:   make(map[string]jobType),
+		queued:  make(map[int64]*entry),
+		running: make(map[int64]*entry),
+	}
+}
+
+// Register() - adds a job type, each run's context is cancelled after timeout unless it is zero
+func (r *Runner) Register(name string, timeout time.Duration, fn Func) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.types[name] = jobType{name: name, timeout: timeout, fn: fn}
+}
+
+// Start() - starts the workers, runs queued before now start straight away
+func (r *Runner) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.started {
+		return
+	}
+	r.started = true
+
+	for i := 0; i < r.workers; i++ {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.work()
+		}()
+	}
+}
+
+// Enqueue() - queues a run of the named job with arg, returns the run's id
+func (r *Runner) Enqueue(name string, arg interface{}) (int64, error) {
+	return r.enqueue(name, arg, nil)
+}
+
+// enqueue() - queues a run, on behalf of sch when it isn't nil
+func (r *Runner) enqueue(name string, arg interface{}, sch *schedule) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ctx.Err() != nil {
+		return 0, ErrStopped
+	}
+	if _, ok := r.types[name]; !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownJob, name)
+	}
+
+	r.nextID++
+	e := &entry{
+		run:      Run{ID: r.nextID, Name: name, Status: StatusQueued, QueuedAt: time.Now()},
+		arg:      arg,
+		schedule: sch,
+	}
+
+	//the lock is held, so the channel can't fill up between the check and the send
+	select {
+	case r.queue <- e:
+	default:
+		return 0, ErrQueueFull
+	}
+	r.queued[e.run.ID] = e
+	if sch != nil {
+		sch.active = e
+	}
+	return e.run.ID, nil
+}
+
+// At() - queues a run of the named job at the given time
+func (r *Runner) At(name string, arg interface{}, at time.Time) error {
+	return r.addSchedule(&schedule{name: name, arg: arg, nextRun: at})
+}
+
+// Every() - queues a run of the named job every interval, starting one interval from now,
+// a run is skipped while the previous one is still queued or running
+func (r *Runner) Every(name string, arg interface{}, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("jobs: interval of %q must be greater than zero", name)
+	}
+	return r.addSchedule(&schedule{name: name, arg: arg, every: interval, nextRun: time.Now().Add(interval)})
+}
+
+// addSchedule() - starts the goroutine that queues the schedule's runs until the runner is stopped
+func (r *Runner) addSchedule(sch *schedule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ctx.Err() != nil {
+		return ErrStopped
+	}
+	if _, ok := r.types[sch.name]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownJob, sch.name)
+	}
+	r.schedules = append(r.schedules, sch)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.runSchedule(sch)
+	}()
+	return nil
+}
+
+// runSchedule() - waits for each of the schedule's run times and queues the job
+func (r *Runner) runSchedule(sch *schedule) {
+	for {
+		r.mu.Lock()
+		wait := time.Until(sch.nextRun)
+		r.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		r.mu.Lock()
+		overlapping := sch.active != nil
+		r.mu.Unlock()
+
+		if overlapping {
+			r.logger.PrintDebug("skipping job, the previous run hasn't finished", map[string]interface{}{"job": sch.name})
+		} else {
+			_, err := r.enqueue(sch.name, sch.arg, sch)
+			if err != nil && !errors.Is(err, ErrStopped) {
+				r.logger.PrintError(err, map[string]interface{}{"job": sch.name})
+			}
+		}
+
+		r.mu.Lock()
+		if sch.every == 0 {
+			r.removeSchedule(sch)
+			r.mu.Unlock()
+			return
+		}
+		sch.nextRun = sch.nextRun.Add(sch.every)
+		//a run time missed while the server was busy isn't caught up on
+		if now := time.Now(); sch.nextRun.Before(now) {
+			sch.nextRun = now.Add(sch.every)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// removeSchedule() - forgets a one-off schedule once it has queued its run, r.mu must be held
+func (r *Runner) removeSchedule(sch *schedule) {
+	for i, s := range r.schedules {
+		if s == sch {
+			r.schedules = append(r.schedules[:i], r.schedules[i+1:]...)
+			return
+		}
+	}
+}
+
+// work() - runs queued jobs until the runner is stopped, then drains the queue
+func (r *Runner) work() {
+	for {
+		select {
+		case <-r.ctx.Done():
+			r.drainQueue()
+			return
+		case e := <-r.queue:
+			//select picks at random when both are ready, a run taken at shutdown is drained like the rest
+			if r.ctx.Err() != nil {
+				if r.drain.Err() == nil {
+					r.execute(r.drain, e)
+					r.drainQueue()
+				}
+				return
+			}
+			r.execute(r.ctx, e)
+		}
+	}
+}
+
+// drainQueue() - runs what is left in the queue once the runner is stopped, until the shutdown's
+// context is done
+func (r *Runner) drainQueue() {
+	for {
+		if r.drain.Err() != nil {
+			return
+		}
+		select {
+		case e := <-r.queue:
+			r.execute(r.drain, e)
+		default:
+			return
+		}
+	}
+}
+
+// execute() - runs a job under parent with its deadline, recording how it went
+func (r *Runner) execute(parent context.Context, e *entry) {
+	r.mu.Lock()
+	jt := r.types[e.run.Name]
+	ctx, cancel := parent, context.CancelFunc(func() {})
+	if jt.timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, jt.timeout)
+	}
+	defer cancel()
+
+	started := time.Now()
+	e.run.Status = StatusRunning
+	e.run.StartedAt = &started
+	if deadline, ok := ctx.Deadline(); ok {
+		e.run.Deadline = &deadline
+	}
+	delete(r.queued, e.run.ID)
+	r.running[e.run.ID] = e
+	r.mu.Unlock()
+
+	err := r.call(ctx, jt, e)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	finished := time.Now()
+	e.run.FinishedAt = &finished
+	e.run.Status = StatusSucceeded
+	if err != nil {
+		e.run.Status = StatusFailed
+		e.run.Error = err.Error()
+		r.logger.PrintError(err, map[string]interface{}{"job": e.run.Name, "job_id": e.run.ID}, jsonlog.WithoutTrace())
+	}
+
+	delete(r.running, e.run.ID)
+	if e.schedule != nil && e.schedule.active == e {
+		e.schedule.active = nil
+	}
+	r.recent = append(r.recent, e.run)
+	if len(r.recent) > recentRuns {
+		r.recent = r.recent[len(r.recent)-recentRuns:]
+	}
+}
+
+// call() - calls the job's function, a panic fails the run instead of taking the worker down
+func (r *Runner) call(ctx context.Context, jt jobType, e *entry) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job %s panicked: %v", jt.name, p)
+		}
+	}()
+
+	return jt.fn(ctx, e.arg)
+}
+
+// Status() - what is running, queued and scheduled, along with the most recent finished runs
+func (r *Runner) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := Status{
+		Workers:   r.workers,
+		Types:     make([]string, 0, len(r.types)),
+		Running:   make([]Run, 0, len(r.running)),
+		Queued:    make([]Run, 0, len(r.queued)),
+		Scheduled: make([]Schedule, 0, len(r.schedules)),
+		Recent:    make([]Run, 0, len(r.recent)),
+	}
+	for name := range r.types {
+		status.Types = append(status.Types, name)
+	}
+	sort.Strings(status.Types)
+	for _, e := range r.running {
+		status.Running = append(status.Running, e.run)
+	}
+	sort.Slice(status.Running, func(i, j int) bool { return status.Running[i].ID < status.Running[j].ID })
+	for _, e := range r.queued {
+		status.Queued = append(status.Queued, e.run)
+	}
+	sort.Slice(status.Queued, func(i, j int) bool { return status.Queued[i].ID < status.Queued[j].ID })
+	for _, sch := range r.schedules {
+		s := Schedule{Name: sch.name, NextRun: sch.nextRun}
+		if sch.every > 0 {
+			s.Every = sch.every.String()
+		}
+		status.Scheduled = append(status.Scheduled, s)
+	}
+	//newest first
+	for i := len(r.recent) - 1; i >= 0; i-- {
+		status.Recent = append(status.Recent, r.recent[i])
+	}
+	return status
+}
+
+// Shutdown() - cancels the context of every running job and stops queueing new ones, then runs
+// what is still queued under ctx. It waits until the queue is drained or ctx is done, runs that
+// hadn't started by then are dropped.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.drain = ctx
+	r.cancel()
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queued) > 0 {
+		names := make([]string, 0, len(r.queued))
+		for _, e := range r.queued {
+			names = append(names, e.run.Name)
+		}
+		sort.Strings(names)
+		r.logger.PrintWarn("dropped queued jobs", map[string]interface{}{"jobs": names})
+	}
+	return err
+}
@@ -0,0 +1,344 @@
+// BIOAFF/backend/internal/jobs/jobs_test.go
+
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+)
+
+// syncBuffer - a log destination the workers can write to concurrently
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newTestRunner() - a runner logging to a buffer, shut down when the test ends
+func newTestRunner(t *testing.T, workers, queueSize int) (*Runner, *syncBuffer) {
+	t.Helper()
+
+	logs := &syncBuffer{}
+	r := New(jsonlog.New(logs, jsonlog.LevelDebug), workers, queueSize)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := r.Shutdown(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+	return r, logs
+}
+
+// waitFor() - polls until cond holds, failing the test if it takes too long
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// finished() - the run with the given id once it has finished
+func finished(r *Runner, id int64) (Run, bool) {
+	for _, run := range r.Status().Recent {
+		if run.ID == id {
+			return run, true
+		}
+	}
+	return Run{}, false
+}
+
+func TestEnqueue(t *testing.T) {
+	r, _ := newTestRunner(t, 2, 10)
+
+	got := make(chan interface{}, 1)
+	r.Register("echo", 0, func(ctx context.Context, arg interface{}) error {
+		got <- arg
+		return nil
+	})
+
+	//runs queued before Start wait for a worker
+	id, err := r.Enqueue("echo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued := r.Status().Queued; len(queued) != 1 || queued[0].ID != id {
+		t.Errorf("got queued %+v, want the run", queued)
+	}
+	r.Start()
+
+	if arg := <-got; arg != "hello" {
+		t.Errorf("got arg %v, want hello", arg)
+	}
+	waitFor(t, "the run to finish", func() bool {
+		_, ok := finished(r, id)
+		return ok
+	})
+	run, _ := finished(r, id)
+	if run.Status != StatusSucceeded || run.StartedAt == nil || run.FinishedAt == nil || run.Deadline != nil {
+		t.Errorf("got %+v, want a succeeded run without a deadline", run)
+	}
+
+	_, err = r.Enqueue("nothing", nil)
+	if !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("got %v, want ErrUnknownJob", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	r, _ := newTestRunner(t, 1, 10)
+	r.Register("slow", 20*time.Millisecond, func(ctx context.Context, arg interface{}) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	r.Start()
+
+	id, err := r.Enqueue("slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the run to time out", func() bool {
+		_, ok := finished(r, id)
+		return ok
+	})
+	run, _ := finished(r, id)
+	if run.Status != StatusFailed || !strings.Contains(run.Error, "deadline exceeded") || run.Deadline == nil {
+		t.Errorf("got %+v, want a failed run past its deadline", run)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	r, logs := newTestRunner(t, 1, 10)
+	r.Register("explode", 0, func(ctx context.Context, arg interface{}) error {
+		panic("boom")
+	})
+	r.Register("noop", 0, func(ctx context.Context, arg interface{}) error { return nil })
+	r.Start()
+
+	first, _ := r.Enqueue("explode", nil)
+	second, _ := r.Enqueue("noop", nil)
+
+	//the worker survives the panic and carries on with the next run
+	waitFor(t, "both runs to finish", func() bool {
+		_, ok1 := finished(r, first)
+		_, ok2 := finished(r, second)
+		return ok1 && ok2
+	})
+	if run, _ := finished(r, first); run.Status != StatusFailed || !strings.Contains(run.Error, "boom") {
+		t.Errorf("got %+v, want a failed run", run)
+	}
+	if !strings.Contains(logs.String(), `"job":"explode"`) {
+		t.Errorf("the panic wasn't logged with the job name: %s", logs)
+	}
+}
+
+func TestBoundedWorkers(t *testing.T) {
+	r, _ := newTestRunner(t, 2, 10)
+
+	var running, most int32
+	release := make(chan struct{})
+	r.Register("block", 0, func(ctx context.Context, arg interface{}) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	r.Start()
+
+	ids := make([]int64, 5)
+	for i := range ids {
+		ids[i], _ = r.Enqueue("block", nil)
+	}
+	waitFor(t, "the workers to fill up", func() bool {
+		s := r.Status()
+		return len(s.Running) == 2 && len(s.Queued) == 3
+	})
+
+	close(release)
+	waitFor(t, "every run to finish", func() bool {
+		return len(r.Status().Recent) == len(ids)
+	})
+	if most := atomic.LoadInt32(&most); most != 2 {
+		t.Errorf("got %d runs at once, want 2", most)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	r, _ := newTestRunner(t, 1, 1)
+	r.Register("noop", 0, func(ctx context.Context, arg interface{}) error { return nil })
+
+	//not started, so the first run fills the queue
+	if _, err := r.Enqueue("noop", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Enqueue("noop", nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got %v, want ErrQueueFull", err)
+	}
+}
+
+func TestEvery(t *testing.T) {
+	r, _ := newTestRunner(t, 4, 10)
+
+	var runs, running, overlapped int32
+	r.Register("tick", 0, func(ctx context.Context, arg interface{}) error {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+		time.Sleep(15 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+	if err := r.Every("tick", nil, 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Every("tick", nil, 0); err == nil {
+		t.Error("accepted a zero interval")
+	}
+	r.Start()
+
+	waitFor(t, "a few runs", func() bool { return atomic.LoadInt32(&runs) >= 3 })
+	if atomic.LoadInt32(&overlapped) != 0 {
+		t.Error("a recurring job ran while its previous run was still going")
+	}
+	if s := r.Status().Scheduled; len(s) != 1 || s[0].Every != "5ms" {
+		t.Errorf("got scheduled %+v", s)
+	}
+}
+
+func TestAt(t *testing.T) {
+	r, _ := newTestRunner(t, 1, 10)
+
+	var runs int32
+	r.Register("once", 0, func(ctx context.Context, arg interface{}) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+	r.Start()
+
+	if err := r.At("once", nil, time.Now().Add(20*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Status().Scheduled) != 1 {
+		t.Error("the run isn't scheduled")
+	}
+
+	waitFor(t, "the scheduled run", func() bool { return atomic.LoadInt32(&runs) == 1 })
+	waitFor(t, "the schedule to be forgotten", func() bool { return len(r.Status().Scheduled) == 0 })
+	time.Sleep(30 * time.Millisecond)
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("got %d runs, want 1", n)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	logs := &syncBuffer{}
+	r := New(jsonlog.New(logs, jsonlog.LevelDebug), 1, 10)
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	r.Register("wait", 0, func(ctx context.Context, arg interface{}) error {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+	var drained int32
+	r.Register("queued", 0, func(ctx context.Context, arg interface{}) error {
+		if ctx.Err() != nil {
+			t.Error("a queued run was drained with a cancelled context")
+		}
+		atomic.AddInt32(&drained, 1)
+		return nil
+	})
+	r.Start()
+
+	r.Enqueue("wait", nil)
+	<-started
+	r.Enqueue("queued", nil)
+	r.Enqueue("queued", nil)
+
+	//the running job is cancelled and waited for, then the queued ones are run
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-cancelled:
+	default:
+		t.Error("shutdown returned before the running job did")
+	}
+	if n := atomic.LoadInt32(&drained); n != 2 {
+		t.Errorf("got %d queued runs drained, want 2", n)
+	}
+	if strings.Contains(logs.String(), "dropped queued jobs") {
+		t.Error("runs were dropped with time left to drain them")
+	}
+
+	if _, err := r.Enqueue("wait", nil); !errors.Is(err, ErrStopped) {
+		t.Errorf("got %v, want ErrStopped", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	logs := &syncBuffer{}
+	r := New(jsonlog.New(logs, jsonlog.LevelDebug), 1, 10)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r.Register("stubborn", 0, func(ctx context.Context, arg interface{}) error {
+		close(started)
+		<-release
+		return nil
+	})
+	r.Register("never", 0, func(ctx context.Context, arg interface{}) error {
+		t.Error("a queued run started after the shutdown's deadline")
+		return nil
+	})
+	r.Start()
+	r.Enqueue("stubborn", nil)
+	<-started
+	r.Enqueue("never", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline exceeded", err)
+	}
+	if !strings.Contains(logs.String(), "dropped queued jobs") {
+		t.Error("the dropped run wasn't logged")
+	}
+
+	//the worker stops once the stubborn job returns, without starting the queued run
+	close(release)
+	r.wg.Wait()
+}
@@ -4,10 +4,13 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,77 +19,193 @@ type Level int8
 
 // levels start at zero
 const (
-	LevelInfo  Level = iota //value is 0
-	LevelError              //value is 1
-	LevelFatal              //value is 2
-	LevelOff                //value is 3
+	LevelDebug Level = iota //value is 0
+	LevelInfo               //value is 1
+	LevelWarn               //value is 2
+	LevelError              //value is 3
+	LevelFatal              //value is 4
+	LevelOff                //value is 5
 )
 
 // The severity levels as a human readable friendly format
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 }
 
+// ParseLevel() - converts a level name such as "debug" or "ERROR" back into a Level
+func ParseLevel(name string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(name, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Option changes how a single log entry is written
+type Option func(*entry)
+
+// WithTrace() - includes the stack trace in the entry, whatever its level
+func WithTrace() Option {
+	return func(e *entry) {
+		e.trace = true
+	}
+}
+
+// WithoutTrace() - leaves the stack trace out of an error entry, e.g. for routine failures
+func WithoutTrace() Option {
+	return func(e *entry) {
+		e.trace = false
+	}
+}
+
+// entry - the settings for a single log entry
+type entry struct {
+	trace bool
+}
+
 // Defind a custom logger
+// child loggers created by With() share the sinks, mutex and level of their parent
 type Logger struct {
-	out      io.Writer
-	minLevel Level
+	*output
+	fields map[string]interface{}
+}
+
+// Sink is a destination for log entries, only entries at MinLevel or above are written to it
+type Sink struct {
+	Out      io.Writer
+	MinLevel Level
+}
+
+// output - the parts of a logger shared between a logger and all of its children
+type output struct {
+	sinks    []Sink
+	minLevel atomic.Int32
+	redactor atomic.Pointer[Redactor]
 	mu       sync.Mutex
 }
 
 // The new() function creates a new instance of logger
 func New(out io.Writer, minLevel Level) *Logger {
+	return NewMulti(minLevel, Sink{Out: out, MinLevel: LevelDebug})
+}
+
+// NewMulti() - creates a logger which fans every entry out to several sinks,
+// minLevel applies to the logger as a whole and each sink can be stricter still
+// sensitive values are hidden by the DefaultRedactor() until SetRedactor() says otherwise
+func NewMulti(minLevel Level, sinks ...Sink) *Logger {
+	l := &Logger{
+		output: &output{sinks: sinks},
+	}
+	l.SetLevel(minLevel)
+	l.SetRedactor(DefaultRedactor())
+	return l
+}
+
+// With() - returns a child logger which adds the fields to every entry it writes
+// fields passed to a print method take precedence over the bound fields with the same key
+func (l *Logger) With(fields map[string]interface{}) *Logger {
+	merged := make(map[string]interface{}, len(l.fields)+len(fields))
+	for key, value := range l.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
 	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+		output: l.output,
+		fields: merged,
 	}
 }
 
+// SetLevel() - changes the minimum severity level of the logger and all of its children,
+// safe to call while the logger is in use
+func (l *Logger) SetLevel(level Level) {
+	l.minLevel.Store(int32(level))
+}
+
+// SetRedactor() - changes the redaction rules of the logger and all of its children, nil turns redaction off
+func (l *Logger) SetRedactor(rd *Redactor) {
+	l.redactor.Store(rd)
+}
+
+// Level() - returns the current minimum severity level
+func (l *Logger) Level() Level {
+	return Level(l.minLevel.Load())
+}
+
 // Helper methods
-func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+func (l *Logger) PrintDebug(message string, properties map[string]interface{}, opts ...Option) {
+	l.print(LevelDebug, message, properties, opts...)
+}
+
+func (l *Logger) PrintInfo(message string, properties map[string]interface{}, opts ...Option) {
+	l.print(LevelInfo, message, properties, opts...)
+}
+
+func (l *Logger) PrintWarn(message string, properties map[string]interface{}, opts ...Option) {
+	l.print(LevelWarn, message, properties, opts...)
 }
 
-func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+func (l *Logger) PrintError(err error, properties map[string]interface{}, opts ...Option) {
+	l.print(LevelError, err.Error(), properties, opts...)
 }
 
-func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+func (l *Logger) PrintFatal(err error, properties map[string]interface{}, opts ...Option) {
+	l.print(LevelFatal, err.Error(), properties, opts...)
 	os.Exit(1)
 }
 
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+func (l *Logger) print(level Level, message string, properties map[string]interface{}, opts ...Option) (int, error) {
 	//Ensure severity level is at least minimal
-	if level < l.minLevel {
+	if level < l.Level() {
 		return 0, nil
 	}
 
+	//errors and worse carry a stack trace unless the caller says otherwise
+	e := entry{trace: level >= LevelError}
+	for _, opt := range opts {
+		opt(&e)
+	}
+
+	//hide sensitive values before anything is written
+	props := normalize(l.merge(properties))
+	if rd := l.redactor.Load(); rd != nil {
+		message = rd.String(message)
+		props = rd.Properties(props)
+	}
+
 	//Create a struct for holding the log entry data
 	data := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitemtpy"`
+		Level      string                 `json:"level"`
+		Time       string                 `json:"time"`
+		Message    string                 `json:"message"`
+		Properties map[string]interface{} `json:"properties,omitempty"`
+		Trace      string                 `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
 		Time:       time.Now().UTC().Format(time.RFC3339),
 		Message:    message,
-		Properties: properties,
+		Properties: props,
 	}
 
 	//should we include the stack trace?
-	if level >= LevelError {
+	if e.trace {
 		data.Trace = string(debug.Stack())
 	}
 
@@ -97,13 +216,67 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		line = []byte(LevelError.String() + ": unable to marshal log message" + err.Error())
 	}
 
-	//Prepare to write the log entry
+	//Prepare to write the log entry to every sink that wants it
+	line = append(line, '\n')
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	return l.out.Write(append(line, '\n'))
+
+	var firstErr error
+	for _, sink := range l.sinks {
+		if level < sink.MinLevel {
+			continue
+		}
+		_, err := sink.Out.Write(line)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return len(line), nil
+}
+
+// merge() - combines the bound fields with the properties of a single entry
+func (l *Logger) merge(properties map[string]interface{}) map[string]interface{} {
+	if len(l.fields) == 0 {
+		return properties
+	}
+
+	merged := make(map[string]interface{}, len(l.fields)+len(properties))
+	for key, value := range l.fields {
+		merged[key] = value
+	}
+	for key, value := range properties {
+		merged[key] = value
+	}
+	return merged
+}
+
+// normalize() - converts property values which don't marshal in a readable way,
+// durations become "1.5s" instead of nanoseconds and errors become their message
+func normalize(properties map[string]interface{}) map[string]interface{} {
+	if properties == nil {
+		return nil
+	}
+
+	normalized := make(map[string]interface{}, len(properties))
+	for key, value := range properties {
+		switch v := value.(type) {
+		case time.Duration:
+			normalized[key] = v.String()
+		case error:
+			normalized[key] = v.Error()
+		case map[string]interface{}:
+			normalized[key] = normalize(v)
+		default:
+			normalized[key] = v
+		}
+	}
+	return normalized
 }
 
 // Implement the io.Write interface
-func (l *Logger) Wrtie(message []byte) (n int, err error) {
-	return l.print(LevelError, string(message), nil)
+func (l *Logger) Write(message []byte) (n int, err error) {
+	return l.print(LevelError, string(message), nil, WithoutTrace())
 }
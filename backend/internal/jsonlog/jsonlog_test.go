@@ -0,0 +1,178 @@
+// BIOAFF/backend/internal/jsonlog/jsonlog_test.go
+
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logLine - a decoded log entry
+type logLine struct {
+	Level      string                 `json:"level"`
+	Time       string                 `json:"time"`
+	Message    string                 `json:"message"`
+	Properties map[string]interface{} `json:"properties"`
+	Trace      string                 `json:"trace"`
+}
+
+// decodeLines() - decodes every line written to buf
+func decodeLines(t *testing.T, buf *bytes.Buffer) []logLine {
+	t.Helper()
+
+	var lines []logLine
+	for _, raw := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if raw == "" {
+			continue
+		}
+		var line logLine
+		err := json.Unmarshal([]byte(raw), &line)
+		if err != nil {
+			t.Fatalf("line %q: %s", raw, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestLevels(t *testing.T) {
+	tests := []struct {
+		minLevel Level
+		want     []string
+	}{
+		{LevelDebug, []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{LevelInfo, []string{"INFO", "WARN", "ERROR"}},
+		{LevelWarn, []string{"WARN", "ERROR"}},
+		{LevelError, []string{"ERROR"}},
+		{LevelOff, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.minLevel.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			l := New(&buf, tt.minLevel)
+			l.PrintDebug("debug", nil)
+			l.PrintInfo("info", nil)
+			l.PrintWarn("warn", nil)
+			l.PrintError(errors.New("error"), nil)
+
+			var got []string
+			for _, line := range decodeLines(t, &buf) {
+				if line.Message != strings.ToLower(line.Level) {
+					t.Errorf("got message %q at level %s", line.Message, line.Level)
+				}
+				if _, err := time.Parse(time.RFC3339, line.Time); err != nil {
+					t.Errorf("got time %q, want RFC 3339", line.Time)
+				}
+				got = append(got, line.Level)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got levels %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		for _, name := range []string{l.String(), strings.ToLower(l.String())} {
+			got, err := ParseLevel(name)
+			if err != nil || got != l {
+				t.Errorf("ParseLevel(%q) got %s, %v", name, got, err)
+			}
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel accepted an unknown level")
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelInfo)
+	child := l.With(map[string]interface{}{"request_id": "abc"})
+
+	child.PrintDebug("hidden", nil)
+	l.SetLevel(LevelDebug)
+	child.PrintDebug("shown", nil)
+	if child.Level() != LevelDebug {
+		t.Errorf("got child level %s, want DEBUG", child.Level())
+	}
+
+	lines := decodeLines(t, &buf)
+	if len(lines) != 1 || lines[0].Message != "shown" {
+		t.Errorf("got %+v, want only the entry written after the level changed", lines)
+	}
+}
+
+func TestProperties(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelDebug)
+	l.SetRedactor(nil)
+
+	l.With(map[string]interface{}{"user_id": 1, "request_id": "abc"}).PrintInfo("typed", map[string]interface{}{
+		"user_id":  7, //wins over the bound field
+		"count":    3,
+		"ratio":    0.5,
+		"enabled":  true,
+		"took":     1500 * time.Millisecond,
+		"err":      errors.New("boom"),
+		"nested":   map[string]interface{}{"wait": 2 * time.Second, "ok": false},
+		"list":     []string{"a", "b"},
+		"nothing":  nil,
+		"email_to": "someone",
+	})
+
+	lines := decodeLines(t, &buf)
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1", len(lines))
+	}
+	js, err := json.Marshal(lines[0].Properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"count":3,"email_to":"someone","enabled":true,"err":"boom","list":["a","b"],"nested":{"ok":false,"wait":"2s"},"nothing":null,"ratio":0.5,"request_id":"abc","took":"1.5s","user_id":7}`
+	if string(js) != want {
+		t.Errorf("got properties\n%s\nwant\n%s", js, want)
+	}
+}
+
+func TestTraces(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelDebug)
+
+	l.PrintError(errors.New("with trace"), nil)
+	l.PrintError(errors.New("without trace"), nil, WithoutTrace())
+	l.PrintInfo("asked for a trace", nil, WithTrace())
+	l.PrintWarn("no trace", nil)
+	_, err := l.Write([]byte("from the http server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{"with trace": true, "without trace": false, "asked for a trace": true, "no trace": false, "from the http server": false}
+	for _, line := range decodeLines(t, &buf) {
+		if got := line.Trace != ""; got != want[line.Message] {
+			t.Errorf("%q: got trace %t, want %t", line.Message, got, want[line.Message])
+		}
+	}
+}
+
+func TestSinks(t *testing.T) {
+	var all, errs bytes.Buffer
+	l := NewMulti(LevelInfo, Sink{Out: &all, MinLevel: LevelDebug}, Sink{Out: &errs, MinLevel: LevelError})
+
+	l.PrintDebug("below the logger's level", nil)
+	l.PrintInfo("info", nil)
+	l.PrintError(errors.New("error"), nil)
+
+	if lines := decodeLines(t, &all); len(lines) != 2 {
+		t.Errorf("got %d lines in the first sink, want 2", len(lines))
+	}
+	if lines := decodeLines(t, &errs); len(lines) != 1 || lines[0].Level != "ERROR" {
+		t.Errorf("got %+v in the error sink, want only the error", lines)
+	}
+}
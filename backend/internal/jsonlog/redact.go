@@ -0,0 +1,136 @@
+// BIOAFF/backend/internal/jsonlog/redact.go
+
+package jsonlog
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm/backend/internal/validator"
+)
+
+// Redacted is written in place of every sensitive value
+const Redacted = "[REDACTED]"
+
+var (
+	//the validator's email regex is anchored to whole values, log lines need it to match anywhere
+	EmailPattern = regexp.MustCompile(strings.TrimSuffix(strings.TrimPrefix(validator.EmailRX.String(), "^"), "$"))
+
+	//Authorization header values, e.g. "Bearer Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"
+	BearerTokenPattern = regexp.MustCompile(`(?i)\b(bearer|apikey)\s+[a-zA-Z0-9\-._~+/]+=*`)
+
+	//social security numbers in their dashed form, e.g. 029-48-5030, a bare run of nine digits is just as
+	//likely an id or a duration so those are only redacted under the ssn keys
+	SSNPattern = regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)
+
+	//secrets passed in query strings, e.g. ?token=Y3QMGX3PJ3WLRL2YRTQGQ6KRHU
+	QuerySecretPattern = regexp.MustCompile(`(?i)\b(token|password|secret|api_key|code)=[^&\s"]+`)
+
+	//value patterns used by the default redactor
+	DefaultRedactionPatterns = []*regexp.Regexp{BearerTokenPattern, QuerySecretPattern, EmailPattern, SSNPattern}
+
+	//property keys which always hold something sensitive, matched anywhere in the key
+	DefaultRedactedKeys = []string{
+		"password", "token", "secret", "authorization", "cookie", "dsn",
+		"email", "social_security", "ssn", "passport", "tax_num", "phone",
+	}
+)
+
+// Redactor hides sensitive values before a log entry is written
+// properties are redacted by key name, the message and string values by pattern
+type Redactor struct {
+	keys     []string
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor() - creates a redactor for the given key names and value patterns
+func NewRedactor(keys []string, patterns ...*regexp.Regexp) *Redactor {
+	lowered := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lowered = append(lowered, strings.ToLower(key))
+	}
+	return &Redactor{
+		keys:     lowered,
+		patterns: patterns,
+	}
+}
+
+// DefaultRedactor() - redacts the default keys, emails, bearer tokens, dashed ssns and query string secrets
+func DefaultRedactor() *Redactor {
+	return NewRedactor(DefaultRedactedKeys, DefaultRedactionPatterns...)
+}
+
+// ParseRedactionPatterns() - compiles user supplied value patterns, e.g. from a flag
+func ParseRedactionPatterns(exprs []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		rx, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", expr, err)
+		}
+		patterns = append(patterns, rx)
+	}
+	return patterns, nil
+}
+
+// sensitiveKey() - checks if a property key is one whose value is never logged
+func (rd *Redactor) sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range rd.keys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// String() - replaces every match of the value patterns
+func (rd *Redactor) String(s string) string {
+	for _, rx := range rd.patterns {
+		s = rx.ReplaceAllString(s, Redacted)
+	}
+	return s
+}
+
+// Properties() - returns a redacted copy of normalized properties
+func (rd *Redactor) Properties(properties map[string]interface{}) map[string]interface{} {
+	if properties == nil {
+		return nil
+	}
+
+	redacted := make(map[string]interface{}, len(properties))
+	for key, value := range properties {
+		if rd.sensitiveKey(key) {
+			redacted[key] = Redacted
+			continue
+		}
+		redacted[key] = rd.value(value)
+	}
+	return redacted
+}
+
+// value() - redacts a single property value, numbers are checked in their printed form
+// so a configured pattern catches them as well as strings
+func (rd *Redactor) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return rd.String(v)
+	case map[string]interface{}:
+		return rd.Properties(v)
+	case []string:
+		redacted := make([]string, len(v))
+		for i := range v {
+			redacted[i] = rd.String(v[i])
+		}
+		return redacted
+	case int, int32, int64, uint, uint32, uint64:
+		s := fmt.Sprint(v)
+		if rd.String(s) != s {
+			return Redacted
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,251 @@
+// BIOAFF/backend/internal/jsonlog/rotate.go
+
+package jsonlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat - rotated files are named after the time they were rotated, which also keeps them in order
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile is an io.Writer that writes to a file and rotates it once it grows too large or too old
+// rotated files are optionally gzipped and only the newest MaxBackups of them are kept
+type RotatingFile struct {
+	filename   string
+	maxSize    int64         //bytes, zero for no limit
+	maxAge     time.Duration //zero for no limit
+	maxBackups int           //zero keeps every rotated file
+	compress   bool
+
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	startedAt time.Time //when the file's first entry was written, it survives restarts
+
+	//compressing and pruning happens in the background, one run at a time
+	millMu sync.Mutex
+	wg     sync.WaitGroup
+}
+
+// NewRotatingFile() - opens, or creates, the log file for appending
+func NewRotatingFile(filename string, maxSize int64, maxAge time.Duration, maxBackups int, compress bool) (*RotatingFile, error) {
+	f := &RotatingFile{
+		filename:   filename,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+		compress:   compress,
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write() - writes a log line, rotating the file first if the line would take it over its limits
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tooLarge := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	tooOld := f.maxAge > 0 && f.size > 0 && time.Since(f.startedAt) > f.maxAge
+	if tooLarge || tooOld {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if f.size == 0 {
+		f.startedAt = time.Now()
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen() - closes and reopens the log file, used after an external tool has moved it away
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Close() - closes the log file and waits for any background compression to finish
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	err := f.file.Close()
+	f.mu.Unlock()
+
+	f.wg.Wait()
+	return err
+}
+
+// open() - opens the log file for appending, creating it and its directory when needed
+func (f *RotatingFile) open() error {
+	err := os.MkdirAll(filepath.Dir(f.filename), 0o755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.startedAt = startedAt(f.filename, info)
+	return nil
+}
+
+// startedAt() - when an existing log file was started, taken from the time of its first entry so a restart
+// doesn't reset the file's age, falling back to the time it was last written
+func startedAt(filename string, info os.FileInfo) time.Time {
+	if info.Size() == 0 {
+		return time.Now()
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return info.ModTime()
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(file, 64*1024)).ReadBytes('\n')
+	if err != nil {
+		return info.ModTime()
+	}
+	var first struct {
+		Time time.Time `json:"time"`
+	}
+	if json.Unmarshal(line, &first) != nil || first.Time.IsZero() {
+		return info.ModTime()
+	}
+	return first.Time
+}
+
+// rotate() - moves the current file aside and starts a new one, the caller must hold f.mu
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+
+	backup := f.backupName(time.Now())
+	err = os.Rename(f.filename, backup)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = f.open()
+	if err != nil {
+		return err
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.mill(backup)
+	}()
+	return nil
+}
+
+// backupName() - e.g. bioaff.log becomes bioaff-20230314T101500.000.log
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.filename)
+	prefix := strings.TrimSuffix(f.filename, ext)
+	return prefix + "-" + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// mill() - compresses a freshly rotated file and removes the backups we no longer keep
+// errors are ignored, there is nowhere left to log them and the next rotation tries again
+func (f *RotatingFile) mill(backup string) {
+	f.millMu.Lock()
+	defer f.millMu.Unlock()
+
+	if f.compress {
+		compressFile(backup)
+	}
+
+	if f.maxBackups <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(f.filename)
+	prefix := filepath.Base(strings.TrimSuffix(f.filename, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.filename))
+	if err != nil {
+		return
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		//only names holding a rotation timestamp are ours, other logs may share the directory
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, name)
+		}
+	}
+
+	//the timestamps sort oldest first, so everything before the newest maxBackups goes
+	sort.Strings(backups)
+	for len(backups) > f.maxBackups {
+		os.Remove(filepath.Join(filepath.Dir(f.filename), backups[0]))
+		backups = backups[1:]
+	}
+}
+
+// compressFile() - gzips the file next to itself and removes the original
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,173 @@
+// BIOAFF/backend/internal/jsonlog/rotate_test.go
+
+package jsonlog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backups() - the rotated files next to the log file, oldest first
+func backups(t *testing.T, filename string) []string {
+	t.Helper()
+
+	matches, err := filepath.Glob(strings.TrimSuffix(filename, ".log") + "-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+// writeLine() - writes a log line with the given time, as the logger would
+func writeLine(t *testing.T, f *RotatingFile, at time.Time) {
+	t.Helper()
+
+	_, err := f.Write([]byte(`{"level":"INFO","time":"` + at.UTC().Format(time.RFC3339) + `","message":"line"}` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//backups are named to the millisecond
+	time.Sleep(2 * time.Millisecond)
+}
+
+func TestRotateBySize(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bioaff.log")
+	f, err := NewRotatingFile(filename, 150, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//each line is about 64 bytes, so two fit in a file
+	for i := 0; i < 4; i++ {
+		writeLine(t, f, time.Now())
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := backups(t, filename); len(got) != 1 {
+		t.Errorf("got backups %v, want 1", got)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 150 {
+		t.Errorf("got a %d byte log file, want at most 150", info.Size())
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bioaff.log")
+
+	//a file started two days ago by an earlier run of the api
+	f, err := NewRotatingFile(filename, 0, 24*time.Hour, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLine(t, f, time.Now().Add(-48*time.Hour))
+	f.Close()
+
+	//the restart doesn't reset its age, so the next line starts a new file
+	f, err = NewRotatingFile(filename, 0, 24*time.Hour, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLine(t, f, time.Now())
+	writeLine(t, f, time.Now())
+	f.Close()
+
+	if got := backups(t, filename); len(got) != 1 {
+		t.Errorf("got backups %v, want 1", got)
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(content), "\n"); n != 2 {
+		t.Errorf("got %d lines in the new file, want 2", n)
+	}
+}
+
+func TestStartedAtFallsBackToModTime(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bioaff.log")
+	err := os.WriteFile(filename, []byte("not json\n"), 0o640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err = os.Chtimes(filename, old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := startedAt(filename, info); !got.Equal(old) {
+		t.Errorf("got %s, want the modification time %s", got, old)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bioaff.log")
+	//another log sharing the directory is left alone
+	other := filepath.Join(filepath.Dir(filename), "bioaff-access.log")
+	err := os.WriteFile(other, nil, 0o640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewRotatingFile(filename, 1, 0, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		writeLine(t, f, time.Now())
+		//let each rotation finish compressing so the backups are pruned in order
+		f.wg.Wait()
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kept []string
+	for _, name := range backups(t, filename) {
+		if name != other {
+			kept = append(kept, name)
+		}
+	}
+	if len(kept) != 2 {
+		t.Fatalf("got backups %v, want the newest 2", kept)
+	}
+	for _, name := range kept {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("%s wasn't compressed", name)
+			continue
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(gz)
+		file.Close()
+		if err != nil || !strings.Contains(string(content), `"message":"line"`) {
+			t.Errorf("%s holds %q, %v", name, content, err)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("the other log was removed: %v", err)
+	}
+}
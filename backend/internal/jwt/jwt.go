@@ -0,0 +1,418 @@
+// BIOAFF/backend/internal/jwt/jwt.go
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the signing algorithms, every key is tied to one so a token can't pick a weaker one for itself,
+// RS256 keys are only ever someone else's public keys, such as an OpenID provider's
+const (
+	EdDSA = "EdDSA"
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+// minSecretSize - HMAC secrets shorter than the hash output are refused
+const minSecretSize = 32
+
+// minRSASize - RSA keys shorter than this many bits are refused
+const minRSASize = 2048
+
+var (
+	ErrInvalidToken = errors.New("jwt: invalid token")
+	ErrExpired      = errors.New("jwt: token has expired")
+	ErrUnknownKey   = errors.New("jwt: unknown key")
+)
+
+// encoding - every part of a token is unpadded base64url
+var encoding = base64.RawURLEncoding
+
+// Claims are the registered claims the api uses, along with its own about the account
+type Claims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti,omitempty"`
+
+	Kind      string `json:"kind"`          //admin or public
+	Email     string `json:"email"`         //the account's email when the token was issued
+	Activated bool   `json:"activated"`     //whether the account was activated when the token was issued
+	SessionID int64  `json:"sid,omitempty"` //the refresh token family the token was issued for
+}
+
+// header is the JOSE header of a token
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// Key signs and verifies tokens with one algorithm, Ed25519 keys may be public only once rotated out
+type Key struct {
+	ID        string
+	Algorithm string
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+	secret    []byte
+	rsa       *rsa.PublicKey
+}
+
+// NewEd25519Key() - a key signing with an Ed25519 private key
+func NewEd25519Key(id string, private ed25519.PrivateKey) *Key {
+	return &Key{ID: id, Algorithm: EdDSA, private: private, public: private.Public().(ed25519.PublicKey)}
+}
+
+// NewEd25519PublicKey() - a key which only verifies, for keys rotated out while their tokens may still be in use
+func NewEd25519PublicKey(id string, public ed25519.PublicKey) *Key {
+	return &Key{ID: id, Algorithm: EdDSA, public: public}
+}
+
+// NewHMACKey() - a key signing with a shared secret, it is never published
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) < minSecretSize {
+		return nil, fmt.Errorf("jwt: key %q: the secret must be at least %d bytes", id, minSecretSize)
+	}
+	return &Key{ID: id, Algorithm: HS256, secret: secret}, nil
+}
+
+// NewRSAPublicKey() - a key which only verifies RS256 signatures
+func NewRSAPublicKey(id string, public *rsa.PublicKey) (*Key, error) {
+	if public.N.BitLen() < minRSASize {
+		return nil, fmt.Errorf("jwt: key %q: RSA keys must be at least %d bits", id, minRSASize)
+	}
+	return &Key{ID: id, Algorithm: RS256, rsa: public}, nil
+}
+
+// ParseKey() - reads a key from its file, a PEM PKCS #8 private or PKIX public key for EdDSA,
+// and a base64 secret for HS256
+func ParseKey(id, algorithm string, material []byte) (*Key, error) {
+	switch algorithm {
+	case EdDSA:
+		block, _ := pem.Decode(material)
+		if block == nil {
+			return nil, fmt.Errorf("jwt: key %q: no PEM block found", id)
+		}
+		switch block.Type {
+		case "PRIVATE KEY":
+			k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("jwt: key %q: %w", id, err)
+			}
+			private, ok := k.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("jwt: key %q: not an Ed25519 private key", id)
+			}
+			return NewEd25519Key(id, private), nil
+		case "PUBLIC KEY":
+			k, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("jwt: key %q: %w", id, err)
+			}
+			public, ok := k.(ed25519.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("jwt: key %q: not an Ed25519 public key", id)
+			}
+			return NewEd25519PublicKey(id, public), nil
+		default:
+			return nil, fmt.Errorf("jwt: key %q: unexpected PEM block %q", id, block.Type)
+		}
+	case HS256:
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(material)))
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q: the secret must be base64: %w", id, err)
+		}
+		return NewHMACKey(id, secret)
+	default:
+		return nil, fmt.Errorf("jwt: key %q: unsupported algorithm %q", id, algorithm)
+	}
+}
+
+// CanSign() - reports if the key holds what is needed to sign, not just to verify
+func (k *Key) CanSign() bool {
+	return k.private != nil || k.secret != nil
+}
+
+// sign() - the signature of the signing input
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch {
+	case k.Algorithm == EdDSA && k.private != nil:
+		return ed25519.Sign(k.private, input), nil
+	case k.Algorithm == HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	default:
+		return nil, fmt.Errorf("jwt: key %q can't sign", k.ID)
+	}
+}
+
+// verify() - checks the signature of the signing input
+func (k *Key) verify(input, signature []byte) bool {
+	switch k.Algorithm {
+	case EdDSA:
+		return ed25519.Verify(k.public, input, signature)
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case RS256:
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// KeySet signs with one key and verifies with any of them, so keys can be rotated without
+// cutting off the tokens signed with the previous one
+type KeySet struct {
+	issuer  string
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet() - a key set signing with the key named signingID, tokens are issued by and must come from issuer.
+// Without a signingID the set only verifies.
+func NewKeySet(issuer, signingID string, keys ...*Key) (*KeySet, error) {
+	s := &KeySet{issuer: issuer, keys: make(map[string]*Key, len(keys))}
+	for _, k := range keys {
+		if _, ok := s.keys[k.ID]; ok {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", k.ID)
+		}
+		s.keys[k.ID] = k
+	}
+	if signingID == "" {
+		return s, nil
+	}
+
+	signing, ok := s.keys[signingID]
+	if !ok {
+		return nil, fmt.Errorf("jwt: %w %q to sign with", ErrUnknownKey, signingID)
+	}
+	if !signing.CanSign() {
+		return nil, fmt.Errorf("jwt: key %q is public only and can't sign", signingID)
+	}
+	s.signing = signing
+	return s, nil
+}
+
+// Sign() - a signed token carrying the claims, the issuer is filled in
+func (s *KeySet) Sign(claims Claims) (string, error) {
+	claims.Issuer = s.issuer
+	return s.SignPayload(claims)
+}
+
+// SignPayload() - a signed token carrying any claims, as they are
+func (s *KeySet) SignPayload(claims interface{}) (string, error) {
+	if s.signing == nil {
+		return "", errors.New("jwt: the key set only verifies")
+	}
+
+	h, err := json.Marshal(header{Algorithm: s.signing.Algorithm, Type: "JWT", KeyID: s.signing.ID})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	input := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+	signature, err := s.signing.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verify() - checks the token's signature, issuer and times, returning its claims
+func (s *KeySet) Verify(token string, now time.Time) (*Claims, error) {
+	payload, err := s.VerifyPayload(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil || claims.Issuer != s.issuer || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	if claims.ExpiresAt == 0 || now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// VerifyPayload() - checks the token's signature only, returning its JSON claims for the caller to check
+func (s *KeySet) VerifyPayload(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	err := decodePart(parts[0], &h)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	k, ok := s.keys[h.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	//the algorithm is the key's, the header only has to agree with it
+	if h.Algorithm != k.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil || !k.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return payload, nil
+}
+
+// decodePart() - decodes a base64url JSON part of a token
+func decodePart(part string, dst interface{}) error {
+	js, err := encoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, dst)
+}
+
+// LooksLikeJWT() - reports if a bearer token has the shape of a JWT rather than an opaque token
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// JWK is a public key in a JWKS (RFC 7517), only Ed25519 keys are published (RFC 8037)
+// while RSA ones are read from other parties' sets
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+}
+
+// ParseJWKS() - the signature keys of a JWKS document, keys for other uses or of a kind we can't verify with
+// are left out. A key which can't be used, such as a short RSA key or a second key with the same id, is
+// skipped with the reason returned in skipped, so one bad key doesn't take the rest of the set with it.
+func ParseJWKS(document []byte) (keys []*Key, skipped []error, err error) {
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	err = json.Unmarshal(document, &set)
+	if err != nil {
+		return nil, nil, fmt.Errorf("jwt: JWKS: %w", err)
+	}
+
+	keys = []*Key{}
+	seen := make(map[string]bool, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var k *Key
+		switch {
+		case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519" && (jwk.Algorithm == "" || jwk.Algorithm == EdDSA):
+			k, err = parseEd25519JWK(jwk)
+		case jwk.KeyType == "RSA" && (jwk.Algorithm == "" || jwk.Algorithm == RS256):
+			k, err = parseRSAJWK(jwk)
+		default:
+			continue
+		}
+		if err == nil && seen[jwk.KeyID] {
+			//tokens name the key they were signed with, two keys by the same name can't be told apart
+			err = fmt.Errorf("jwt: JWKS: key %q: duplicate key id", jwk.KeyID)
+		}
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		seen[jwk.KeyID] = true
+		keys = append(keys, k)
+	}
+	return keys, skipped, nil
+}
+
+// parseEd25519JWK() - the Ed25519 public key of a JWK
+func parseEd25519JWK(jwk JWK) (*Key, error) {
+	x, err := encoding.DecodeString(jwk.X)
+	if err != nil || len(x) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("jwt: JWKS: key %q: invalid x", jwk.KeyID)
+	}
+	return NewEd25519PublicKey(jwk.KeyID, ed25519.PublicKey(x)), nil
+}
+
+// parseRSAJWK() - the RSA public key of a JWK
+func parseRSAJWK(jwk JWK) (*Key, error) {
+	n, err := encoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: JWKS: key %q: invalid n", jwk.KeyID)
+	}
+	e, err := encoding.DecodeString(jwk.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, fmt.Errorf("jwt: JWKS: key %q: invalid e", jwk.KeyID)
+	}
+	return NewRSAPublicKey(jwk.KeyID, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())})
+}
+
+// JWKS() - the public keys tokens may be verified with, secrets are never included
+func (s *KeySet) JWKS() []JWK {
+	keys := []JWK{}
+	for _, k := range s.keys {
+		if k.Algorithm != EdDSA {
+			continue
+		}
+		keys = append(keys, JWK{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         encoding.EncodeToString(k.public),
+			KeyID:     k.ID,
+			Algorithm: EdDSA,
+			Use:       "sig",
+		})
+	}
+	//the signing key first, then by id so the document is stable
+	signingID := ""
+	if s.signing != nil {
+		signingID = s.signing.ID
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if (keys[i].KeyID == signingID) != (keys[j].KeyID == signingID) {
+			return keys[i].KeyID == signingID
+		}
+		return keys[i].KeyID < keys[j].KeyID
+	})
+	return keys
+}
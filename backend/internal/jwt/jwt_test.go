@@ -0,0 +1,319 @@
+// BIOAFF/backend/internal/jwt/jwt_test.go
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEd25519Key() - a fresh signing key for a test
+func newEd25519Key(t *testing.T, id string) *Key {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEd25519Key(id, private)
+}
+
+// claims() - claims for a token valid for an hour from now
+func claims(now time.Time) Claims {
+	return Claims{
+		Subject:   "42",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		Kind:      "admin",
+		Email:     "staff@example.com",
+		Activated: true,
+		SessionID: 7,
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	//RFC 8037 appendix A.4 and RFC 7515 appendix A.1
+	tests := []struct {
+		name      string
+		key       func() *Key
+		input     string
+		signature string
+	}{
+		{
+			name: "EdDSA",
+			key: func() *Key {
+				seed, _ := encoding.DecodeString("nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+				return NewEd25519Key("a", ed25519.NewKeyFromSeed(seed))
+			},
+			input:     "eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc",
+			signature: "hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg",
+		},
+		{
+			name: "HS256",
+			key: func() *Key {
+				secret, _ := encoding.DecodeString("AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow")
+				k, _ := NewHMACKey("a", secret)
+				return k
+			},
+			input:     "eyJ0eXAiOiJKV1QiLA0KICJhbGciOiJIUzI1NiJ9.eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ",
+			signature: "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+		},
+	}
+
+	for _, tt := range tests {
+		k := tt.key()
+		signature, err := k.sign([]byte(tt.input))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := encoding.EncodeToString(signature); got != tt.signature {
+			t.Errorf("%s: got signature %s, want %s", tt.name, got, tt.signature)
+		}
+		if !k.verify([]byte(tt.input), signature) {
+			t.Errorf("%s: the signature doesn't verify", tt.name)
+		}
+		if k.verify([]byte(tt.input+"x"), signature) {
+			t.Errorf("%s: the signature verifies another input", tt.name)
+		}
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	now := time.Now()
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	hmacKey, err := NewHMACKey("shared", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []*Key{newEd25519Key(t, "ed"), hmacKey} {
+		s, err := NewKeySet("bioaff", k.ID, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := s.Sign(claims(now))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !LooksLikeJWT(token) {
+			t.Errorf("%s: %q doesn't look like a JWT", k.Algorithm, token)
+		}
+
+		got, err := s.Verify(token, now)
+		if err != nil {
+			t.Fatalf("%s: %v", k.Algorithm, err)
+		}
+		want := claims(now)
+		want.Issuer = "bioaff"
+		if *got != want {
+			t.Errorf("%s: got claims %+v, want %+v", k.Algorithm, *got, want)
+		}
+
+		if _, err := s.Verify(token, now.Add(time.Hour)); !errors.Is(err, ErrExpired) {
+			t.Errorf("%s: got %v at expiry, want ErrExpired", k.Algorithm, err)
+		}
+
+		//a changed claim breaks the signature
+		parts := strings.Split(token, ".")
+		body, _ := encoding.DecodeString(parts[1])
+		body = []byte(strings.Replace(string(body), `"admin"`, `"public"`, 1))
+		if _, err := s.Verify(parts[0]+"."+encoding.EncodeToString(body)+"."+parts[2], now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v for a tampered token, want ErrInvalidToken", k.Algorithm, err)
+		}
+
+		//tokens from another issuer are refused even when signed with the same key
+		other, _ := NewKeySet("someone-else", k.ID, k)
+		if _, err := other.Verify(token, now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v from another issuer, want ErrInvalidToken", k.Algorithm, err)
+		}
+	}
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	now := time.Now()
+	ed := newEd25519Key(t, "ed")
+	s, err := NewKeySet("bioaff", "ed", ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//an HMAC over the token keyed with the published public key mustn't pass for the Ed25519 key
+	public := []byte(ed.public)
+	public = append(public, public...)
+	hmacKey, _ := NewHMACKey("ed", public)
+	forger, _ := NewKeySet("bioaff", "ed", hmacKey)
+	token, err := forger.Sign(claims(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(token, now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v for an HS256 token, want ErrInvalidToken", err)
+	}
+
+	//unsigned tokens are refused
+	h := encoding.EncodeToString([]byte(`{"alg":"none","kid":"ed"}`))
+	c := encoding.EncodeToString([]byte(`{"iss":"bioaff","sub":"42","exp":9999999999}`))
+	if _, err := s.Verify(h+"."+c+".", now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v for an unsigned token, want ErrInvalidToken", err)
+	}
+
+	other, _ := NewKeySet("bioaff", "other", newEd25519Key(t, "other"))
+	token, _ = other.Sign(claims(now))
+	if _, err := s.Verify(token, now); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v for an unknown key, want ErrUnknownKey", err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	now := time.Now()
+	old := newEd25519Key(t, "2025")
+	oldSet, _ := NewKeySet("bioaff", "2025", old)
+	token, _ := oldSet.Sign(claims(now))
+
+	//the old key is kept public only after rotating, its tokens verify until they expire
+	rotated, err := NewKeySet("bioaff", "2026", newEd25519Key(t, "2026"), NewEd25519PublicKey("2025", old.public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Verify(token, now); err != nil {
+		t.Errorf("got %v for a token signed with the previous key", err)
+	}
+
+	if _, err := NewKeySet("bioaff", "2025", NewEd25519PublicKey("2025", old.public)); err == nil {
+		t.Error("signing with a public key was accepted")
+	}
+	if _, err := NewKeySet("bioaff", "nope", old); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v signing with a missing key, want ErrUnknownKey", err)
+	}
+
+	hmacKey, _ := NewHMACKey("shared", make([]byte, 32))
+	withSecret, _ := NewKeySet("bioaff", "2026", newEd25519Key(t, "2026"), NewEd25519PublicKey("2025", old.public), hmacKey)
+	jwks := withSecret.JWKS()
+	if len(jwks) != 2 || jwks[0].KeyID != "2026" || jwks[1].KeyID != "2025" {
+		t.Fatalf("got %+v, want the signing key then the previous one, without the secret", jwks)
+	}
+	if jwks[1].X != encoding.EncodeToString(old.public) || jwks[1].KeyType != "OKP" || jwks[1].Curve != "Ed25519" {
+		t.Errorf("got %+v, want the previous public key", jwks[1])
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	der, _ = x509.MarshalPKIXPublicKey(public)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	k, err := ParseKey("a", EdDSA, privatePEM)
+	if err != nil || !k.CanSign() {
+		t.Errorf("got %v parsing a private key", err)
+	}
+	k, err = ParseKey("a", EdDSA, publicPEM)
+	if err != nil || k.CanSign() {
+		t.Errorf("got %v parsing a public key, want one which can't sign", err)
+	}
+	secret := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	if _, err := ParseKey("a", HS256, []byte(secret+"\n")); err != nil {
+		t.Errorf("got %v parsing a secret", err)
+	}
+
+	bad := []struct {
+		name      string
+		algorithm string
+		material  []byte
+	}{
+		{"short secret", HS256, []byte(base64.StdEncoding.EncodeToString(make([]byte, 16)))},
+		{"secret not base64", HS256, []byte("not base64!")},
+		{"not PEM", EdDSA, []byte("nothing here")},
+		{"unknown algorithm", "RS256", privatePEM},
+	}
+	for _, tt := range bad {
+		if _, err := ParseKey("a", tt.algorithm, tt.material); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := newEd25519Key(t, "ed")
+	e := big.NewInt(int64(private.E)).Bytes()
+	document, _ := json.Marshal(map[string]interface{}{"keys": []JWK{
+		{KeyType: "RSA", KeyID: "rsa", N: encoding.EncodeToString(private.N.Bytes()), E: encoding.EncodeToString(e), Use: "sig"},
+		{KeyType: "OKP", Curve: "Ed25519", KeyID: "ed", X: encoding.EncodeToString(ed.public)},
+		{KeyType: "RSA", KeyID: "enc", N: encoding.EncodeToString(private.N.Bytes()), E: encoding.EncodeToString(e), Use: "enc"},
+		{KeyType: "EC", Curve: "P-256", KeyID: "ec"},
+	}})
+
+	keys, skipped, err := ParseJWKS(document)
+	if err != nil || len(skipped) != 0 {
+		t.Fatal(err, skipped)
+	}
+	if len(keys) != 2 || keys[0].Algorithm != RS256 || keys[1].Algorithm != EdDSA {
+		t.Fatalf("got %d keys, want the RSA and Ed25519 signature keys", len(keys))
+	}
+	s, err := NewKeySet("https://login.example.gov", "", keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SignPayload(map[string]string{"sub": "42"}); err == nil {
+		t.Error("a key set without a signing key signed")
+	}
+
+	//an RS256 token as a provider would sign it
+	h := encoding.EncodeToString([]byte(`{"alg":"RS256","kid":"rsa"}`))
+	c := encoding.EncodeToString([]byte(`{"iss":"https://login.example.gov","sub":"42"}`))
+	digest := sha256.Sum256([]byte(h + "." + c))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := s.VerifyPayload(h + "." + c + "." + encoding.EncodeToString(signature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != `{"iss":"https://login.example.gov","sub":"42"}` {
+		t.Errorf("got payload %s", payload)
+	}
+	if _, err := s.VerifyPayload(h + "." + encoding.EncodeToString([]byte(`{"sub":"1"}`)) + "." + encoding.EncodeToString(signature)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v for a tampered RS256 token, want ErrInvalidToken", err)
+	}
+
+	//unusable keys are skipped without losing the rest of the set
+	weak, _ := rsa.GenerateKey(rand.Reader, 1024)
+	document, _ = json.Marshal(map[string]interface{}{"keys": []JWK{
+		{KeyType: "RSA", KeyID: "weak", N: encoding.EncodeToString(weak.N.Bytes()), E: encoding.EncodeToString(e)},
+		{KeyType: "RSA", N: encoding.EncodeToString(private.N.Bytes()), E: encoding.EncodeToString(e)},
+		{KeyType: "OKP", Curve: "Ed25519", X: encoding.EncodeToString(ed.public)},
+		{KeyType: "OKP", Curve: "Ed25519", KeyID: "short", X: "c2hvcnQ"},
+	}})
+	keys, skipped, err = ParseJWKS(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Algorithm != RS256 || len(skipped) != 3 {
+		t.Errorf("got %d keys and skipped %v, want the 2048 bit RSA key kept and the rest skipped", len(keys), skipped)
+	}
+	if _, _, err := ParseJWKS([]byte("not json")); err == nil {
+		t.Error("a malformed document was accepted")
+	}
+}
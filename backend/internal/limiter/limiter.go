@@ -0,0 +1,60 @@
+// BIOAFF/backend/internal/limiter/limiter.go
+
+package limiter
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket, refilled at Rate tokens per second and holding up to Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result describes the outcome of taking a token from a bucket
+type Result struct {
+	Allowed    bool
+	Limit      int           //size of the bucket
+	Remaining  int           //tokens left after this request
+	Reset      time.Duration //time until the bucket is full again
+	RetryAfter time.Duration //time until the next token is available, zero when allowed
+}
+
+// Store is implemented by anything that can keep token buckets for the rate limiter
+type Store interface {
+	//Allow() takes a token from the bucket identified by key
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	//Cleanup() forgets the buckets which have been full for the idle duration, a full bucket is no different
+	//from a new one so slow limits aren't reset early
+	Cleanup(ctx context.Context, idle time.Duration) error
+}
+
+// take() - refills a bucket holding tokens for the elapsed time and tries to take one token from it
+// returns the tokens left in the bucket along with the result
+func take(tokens float64, elapsed time.Duration, limit Limit) (float64, Result) {
+	//refill the bucket, it can never hold more than the burst
+	tokens = math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+
+	result := Result{Limit: limit.Burst}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - tokens) / limit.Rate)
+	}
+
+	result.Remaining = int(tokens)
+	result.Reset = secondsToDuration((float64(limit.Burst) - tokens) / limit.Rate)
+	return tokens, result
+}
+
+// secondsToDuration() - converts fractional seconds, guarding against a zero rate
+func secondsToDuration(seconds float64) time.Duration {
+	if math.IsInf(seconds, 0) || math.IsNaN(seconds) || seconds > math.MaxInt64/float64(time.Second) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
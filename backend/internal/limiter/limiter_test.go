@@ -0,0 +1,132 @@
+// BIOAFF/backend/internal/limiter/limiter_test.go
+
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+func TestMain(m *testing.M) {
+	testdb.Main(m)
+}
+
+func TestTake(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 4}
+
+	tests := []struct {
+		name          string
+		tokens        float64
+		elapsed       time.Duration
+		wantTokens    float64
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+	}{
+		{"full bucket", 4, 0, 3, true, 3, 0},
+		{"last token", 1, 0, 0, true, 0, 0},
+		{"empty bucket", 0, 0, 0, false, 0, 500 * time.Millisecond},
+		{"refilled", 0, time.Second, 1, true, 1, 0},
+		{"never over the burst", 4, time.Hour, 3, true, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, result := take(tt.tokens, tt.elapsed, limit)
+			if tokens != tt.wantTokens {
+				t.Errorf("got %g tokens, want %g", tokens, tt.wantTokens)
+			}
+			if result.Allowed != tt.wantAllowed || result.Remaining != tt.wantRemaining || result.RetryAfter != tt.wantRetry {
+				t.Errorf("got %+v, want allowed %t remaining %d retry after %s", result, tt.wantAllowed, tt.wantRemaining, tt.wantRetry)
+			}
+			if result.Limit != limit.Burst {
+				t.Errorf("got limit %d, want %d", result.Limit, limit.Burst)
+			}
+		})
+	}
+}
+
+// exhaust() - takes every token of a fresh bucket and then one more, which must be refused
+func exhaust(t *testing.T, store Store, key string, limit Limit) {
+	t.Helper()
+	ctx := context.Background()
+
+	for i := 0; i < limit.Burst; i++ {
+		result, err := store.Allow(ctx, key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+	}
+	result, err := store.Allow(ctx, key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.RetryAfter <= 0 {
+		t.Fatalf("got %+v after the burst, want refused with a retry after", result)
+	}
+}
+
+// refill() - uses a bucket for ip:192.0.2.3 which refills straight away
+func refill(t *testing.T, store Store) {
+	t.Helper()
+
+	_, err := store.Allow(context.Background(), "ip:192.0.2.3", Limit{Rate: 1000, Burst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Rate: 0.1, Burst: 3}
+
+	exhaust(t, store, "ip:192.0.2.1", limit)
+
+	//another key has a bucket of its own
+	result, err := store.Allow(context.Background(), "ip:192.0.2.2", limit)
+	if err != nil || !result.Allowed {
+		t.Fatalf("got %+v, %v for another key, want allowed", result, err)
+	}
+
+	//only a bucket which has refilled is forgotten, the others would start over full
+	refill(t, store)
+	err = store.Cleanup(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.buckets) != 2 || store.buckets["ip:192.0.2.3"] != nil {
+		t.Errorf("got %d buckets after cleanup, want the 2 which haven't refilled", len(store.buckets))
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	store := NewPostgresStore(testdb.Open(t))
+	limit := Limit{Rate: 0.1, Burst: 3}
+
+	exhaust(t, store, "ip:192.0.2.1", limit)
+
+	result, err := store.Allow(context.Background(), "ip:192.0.2.2", limit)
+	if err != nil || !result.Allowed {
+		t.Fatalf("got %+v, %v for another key, want allowed", result, err)
+	}
+
+	refill(t, store)
+	err = store.Cleanup(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	err = store.DB.QueryRow(`SELECT count(*) FROM rate_limits`).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d buckets after cleanup, want the 2 which haven't refilled", n)
+	}
+}
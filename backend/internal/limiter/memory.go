@@ -0,0 +1,63 @@
+// BIOAFF/backend/internal/limiter/memory.go
+
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// bucket - a single token bucket kept in memory
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+	fullAt   time.Time //when the bucket has refilled, from then on it is no different from a new one
+}
+
+// MemoryStore keeps the token buckets in the memory of the current process
+// limits are not shared with other replicas and start over on a restart
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewMemoryStore() - creates a new, empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow() - takes a token from the bucket identified by key, creating a full bucket on first use
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(limit.Burst), lastSeen: now}
+		s.buckets[key] = b
+	}
+
+	var result Result
+	b.tokens, result = take(b.tokens, now.Sub(b.lastSeen), limit)
+	b.lastSeen = now
+	b.fullAt = now.Add(result.Reset)
+	return result, nil
+}
+
+// Cleanup() - removes the buckets which have been full for the idle duration
+func (s *MemoryStore) Cleanup(ctx context.Context, idle time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, b := range s.buckets {
+		if time.Since(b.fullAt) > idle {
+			delete(s.buckets, key)
+		}
+	}
+	return nil
+}
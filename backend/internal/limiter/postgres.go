@@ -0,0 +1,87 @@
+// BIOAFF/backend/internal/limiter/postgres.go
+
+package limiter
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore keeps the token buckets in the rate_limits table
+// every replica using the same database shares the same limits
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+// NewPostgresStore() - creates a store backed by the given connection pool
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+// Allow() - takes a token from the bucket identified by key
+// the row is locked for the duration of the transaction so concurrent requests queue up behind each other
+func (s *PostgresStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	//create a full bucket the first time the key is seen
+	query := `
+		INSERT INTO rate_limits (key, tokens)
+		VALUES ($1, $2)
+		ON CONFLICT (key) DO NOTHING`
+	_, err = tx.ExecContext(ctx, query, key, limit.Burst)
+	if err != nil {
+		return Result{}, err
+	}
+
+	//the database clock is used so replicas with skewed clocks agree on the elapsed time
+	var (
+		tokens  float64
+		elapsed float64
+	)
+	query = `
+		SELECT tokens, GREATEST(EXTRACT(EPOCH FROM (NOW() - updated_at)), 0)
+		FROM rate_limits
+		WHERE key = $1
+		FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, key).Scan(&tokens, &elapsed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	tokens, result := take(tokens, secondsToDuration(elapsed), limit)
+
+	query = `
+		UPDATE rate_limits
+		SET tokens = $2, updated_at = NOW(), full_at = NOW() + make_interval(secs => $3)
+		WHERE key = $1`
+	_, err = tx.ExecContext(ctx, query, key, tokens, result.Reset.Seconds())
+	if err != nil {
+		return Result{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Result{}, err
+	}
+	return result, nil
+}
+
+// Cleanup() - deletes the buckets which have been full for the idle duration
+func (s *PostgresStore) Cleanup(ctx context.Context, idle time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		DELETE FROM rate_limits
+		WHERE full_at < NOW() - make_interval(secs => $1)`
+	_, err := s.DB.ExecContext(ctx, query, idle.Seconds())
+	return err
+}
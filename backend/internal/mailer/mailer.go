@@ -0,0 +1,95 @@
+// BIOAFF/backend/internal/mailer/mailer.go
+
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// the email templates are embedded into the binary
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Languages - the languages the templates are written in, the first one is used when a translation is missing
+var Languages = []string{"en", "es"}
+
+// Mailer sends templated emails, the api uses an smtp mailer and tests swap in a fake one
+type Mailer interface {
+	Send(recipient, templateFile string, data interface{}) error
+}
+
+// SMTPMailer sends email through an smtp server
+type SMTPMailer struct {
+	dialer *mail.Dialer
+	sender string
+}
+
+// New() - creates a mailer for the given smtp server, sending as sender
+func New(host string, port int, username, password, sender string) *SMTPMailer {
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	return &SMTPMailer{
+		dialer: dialer,
+		sender: sender,
+	}
+}
+
+// Send() - renders the subject, plainBody and htmlBody templates of templateFile and sends them to recipient
+func (m *SMTPMailer) Send(recipient, templateFile string, data interface{}) error {
+	subject, plainBody, htmlBody, err := Render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	msg := mail.NewMessage()
+	msg.SetHeader("To", recipient)
+	msg.SetHeader("From", m.sender)
+	msg.SetHeader("Subject", subject)
+	msg.SetBody("text/plain", plainBody)
+	msg.AddAlternative("text/html", htmlBody)
+
+	//the smtp server may drop the odd connection, so try a few times before giving up
+	for i := 1; i <= 3; i++ {
+		err = m.dialer.DialAndSend(msg)
+		if err == nil {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return err
+}
+
+// Render() - executes the subject, plainBody and htmlBody templates of templateFile
+func Render(templateFile string, data interface{}) (string, string, string, error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var parts [3]bytes.Buffer
+	for i, name := range []string{"subject", "plainBody", "htmlBody"} {
+		err = tmpl.ExecuteTemplate(&parts[i], name, data)
+		if err != nil {
+			return "", "", "", err
+		}
+	}
+	return parts[0].String(), parts[1].String(), parts[2].String(), nil
+}
+
+// TemplateFor() - the translation of a template, e.g. form_verified.es.tmpl, falling back to the first language
+func TemplateFor(name, language string) string {
+	file := fmt.Sprintf("%s.%s.tmpl", name, language)
+	if _, err := fs.Stat(templateFS, "templates/"+file); err == nil {
+		return file
+	}
+	return fmt.Sprintf("%s.%s.tmpl", name, Languages[0])
+}
@@ -0,0 +1,73 @@
+// BIOAFF/backend/internal/mailer/mailer_test.go
+package mailer
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	files, err := fs.Glob(templateFS, "templates/*.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no templates embedded")
+	}
+
+	data := map[string]interface{}{
+		"name":               "Ana Pérez",
+		"formNumber":         343434,
+		"reason":             "the passport number is missing",
+		"passwordResetToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+		"minutes":            45,
+	}
+	for _, file := range files {
+		file = strings.TrimPrefix(file, "templates/")
+		t.Run(file, func(t *testing.T) {
+			subject, plain, html, err := Render(file, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject == "" || strings.Contains(subject, "\n") || !strings.Contains(html, "<html>") {
+				t.Errorf("got subject %q and an html body without html", subject)
+			}
+			if strings.HasPrefix(file, "form_") && (!strings.Contains(subject, "343434") || !strings.Contains(plain, "Ana Pérez")) {
+				t.Errorf("form email is missing the form number or name")
+			}
+			if strings.HasPrefix(file, "form_returned") && !strings.Contains(plain, "passport number is missing") {
+				t.Error("returned email is missing the reason")
+			}
+			if strings.HasPrefix(file, "password_reset") && (!strings.Contains(plain, "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU") || !strings.Contains(html, "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU")) {
+				t.Error("password reset email is missing the token")
+			}
+		})
+	}
+}
+
+func TestTemplateFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		want     string
+	}{
+		{"form_verified", "en", "form_verified.en.tmpl"},
+		{"form_returned", "es", "form_returned.es.tmpl"},
+		{"form_returned", "fr", "form_returned.en.tmpl"},
+	}
+	for _, tt := range tests {
+		if got := TemplateFor(tt.name, tt.language); got != tt.want {
+			t.Errorf("TemplateFor(%q, %q) = %q, want %q", tt.name, tt.language, got, tt.want)
+		}
+	}
+
+	//every template has a translation in every language
+	for _, name := range []string{"form_verified", "form_returned", "password_reset", "password_changed", "account_locked"} {
+		for _, language := range Languages {
+			if _, err := fs.Stat(templateFS, "templates/"+name+"."+language+".tmpl"); err != nil {
+				t.Errorf("%s has no %s translation", name, language)
+			}
+		}
+	}
+}
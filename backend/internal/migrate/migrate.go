@@ -0,0 +1,326 @@
+// BIOAFF/backend/internal/migrate/migrate.go
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrDirty          = errors.New("the schema is dirty, fix it by hand and use force to set the version")
+	ErrUnknownVersion = errors.New("the database is at a version that has no migration files")
+)
+
+// migration file names follow golang-migrate, e.g. 000001_create_admin_table.up.sql
+var fileRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a single numbered change to the schema
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load() - reads every migration in the root of fsys, ordered by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		matches := fileRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		}
+		if m.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, matches[2])
+		}
+		if matches[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrator applies migrations to a database, recording the version in a golang-migrate compatible table
+type Migrator struct {
+	db         *sql.DB
+	table      string
+	migrations []Migration
+}
+
+// New() - creates a migrator for the migrations in fsys, versions are recorded in the given table
+func New(db *sql.DB, fsys fs.FS, table string) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		table:      table,
+		migrations: migrations,
+	}, nil
+}
+
+// Latest() - the version of the newest migration, zero when there are none
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status describes where the database is compared to the migrations
+type Status struct {
+	Version int64 //zero when no migration has been applied
+	Dirty   bool
+	Applied []Migration
+	Pending []Migration
+}
+
+// Status() - reports the current version along with the applied and pending migrations
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	var status Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		status.Version, status.Dirty, err = m.version(ctx, conn)
+		return err
+	})
+	if err != nil {
+		return status, err
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version <= status.Version {
+			status.Applied = append(status.Applied, migration)
+		} else {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+	return status, nil
+}
+
+// Up() - applies the next n pending migrations, or all of them when n is zero or less
+func (m *Migrator) Up(ctx context.Context, n int) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := m.version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version <= current {
+				continue
+			}
+			if n > 0 && len(applied) == n {
+				break
+			}
+
+			err := m.apply(ctx, conn, migration.Up, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down() - rolls back the last n applied migrations
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := m.version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+
+		for len(reverted) < n && current > 0 {
+			i := m.index(current)
+			if i < 0 {
+				return ErrUnknownVersion
+			}
+			migration := m.migrations[i]
+
+			//the version drops to the migration before this one, or to nothing at all
+			var previous int64
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+
+			err := m.apply(ctx, conn, migration.Down, previous)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration)
+			current = previous
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Force() - records the version as clean without running anything, zero removes the version
+// used to recover after a migration failed half way and the schema was fixed by hand
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	if version != 0 && m.index(version) < 0 {
+		return fmt.Errorf("there is no migration with version %d", version)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		err = m.setVersion(ctx, tx, version, false)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// apply() - runs a migration and records the new version in the same transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, query string, version int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if query != "" {
+		_, err = tx.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = m.setVersion(ctx, tx, version, false)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// withLock() - runs fn on a single connection holding an advisory lock,
+// so instances starting at the same time don't migrate on top of each other
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	lockID := int64(crc32.ChecksumIEEE([]byte(m.table)))
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	//the lock belongs to the session, release it even if the context is already done
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)`, m.quotedTable())
+	_, err = conn.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// version() - the recorded version, zero when there isn't one
+func (m *Migrator) version(ctx context.Context, conn *sql.Conn) (int64, bool, error) {
+	var (
+		version int64
+		dirty   bool
+	)
+	query := fmt.Sprintf(`SELECT version, dirty FROM %s LIMIT 1`, m.quotedTable())
+	err := conn.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// setVersion() - replaces the recorded version, the table only ever holds one row
+func (m *Migrator) setVersion(ctx context.Context, tx *sql.Tx, version int64, dirty bool) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s`, m.quotedTable()))
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (version, dirty) VALUES ($1, $2)`, m.quotedTable())
+	_, err = tx.ExecContext(ctx, query, version, dirty)
+	return err
+}
+
+// index() - the position of a version in the migrations, -1 when there is no such migration
+func (m *Migrator) index(version int64) int {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// quotedTable() - the version table as a quoted identifier
+func (m *Migrator) quotedTable() string {
+	return `"` + m.table + `"`
+}
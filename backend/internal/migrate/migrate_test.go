@@ -0,0 +1,193 @@
+// BIOAFF/backend/internal/migrate/migrate_test.go
+
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jinzhu/gorm/backend/internal/migrate"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+)
+
+func TestMain(m *testing.M) {
+	testdb.Main(m)
+}
+
+// testMigrations - two small migrations, the second one can be told to fail
+func testMigrations(failSecond bool) fstest.MapFS {
+	second := `CREATE TABLE second (id int)`
+	if failSecond {
+		second = `CREATE TABLE second (id int); SELECT * FROM no_such_table`
+	}
+	return fstest.MapFS{
+		"000001_first.up.sql":    {Data: []byte(`CREATE TABLE first (id int)`)},
+		"000001_first.down.sql":  {Data: []byte(`DROP TABLE first`)},
+		"000003_second.up.sql":   {Data: []byte(second)},
+		"000003_second.down.sql": {Data: []byte(`DROP TABLE second`)},
+		"readme.txt":             {Data: []byte(`not a migration`)},
+	}
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := migrate.Load(testMigrations(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Version != 3 {
+		t.Fatalf("got %+v, want versions 1 and 3", migrations)
+	}
+	if migrations[1].Name != "second" || migrations[1].Down != `DROP TABLE second` {
+		t.Errorf("got %+v for version 3", migrations[1])
+	}
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"missing up", fstest.MapFS{"000001_first.down.sql": {}}},
+		{"two names", fstest.MapFS{"000001_first.up.sql": {}, "000001_other.down.sql": {}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := migrate.Load(tt.fsys)
+			if err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	db := testdb.Empty(t)
+	ctx := context.Background()
+
+	m, err := migrate.New(db, testMigrations(false), "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Latest() != 3 {
+		t.Errorf("got latest %d, want 3", m.Latest())
+	}
+
+	applied, err := m.Up(ctx, 1)
+	if err != nil || len(applied) != 1 {
+		t.Fatalf("got %d applied, %v, want 1", len(applied), err)
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != 1 || len(status.Applied) != 1 || len(status.Pending) != 1 {
+		t.Errorf("got %+v, want version 1 with one pending", status)
+	}
+
+	applied, err = m.Up(ctx, 0)
+	if err != nil || len(applied) != 1 || applied[0].Version != 3 {
+		t.Fatalf("got %+v, %v, want version 3 applied", applied, err)
+	}
+
+	//down drops back to the previous migration, not to version - 1
+	reverted, err := m.Down(ctx, 1)
+	if err != nil || len(reverted) != 1 {
+		t.Fatalf("got %d reverted, %v, want 1", len(reverted), err)
+	}
+	status, err = m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != 1 {
+		t.Errorf("got version %d, want 1", status.Version)
+	}
+
+	reverted, err = m.Down(ctx, 5)
+	if err != nil || len(reverted) != 1 {
+		t.Fatalf("got %d reverted, %v, want 1", len(reverted), err)
+	}
+	var tables int
+	err = db.QueryRow(`SELECT count(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'`).Scan(&tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("got %d tables, want 0", tables)
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	db := testdb.Empty(t)
+	ctx := context.Background()
+
+	m, err := migrate.New(db, testMigrations(true), "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := m.Up(ctx, 0)
+	if err == nil {
+		t.Fatal("got no error from a failing migration")
+	}
+	if len(applied) != 1 {
+		t.Errorf("got %d applied, want 1", len(applied))
+	}
+
+	//the failed migration ran in a transaction, so neither its table nor its version are left behind
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != 1 || status.Dirty {
+		t.Errorf("got version %d dirty %t, want clean version 1", status.Version, status.Dirty)
+	}
+	var exists bool
+	err = db.QueryRow(`SELECT to_regclass('second') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("the failed migration's table was left behind")
+	}
+}
+
+func TestForce(t *testing.T) {
+	db := testdb.Empty(t)
+	ctx := context.Background()
+
+	m, err := migrate.New(db, testMigrations(false), "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Force(ctx, 2)
+	if err == nil {
+		t.Error("got no error forcing a version without a migration")
+	}
+
+	//a dirty version left by golang-migrate blocks every migration until it is forced
+	_, err = m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO schema_migrations (version, dirty) VALUES (1, true)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Up(ctx, 0)
+	if !errors.Is(err, migrate.ErrDirty) {
+		t.Fatalf("got %v, want ErrDirty", err)
+	}
+
+	err = m.Force(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != 1 || status.Dirty {
+		t.Errorf("got version %d dirty %t, want clean version 1", status.Version, status.Dirty)
+	}
+}
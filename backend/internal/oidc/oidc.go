@@ -0,0 +1,390 @@
+// BIOAFF/backend/internal/oidc/oidc.go
+
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+	"github.com/jinzhu/gorm/backend/internal/jwt"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+	ErrExpiredIDToken = errors.New("oidc: the ID token has expired")
+)
+
+// leeway - how far the provider's clock may be off from ours
+const leeway = time.Minute
+
+// jwksRefreshInterval - the provider's keys are fetched again for an unknown key id at most this often,
+// so tokens with made up key ids can't have us hammer the provider
+const jwksRefreshInterval = time.Minute
+
+// maxResponseSize - the most we read of a response from the provider
+const maxResponseSize = 1 << 20
+
+// encoding - PKCE verifiers and challenges are unpadded base64url
+var encoding = base64.RawURLEncoding
+
+// Config is what the provider knows the api by
+type Config struct {
+	Issuer       string //the provider's issuer URL, its metadata is discovered from it
+	ClientID     string
+	ClientSecret string //sent with HTTP basic auth, public clients have none
+	RedirectURL  string //where the provider sends the browser back to with the code
+	Scopes       []string
+	Logger       *jsonlog.Logger //warned about provider keys which can't be used, may be nil
+}
+
+// metadata is the part of the provider's discovery document the api uses
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider logs users in with an OpenID Connect provider using the authorization code flow with PKCE,
+// its metadata and keys are fetched the first time they are needed
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *metadata
+	keys        *jwt.KeySet
+	keysFetched time.Time
+}
+
+// New() - a provider reached with client, nothing is fetched until it is used
+func New(config Config, client *http.Client) *Provider {
+	return &Provider{config: config, client: client}
+}
+
+// IDToken holds the claims of a verified ID token
+type IDToken struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Claims        map[string]interface{} //every claim, for the ones the provider is configured to add
+}
+
+// Strings() - the values of a claim holding a string or a list of strings, such as groups or roles
+func (t *IDToken) Strings(claim string) []string {
+	switch v := t.Claims[claim].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// RandomString() - an unguessable value for a state, nonce or PKCE verifier, 32 random bytes make 43 characters
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Challenge() - the S256 PKCE challenge of a verifier (RFC 7636)
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return encoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL() - where to send the browser to log in, state comes back with the code and nonce inside
+// the ID token, while only the challenge of the PKCE verifier leaves the api
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(md.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", strings.Join(p.config.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", Challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange() - trades the code the browser came back with for the ID token, which still has to be verified
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {p.config.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		//RFC 6749 section 2.3.1, both are form encoded before going into the header
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.do(req, &body)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("oidc: token endpoint: %d %s %s", status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc: token endpoint: no ID token in the response")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken() - checks the ID token's signature and claims (OpenID Connect Core 3.1.3.7),
+// nonce must be the one sent with the login
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string, now time.Time) (*IDToken, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := p.verifySignature(ctx, md, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims struct {
+		Issuer        string      `json:"iss"`
+		Subject       string      `json:"sub"`
+		Audience      audience    `json:"aud"`
+		AuthorizedBy  string      `json:"azp"`
+		ExpiresAt     int64       `json:"exp"`
+		IssuedAt      int64       `json:"iat"`
+		Nonce         string      `json:"nonce"`
+		Email         string      `json:"email"`
+		EmailVerified interface{} `json:"email_verified"`
+		Name          string      `json:"name"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	switch {
+	case claims.Issuer != md.Issuer, claims.Subject == "", claims.Nonce != nonce, nonce == "":
+		return nil, ErrInvalidIDToken
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, ErrInvalidIDToken
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != p.config.ClientID:
+		return nil, ErrInvalidIDToken
+	case claims.IssuedAt == 0 || time.Unix(claims.IssuedAt, 0).After(now.Add(leeway)):
+		return nil, ErrInvalidIDToken
+	case claims.ExpiresAt == 0 || !now.Before(time.Unix(claims.ExpiresAt, 0).Add(leeway)):
+		return nil, ErrExpiredIDToken
+	}
+
+	token := &IDToken{
+		Subject: claims.Subject,
+		Email:   claims.Email,
+		Name:    claims.Name,
+	}
+	//some providers send the boolean as a string
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		token.EmailVerified = v
+	case string:
+		token.EmailVerified = v == "true"
+	}
+	err = json.Unmarshal(payload, &token.Claims)
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	return token, nil
+}
+
+// audience - the aud claim, which is a string or a list of strings
+type audience []string
+
+// UnmarshalJSON() - reads either form of the claim
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(b, &list)
+	if err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// contains() - reports if the client is one of the audiences
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySignature() - checks the token against the provider's keys, fetching them again when it was signed
+// with a key we haven't seen, as the provider may have rotated its keys
+func (p *Provider) verifySignature(ctx context.Context, md *metadata, rawIDToken string) ([]byte, error) {
+	keys, err := p.jwks(ctx, md, false)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := keys.VerifyPayload(rawIDToken)
+	if errors.Is(err, jwt.ErrUnknownKey) {
+		keys, err = p.jwks(ctx, md, true)
+		if err != nil {
+			return nil, err
+		}
+		payload, err = keys.VerifyPayload(rawIDToken)
+	}
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	return payload, nil
+}
+
+// jwks() - the provider's keys, fetched again when refresh is set unless they were fetched very recently
+func (p *Provider) jwks(ctx context.Context, md *metadata, refresh bool) (*jwt.KeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil && (!refresh || time.Since(p.keysFetched) < jwksRefreshInterval) {
+		return p.keys, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, md.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var document json.RawMessage
+	status, err := p.do(req, &document)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: jwks: unexpected status %d", status)
+	}
+	keys, skipped, err := jwt.ParseJWKS(document)
+	if err != nil {
+		return nil, err
+	}
+	//the provider may publish a key we can't use next to the one it signs with
+	if len(skipped) > 0 && p.config.Logger != nil {
+		p.config.Logger.PrintWarn("skipped unusable provider keys", map[string]interface{}{"issuer": md.Issuer, "error": errors.Join(skipped...).Error()})
+	}
+	set, err := jwt.NewKeySet(md.Issuer, "", keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys, p.keysFetched = set, time.Now()
+	return set, nil
+}
+
+// discover() - the provider's metadata, fetched once from its well-known discovery document
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+	var md metadata
+	status, err := p.do(req, &md)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: discovery: unexpected status %d", status)
+	}
+
+	//a document claiming another issuer could be trying to pass off its tokens as the configured provider's
+	if md.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q doesn't match %q", md.Issuer, p.config.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: the document is missing an endpoint")
+	}
+
+	p.metadata = &md
+	return p.metadata, nil
+}
+
+// do() - sends a request to the provider and decodes its JSON response, whatever the status
+func (p *Provider) do(req *http.Request, dst interface{}) (int, error) {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return 0, err
+	}
+	err = json.Unmarshal(body, dst)
+	if err != nil && res.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("oidc: %s: %w", req.URL.Path, err)
+	}
+	return res.StatusCode, nil
+}
@@ -0,0 +1,256 @@
+// BIOAFF/backend/internal/oidc/oidc_test.go
+
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/jsonlog"
+	"github.com/jinzhu/gorm/backend/internal/jwt"
+	"github.com/jinzhu/gorm/backend/internal/oidctest"
+)
+
+// newTestProvider() - a provider for the mock, with a user ready to log in
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Provider) {
+	t.Helper()
+
+	mock := oidctest.NewProvider(t, "bioaff", "s3cret")
+	mock.SetUser(map[string]interface{}{
+		"sub":            "u-123",
+		"email":          "staff@registry.gov",
+		"email_verified": true,
+		"name":           "Staff Member",
+		"groups":         []string{"bioaff-verifiers", "all-staff"},
+	})
+
+	p := New(Config{
+		Issuer:       mock.Issuer(),
+		ClientID:     "bioaff",
+		ClientSecret: "s3cret",
+		RedirectURL:  "https://bioaff.example.gov/sso/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+	}, mock.Client())
+	return p, mock
+}
+
+// login() - runs the flow up to the ID token, returning it unverified along with the nonce
+func login(t *testing.T, p *Provider, mock *oidctest.Provider) (string, string) {
+	t.Helper()
+
+	ctx := context.Background()
+	state, _ := RandomString()
+	nonce, _ := RandomString()
+	verifier, _ := RandomString()
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, returned := mock.Login(t, authURL)
+	if returned != state {
+		t.Fatalf("got state %q back, want %q", returned, state)
+	}
+
+	idToken, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idToken, nonce
+}
+
+func TestChallenge(t *testing.T) {
+	//RFC 7636 appendix B
+	got := Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("got challenge %s", got)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	p, mock := newTestProvider(t)
+	ctx := context.Background()
+
+	idToken, nonce := login(t, p, mock)
+	token, err := p.VerifyIDToken(ctx, idToken, nonce, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Subject != "u-123" || token.Email != "staff@registry.gov" || !token.EmailVerified || token.Name != "Staff Member" {
+		t.Errorf("got %+v", token)
+	}
+	if groups := token.Strings("groups"); len(groups) != 2 || groups[0] != "bioaff-verifiers" {
+		t.Errorf("got groups %v", groups)
+	}
+
+	//the nonce ties the token to the login it was asked for
+	if _, err := p.VerifyIDToken(ctx, idToken, "another-nonce", time.Now()); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("got %v for the wrong nonce, want ErrInvalidIDToken", err)
+	}
+	if _, err := p.VerifyIDToken(ctx, idToken, nonce, time.Now().Add(time.Hour)); !errors.Is(err, ErrExpiredIDToken) {
+		t.Errorf("got %v for an expired token, want ErrExpiredIDToken", err)
+	}
+
+	//the discovery document is only fetched once
+	login(t, p, mock)
+	if n := mock.Requests("/.well-known/openid-configuration"); n != 1 {
+		t.Errorf("got %d discovery requests, want 1", n)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	p, mock := newTestProvider(t)
+	ctx := context.Background()
+
+	state, _ := RandomString()
+	nonce, _ := RandomString()
+	verifier, _ := RandomString()
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	if q := u.Query(); q.Get("code_challenge") != Challenge(verifier) || q.Get("code_challenge_method") != "S256" || q.Get("code_verifier") != "" {
+		t.Errorf("got query %v, want the S256 challenge and never the verifier", q)
+	}
+
+	//a stolen code is no use without the verifier
+	code, _ := mock.Login(t, authURL)
+	other, _ := RandomString()
+	if _, err := p.Exchange(ctx, code, other); err == nil {
+		t.Error("exchanged a code with the wrong verifier")
+	}
+
+	//and codes are single use
+	code, _ = mock.Login(t, authURL)
+	if _, err := p.Exchange(ctx, code, verifier); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(ctx, code, verifier); err == nil {
+		t.Error("exchanged a code twice")
+	}
+
+	wrongSecret := New(Config{Issuer: mock.Issuer(), ClientID: "bioaff", ClientSecret: "guess", RedirectURL: p.config.RedirectURL, Scopes: []string{"openid"}}, mock.Client())
+	code, _ = mock.Login(t, authURL)
+	if _, err := wrongSecret.Exchange(ctx, code, verifier); err == nil {
+		t.Error("exchanged a code with the wrong client secret")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(claims map[string]interface{})
+		want   error
+	}{
+		{"another audience", func(c map[string]interface{}) { c["aud"] = "someone-else" }, ErrInvalidIDToken},
+		{"several audiences without azp", func(c map[string]interface{}) { c["aud"] = []string{"bioaff", "other"} }, ErrInvalidIDToken},
+		{"another issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, ErrInvalidIDToken},
+		{"no subject", func(c map[string]interface{}) { delete(c, "sub") }, ErrInvalidIDToken},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }, ErrExpiredIDToken},
+		{"issued in the future", func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() }, ErrInvalidIDToken},
+	}
+
+	for _, tt := range tests {
+		p, mock := newTestProvider(t)
+		mock.ModifyIDTokens(tt.modify)
+
+		idToken, nonce := login(t, p, mock)
+		_, err := p.VerifyIDToken(context.Background(), idToken, nonce, time.Now())
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	p, mock := newTestProvider(t)
+	mock.ModifyIDTokens(func(c map[string]interface{}) {
+		c["aud"] = []string{"bioaff", "other"}
+		c["azp"] = "bioaff"
+	})
+	idToken, nonce := login(t, p, mock)
+	if _, err := p.VerifyIDToken(context.Background(), idToken, nonce, time.Now()); err != nil {
+		t.Errorf("got %v with several audiences and azp, want it accepted", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	p, mock := newTestProvider(t)
+	ctx := context.Background()
+
+	idToken, nonce := login(t, p, mock)
+	if _, err := p.VerifyIDToken(ctx, idToken, nonce, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	//a token signed with a key we haven't seen makes us fetch the keys again
+	mock.RotateKey(t)
+	p.keysFetched = time.Now().Add(-2 * jwksRefreshInterval)
+	idToken, nonce = login(t, p, mock)
+	if _, err := p.VerifyIDToken(ctx, idToken, nonce, time.Now()); err != nil {
+		t.Fatalf("got %v after the provider rotated its key", err)
+	}
+	if n := mock.Requests("/jwks"); n != 2 {
+		t.Errorf("got %d key fetches, want 2", n)
+	}
+
+	//but not more than once every jwksRefreshInterval
+	mock.RotateKey(t)
+	idToken, nonce = login(t, p, mock)
+	if _, err := p.VerifyIDToken(ctx, idToken, nonce, time.Now()); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("got %v right after fetching the keys, want ErrInvalidIDToken", err)
+	}
+	if n := mock.Requests("/jwks"); n != 2 {
+		t.Errorf("got %d key fetches, want still 2", n)
+	}
+}
+
+func TestUnusableKeysSkipped(t *testing.T) {
+	p, mock := newTestProvider(t)
+	var logs bytes.Buffer
+	p.config.Logger = jsonlog.New(&logs, jsonlog.LevelInfo)
+
+	//a short RSA key and a second key without an id don't stop the signing key being used
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := big.NewInt(int64(weak.E)).Bytes()
+	mock.PublishKeys(
+		jwt.JWK{KeyType: "RSA", KeyID: "weak", N: base64.RawURLEncoding.EncodeToString(weak.N.Bytes()), E: base64.RawURLEncoding.EncodeToString(e)},
+		jwt.JWK{KeyType: "OKP", Curve: "Ed25519", X: base64.RawURLEncoding.EncodeToString(make([]byte, 32))},
+		jwt.JWK{KeyType: "OKP", Curve: "Ed25519", X: base64.RawURLEncoding.EncodeToString(make([]byte, 32))},
+	)
+
+	idToken, nonce := login(t, p, mock)
+	if _, err := p.VerifyIDToken(context.Background(), idToken, nonce, time.Now()); err != nil {
+		t.Fatalf("got %v with unusable keys in the set, want the token verified", err)
+	}
+	if !strings.Contains(logs.String(), "skipped unusable provider keys") || !strings.Contains(logs.String(), "weak") {
+		t.Errorf("the skipped keys weren't logged: %s", logs.String())
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	mock := oidctest.NewProvider(t, "bioaff", "")
+	p := New(Config{Issuer: mock.Issuer() + "/", ClientID: "bioaff", Scopes: []string{"openid"}}, mock.Client())
+
+	_, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err == nil {
+		t.Fatal("accepted a discovery document for another issuer")
+	}
+
+	//a failed discovery is tried again next time
+	p.config.Issuer = mock.Issuer()
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err != nil {
+		t.Errorf("got %v once the issuer matched", err)
+	}
+}
@@ -0,0 +1,315 @@
+// BIOAFF/backend/internal/oidctest/oidctest.go
+
+package oidctest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/backend/internal/jwt"
+)
+
+// authorization is a code waiting to be exchanged, with what was asked for when it was issued
+type authorization struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	claims      map[string]interface{}
+}
+
+// Provider is a mock OpenID provider, the user logging in is whoever the test last set
+type Provider struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	mu       sync.Mutex
+	keys     *jwt.KeySet
+	keyCount int
+	extra    []jwt.JWK //published next to the signing key
+	user     map[string]interface{}
+	codes    map[string]authorization
+	modify   func(claims map[string]interface{})
+	requests map[string]int
+}
+
+// NewProvider() - starts a provider for the client, closed when the test ends
+func NewProvider(t *testing.T, clientID, clientSecret string) *Provider {
+	t.Helper()
+
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		codes:        make(map[string]authorization),
+		requests:     make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.Server = httptest.NewServer(p.count(mux))
+	t.Cleanup(p.Close)
+
+	p.RotateKey(t)
+	return p
+}
+
+// Issuer() - the provider's issuer URL
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// SetUser() - the claims of the user who logs in next, such as sub, email and groups
+func (p *Provider) SetUser(claims map[string]interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.user = claims
+}
+
+// ModifyIDTokens() - changes the claims of every ID token issued from now on, to test how bad ones are handled
+func (p *Provider) ModifyIDTokens(modify func(claims map[string]interface{})) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.modify = modify
+}
+
+// RotateKey() - signs with a new key from now on, publishing only the new one
+func (p *Provider) RotateKey(t *testing.T) {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keyCount++
+	k := jwt.NewEd25519Key(fmt.Sprintf("key-%d", p.keyCount), private)
+	p.keys, err = jwt.NewKeySet(p.URL, k.ID, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// PublishKeys() - publishes the keys along with the signing key, such as ones a client can't use
+func (p *Provider) PublishKeys(keys ...jwt.JWK) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.extra = append(p.extra, keys...)
+}
+
+// Requests() - how many requests were made to the path
+func (p *Provider) Requests(path string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.requests[path]
+}
+
+// Login() - plays the browser and the user at the authorization endpoint, returning the code and state
+// the provider redirected back with
+func (p *Provider) Login(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("got status %d from the authorization endpoint, want 302", res.StatusCode)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+// count() - counts the requests to each path
+func (p *Provider) count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.requests[r.URL.Path]++
+		p.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// discovery() - the provider's metadata
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                           p.URL,
+		"authorization_endpoint":           p.URL + "/authorize",
+		"token_endpoint":                   p.URL + "/token",
+		"jwks_uri":                         p.URL + "/jwks",
+		"response_types_supported":         []string{"code"},
+		"code_challenge_methods_supported": []string{"S256"},
+		"id_token_signing_alg_values":      []string{jwt.EdDSA},
+	})
+}
+
+// authorize() - logs the current user straight in, redirecting back with a code
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch {
+	case q.Get("response_type") != "code":
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	case q.Get("client_id") != p.ClientID:
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	case q.Get("redirect_uri") == "" || q.Get("state") == "" || q.Get("nonce") == "":
+		http.Error(w, "missing redirect_uri, state or nonce", http.StatusBadRequest)
+		return
+	case q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	case !strings.Contains(" "+q.Get("scope")+" ", " openid "):
+		http.Error(w, "the openid scope is required", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	if p.user == nil {
+		p.mu.Unlock()
+		http.Error(w, "no user set", http.StatusInternalServerError)
+		return
+	}
+	claims := make(map[string]interface{}, len(p.user))
+	for k, v := range p.user {
+		claims[k] = v
+	}
+	p.codes[code] = authorization{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		claims:      claims,
+	}
+	p.mu.Unlock()
+
+	back, _ := url.Parse(q.Get("redirect_uri"))
+	params := back.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	back.RawQuery = params.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+// token() - exchanges a code for an ID token, checking the client, redirect and PKCE verifier
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if p.ClientSecret != "" {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+		if !ok || id != p.ClientID || secret != p.ClientSecret {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+			return
+		}
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	p.mu.Lock()
+	code := r.PostForm.Get("code")
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	keys, modify := p.keys, p.modify
+	p.mu.Unlock()
+
+	if !ok || auth.clientID != r.PostForm.Get("client_id") || auth.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := auth.claims
+	claims["iss"] = p.URL
+	claims["aud"] = p.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(5 * time.Minute).Unix()
+	claims["nonce"] = auth.nonce
+	if modify != nil {
+		modify(claims)
+	}
+
+	idToken, err := keys.SignPayload(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// jwks() - the provider's current public key, and any extra keys it publishes
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	keys := append(p.keys.JWKS(), p.extra...)
+	p.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// tokenError() - an error response of the token endpoint (RFC 6749 section 5.2)
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+// writeJSON() - writes v as the response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// randomString() - an unguessable code
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
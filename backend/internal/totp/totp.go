@@ -0,0 +1,129 @@
+// BIOAFF/backend/internal/totp/totp.go
+
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SecretSize - the length of generated secrets in bytes, the size of an HMAC-SHA1 key as RFC 4226 recommends
+const SecretSize = 20
+
+// encoding - secrets are shown to people and put in URIs as unpadded base32
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// the hash algorithms of RFC 6238, authenticator apps only reliably support SHA1
+const (
+	SHA1   = "SHA1"
+	SHA256 = "SHA256"
+	SHA512 = "SHA512"
+)
+
+// Config describes how codes are made and checked, both sides have to agree on everything but Skew
+type Config struct {
+	Algorithm string
+	Digits    int
+	Period    time.Duration
+	Skew      int //time steps either side of the current one which are also accepted
+}
+
+// Default - what authenticator apps expect, six digits every 30 seconds with a step of skew each way
+var Default = Config{Algorithm: SHA1, Digits: 6, Period: 30 * time.Second, Skew: 1}
+
+// GenerateSecret() - a new random secret
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeSecret() - the secret as people type it into authenticator apps
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// Step() - the time step t falls in, counted from the unix epoch
+func (c Config) Step(t time.Time) int64 {
+	return t.Unix() / int64(c.Period/time.Second)
+}
+
+// Code() - the code for a time step, the HOTP (RFC 4226) of the step as the counter
+func (c Config) Code(secret []byte, step int64) string {
+	mac := hmac.New(c.hash(), secret)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	//dynamic truncation, the low nibble of the last byte picks where the 31 bits are read from
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for i := 0; i < c.Digits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", c.Digits, value%modulus)
+}
+
+// Validate() - checks a code against the time steps around t, returning the step it matched so the
+// caller can refuse to accept the same step twice
+func (c Config) Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != c.Digits {
+		return 0, false
+	}
+
+	current := c.Step(t)
+	for step := current - int64(c.Skew); step <= current+int64(c.Skew); step++ {
+		if subtle.ConstantTimeCompare([]byte(c.Code(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// URI() - the otpauth URI authenticator apps read from a QR code, the account is usually an email
+func (c Config) URI(issuer, account string, secret []byte) string {
+	q := url.Values{}
+	q.Set("secret", EncodeSecret(secret))
+	q.Set("issuer", issuer)
+	q.Set("algorithm", c.Algorithm)
+	q.Set("digits", fmt.Sprint(c.Digits))
+	q.Set("period", fmt.Sprint(int64(c.Period/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+// hash() - the hash function of the algorithm, SHA1 unless it is one of the others
+func (c Config) hash() func() hash.Hash {
+	switch c.Algorithm {
+	case SHA256:
+		return sha256.New
+	case SHA512:
+		return sha512.New
+	default:
+		return sha1.New
+	}
+}
@@ -0,0 +1,112 @@
+// BIOAFF/backend/internal/totp/totp_test.go
+
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// the test vectors of RFC 6238 appendix B, eight digit codes every 30 seconds with a seed per algorithm
+func TestRFC6238(t *testing.T) {
+	seeds := map[string][]byte{
+		SHA1:   []byte("12345678901234567890"),
+		SHA256: []byte("12345678901234567890123456789012"),
+		SHA512: []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	tests := []struct {
+		unix      int64
+		algorithm string
+		want      string
+	}{
+		{59, SHA1, "94287082"},
+		{59, SHA256, "46119246"},
+		{59, SHA512, "90693936"},
+		{1111111109, SHA1, "07081804"},
+		{1111111109, SHA256, "68084774"},
+		{1111111109, SHA512, "25091201"},
+		{1111111111, SHA1, "14050471"},
+		{1111111111, SHA256, "67062674"},
+		{1111111111, SHA512, "99943326"},
+		{1234567890, SHA1, "89005924"},
+		{1234567890, SHA256, "91819424"},
+		{1234567890, SHA512, "93441116"},
+		{2000000000, SHA1, "69279037"},
+		{2000000000, SHA256, "90698825"},
+		{2000000000, SHA512, "38618901"},
+		{20000000000, SHA1, "65353130"},
+		{20000000000, SHA256, "77737706"},
+		{20000000000, SHA512, "47863826"},
+	}
+	for _, tt := range tests {
+		c := Config{Algorithm: tt.algorithm, Digits: 8, Period: 30 * time.Second}
+		at := time.Unix(tt.unix, 0)
+		if got := c.Code(seeds[tt.algorithm], c.Step(at)); got != tt.want {
+			t.Errorf("%s at %d: got %s, want %s", tt.algorithm, tt.unix, got, tt.want)
+		}
+		if _, ok := c.Validate(seeds[tt.algorithm], tt.want, at); !ok {
+			t.Errorf("%s at %d: %s didn't validate", tt.algorithm, tt.unix, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	c := Default
+	now := time.Unix(1111111111, 0)
+	code := c.Code(secret, c.Step(now))
+
+	//a step either side is accepted for clocks that drift, and the matching step returned
+	tests := []struct {
+		name string
+		at   time.Time
+		ok   bool
+	}{
+		{"same step", now, true},
+		{"a step later", now.Add(30 * time.Second), true},
+		{"a step earlier", now.Add(-30 * time.Second), true},
+		{"two steps later", now.Add(time.Minute), false},
+	}
+	for _, tt := range tests {
+		step, ok := c.Validate(secret, code, tt.at)
+		if ok != tt.ok {
+			t.Errorf("%s: got %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && step != c.Step(now) {
+			t.Errorf("%s: got step %d, want %d", tt.name, step, c.Step(now))
+		}
+	}
+
+	for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := c.Validate(secret, bad, now); ok {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	u, err := url.Parse(Default.URI("BioAff", "staff@example.com", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/BioAff:staff@example.com" {
+		t.Errorf("got %s, want an otpauth totp URI labelled with the issuer and account", u)
+	}
+	q := u.Query()
+	if q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || q.Get("issuer") != "BioAff" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("got query %v", q)
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if len(a) != SecretSize || string(a) == string(b) {
+		t.Errorf("got secrets %x and %x, want two different %d byte secrets", a, b, SecretSize)
+	}
+}
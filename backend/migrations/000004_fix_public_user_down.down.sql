@@ -0,0 +1,2 @@
+-- 000003's down file drops public_users, which never existed, so the public_user table is dropped here
+DROP TABLE IF EXISTS public_user;
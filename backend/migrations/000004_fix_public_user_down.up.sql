@@ -0,0 +1,6 @@
+-- 000003 already created public_user, this only brings it back when migrating up again past this point
+CREATE TABLE IF NOT EXISTS public_user (
+    id serial PRIMARY KEY,
+    email text NOT NULL,
+    pu_password text NOT NULL
+);
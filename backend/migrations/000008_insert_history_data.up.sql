@@ -1,5 +0,0 @@
-INSERT INTO history(comments) 
-
-VALUES
-('First Comment Made'),
-('Second comment Made');
\ No newline at end of file
@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS form_form_status_idx;
+DROP INDEX IF EXISTS form_form_id_idx;
+DROP INDEX IF EXISTS form_user_id_idx;
+
+CREATE SEQUENCE IF NOT EXISTS form_user_id_seq OWNED BY form.user_id;
+SELECT setval('form_user_id_seq', COALESCE(MAX(user_id), 0) + 1, false) FROM form;
+ALTER TABLE form ALTER COLUMN user_id SET DEFAULT nextval('form_user_id_seq');
+
+-- only possible while every user has a single form
+ALTER TABLE form DROP COLUMN id;
+ALTER TABLE form ADD PRIMARY KEY (user_id);
+
+ALTER TABLE archive ADD CONSTRAINT archive_user_id_fkey FOREIGN KEY (user_id) REFERENCES form (user_id);
@@ -0,0 +1,14 @@
+-- archive pointed at form's primary key, it is pointed at the applicant instead in 000014
+ALTER TABLE archive DROP CONSTRAINT IF EXISTS archive_user_id_fkey;
+
+-- a user can file many forms, so forms get a surrogate key instead of the user's id
+ALTER TABLE form DROP CONSTRAINT form_pkey;
+ALTER TABLE form ADD COLUMN id bigserial PRIMARY KEY;
+
+-- user_id only references the applicant now, it doesn't need a sequence of its own
+ALTER TABLE form ALTER COLUMN user_id DROP DEFAULT;
+DROP SEQUENCE IF EXISTS form_user_id_seq;
+
+CREATE INDEX IF NOT EXISTS form_user_id_idx ON form (user_id);
+CREATE INDEX IF NOT EXISTS form_form_id_idx ON form (form_id);
+CREATE INDEX IF NOT EXISTS form_form_status_idx ON form (form_status);
@@ -0,0 +1,22 @@
+DROP INDEX IF EXISTS history_admin_id_idx;
+DROP INDEX IF EXISTS history_form_id_idx;
+ALTER TABLE history DROP CONSTRAINT IF EXISTS history_form_id_fkey;
+
+-- back to form numbers, entries without a form get a fresh number the way the old serial gave them one
+ALTER TABLE history ALTER COLUMN form_id TYPE integer;
+UPDATE history h SET form_id = f.form_id FROM form f WHERE f.id = h.form_id;
+UPDATE history h SET form_id = u.form_number FROM history_unmatched_forms u WHERE u.history_id = h.id AND h.form_id IS NULL;
+DROP TABLE IF EXISTS history_unmatched_forms;
+CREATE SEQUENCE IF NOT EXISTS history_form_id_seq OWNED BY history.form_id;
+SELECT setval('history_form_id_seq', COALESCE(MAX(form_id), 0) + 1, false) FROM history;
+ALTER TABLE history ALTER COLUMN form_id SET DEFAULT nextval('history_form_id_seq');
+UPDATE history SET form_id = nextval('history_form_id_seq') WHERE form_id IS NULL;
+ALTER TABLE history ALTER COLUMN form_id SET NOT NULL;
+
+CREATE SEQUENCE IF NOT EXISTS history_admin_id_seq OWNED BY history.admin_id;
+SELECT setval('history_admin_id_seq', COALESCE(MAX(admin_id), 0) + 1, false) FROM history;
+ALTER TABLE history ALTER COLUMN admin_id SET DEFAULT nextval('history_admin_id_seq');
+
+-- only possible while every admin has a single entry
+ALTER TABLE history DROP COLUMN id;
+ALTER TABLE history ADD PRIMARY KEY (admin_id);
@@ -0,0 +1,33 @@
+-- an admin writes many history entries, so history gets a surrogate key instead of the admin's id
+ALTER TABLE history DROP CONSTRAINT history_pkey;
+ALTER TABLE history ADD COLUMN id bigserial PRIMARY KEY;
+ALTER TABLE history ALTER COLUMN admin_id DROP DEFAULT;
+DROP SEQUENCE IF EXISTS history_admin_id_seq;
+
+-- form_id held a form number, it now references the form's surrogate key
+-- entries whose form number matches no form can't satisfy the foreign key, their form number is kept
+-- in history_unmatched_forms so the link can be repaired by hand
+ALTER TABLE history ALTER COLUMN form_id DROP DEFAULT;
+DROP SEQUENCE IF EXISTS history_form_id_seq;
+ALTER TABLE history ALTER COLUMN form_id DROP NOT NULL;
+ALTER TABLE history ALTER COLUMN form_id TYPE bigint;
+CREATE TABLE IF NOT EXISTS history_unmatched_forms (
+    history_id bigint PRIMARY KEY REFERENCES history (id) ON DELETE CASCADE,
+    form_number bigint NOT NULL
+);
+INSERT INTO history_unmatched_forms (history_id, form_number)
+SELECT h.id, h.form_id
+FROM history h
+WHERE h.form_id IS NOT NULL
+AND NOT EXISTS (SELECT 1 FROM form f WHERE f.form_id = h.form_id);
+UPDATE history SET form_id = (
+    SELECT f.id
+    FROM form f
+    WHERE f.form_id = history.form_id
+    ORDER BY f.id
+    LIMIT 1
+);
+ALTER TABLE history ADD CONSTRAINT history_form_id_fkey FOREIGN KEY (form_id) REFERENCES form (id);
+
+CREATE INDEX IF NOT EXISTS history_form_id_idx ON history (form_id);
+CREATE INDEX IF NOT EXISTS history_admin_id_idx ON history (admin_id);
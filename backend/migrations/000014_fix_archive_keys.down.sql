@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS archive_form_id_idx;
+DROP INDEX IF EXISTS archive_user_id_idx;
+ALTER TABLE archive DROP CONSTRAINT IF EXISTS archive_user_id_fkey;
+
+CREATE SEQUENCE IF NOT EXISTS archive_user_id_seq OWNED BY archive.user_id;
+SELECT setval('archive_user_id_seq', COALESCE(MAX(user_id), 0) + 1, false) FROM archive;
+ALTER TABLE archive ALTER COLUMN user_id SET DEFAULT nextval('archive_user_id_seq');
+
+-- only possible while every user has a single archived form
+ALTER TABLE archive DROP COLUMN id;
+ALTER TABLE archive ADD PRIMARY KEY (user_id);
@@ -0,0 +1,9 @@
+-- archived forms are snapshots, they get a key of their own and point at the applicant
+ALTER TABLE archive DROP CONSTRAINT archive_pkey;
+ALTER TABLE archive ADD COLUMN id bigserial PRIMARY KEY;
+ALTER TABLE archive ALTER COLUMN user_id DROP DEFAULT;
+DROP SEQUENCE IF EXISTS archive_user_id_seq;
+ALTER TABLE archive ADD CONSTRAINT archive_user_id_fkey FOREIGN KEY (user_id) REFERENCES public_user (id);
+
+CREATE INDEX IF NOT EXISTS archive_user_id_idx ON archive (user_id);
+CREATE INDEX IF NOT EXISTS archive_form_id_idx ON archive (form_id);
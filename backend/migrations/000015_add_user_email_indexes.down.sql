@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public_user_email_idx;
+DROP INDEX IF EXISTS admin_users_email_idx;
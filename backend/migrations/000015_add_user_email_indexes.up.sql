@@ -0,0 +1,3 @@
+-- accounts are looked up by email when logging in, and an email may only belong to one account
+CREATE UNIQUE INDEX IF NOT EXISTS admin_users_email_idx ON admin_users (lower(email));
+CREATE UNIQUE INDEX IF NOT EXISTS public_user_email_idx ON public_user (lower(email));
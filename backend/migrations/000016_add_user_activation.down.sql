@@ -0,0 +1,5 @@
+ALTER TABLE public_user DROP COLUMN IF EXISTS created_at;
+ALTER TABLE public_user DROP COLUMN IF EXISTS version;
+ALTER TABLE public_user DROP COLUMN IF EXISTS activated;
+ALTER TABLE admin_users DROP COLUMN IF EXISTS version;
+ALTER TABLE admin_users DROP COLUMN IF EXISTS activated;
@@ -0,0 +1,10 @@
+-- accounts must be activated before they can use the api, and carry a version for optimistic locking
+ALTER TABLE admin_users ADD COLUMN IF NOT EXISTS activated boolean NOT NULL DEFAULT false;
+ALTER TABLE admin_users ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE public_user ADD COLUMN IF NOT EXISTS activated boolean NOT NULL DEFAULT false;
+ALTER TABLE public_user ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE public_user ADD COLUMN IF NOT EXISTS created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+-- the accounts created before activation existed are already in use
+UPDATE admin_users SET activated = true;
+UPDATE public_user SET activated = true;
@@ -0,0 +1,12 @@
+-- a token belongs to either a staff account or an applicant's account
+CREATE TABLE IF NOT EXISTS tokens (
+    hash bytea PRIMARY KEY,
+    admin_id integer REFERENCES admin_users (id) ON DELETE CASCADE,
+    public_user_id integer REFERENCES public_user (id) ON DELETE CASCADE,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    scope text NOT NULL,
+    CONSTRAINT tokens_owner_check CHECK ((admin_id IS NULL) <> (public_user_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS tokens_admin_id_idx ON tokens (admin_id);
+CREATE INDEX IF NOT EXISTS tokens_public_user_id_idx ON tokens (public_user_id);
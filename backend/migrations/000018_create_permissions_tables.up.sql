@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE
+);
+
+-- only staff accounts are granted permissions
+CREATE TABLE IF NOT EXISTS admin_users_permissions (
+    admin_id integer NOT NULL REFERENCES admin_users (id) ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+    PRIMARY KEY (admin_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+('logs:read'),
+('logs:write');
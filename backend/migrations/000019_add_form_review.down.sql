@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code IN ('forms:read', 'forms:verify');
+ALTER TABLE form DROP COLUMN IF EXISTS version;
@@ -0,0 +1,8 @@
+-- reviews update forms concurrently, the version catches edits made on a stale copy
+ALTER TABLE form ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+INSERT INTO permissions (code)
+VALUES
+('forms:read'),
+('forms:verify')
+ON CONFLICT (code) DO NOTHING;
@@ -0,0 +1,3 @@
+ALTER TABLE public_user DROP CONSTRAINT IF EXISTS public_user_language_check;
+ALTER TABLE public_user DROP COLUMN IF EXISTS language;
+ALTER TABLE public_user DROP COLUMN IF EXISTS form_status_emails;
@@ -0,0 +1,4 @@
+-- applicants hear about their forms unless they opt out, in the language they choose
+ALTER TABLE public_user ADD COLUMN IF NOT EXISTS form_status_emails boolean NOT NULL DEFAULT true;
+ALTER TABLE public_user ADD COLUMN IF NOT EXISTS language text NOT NULL DEFAULT 'en';
+ALTER TABLE public_user ADD CONSTRAINT public_user_language_check CHECK (language IN ('en', 'es'));
@@ -0,0 +1,15 @@
+-- every notification we tried to send, so the office can answer "did I get an email?"
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigserial PRIMARY KEY,
+    public_user_id integer NOT NULL REFERENCES public_user (id) ON DELETE CASCADE,
+    form_id bigint REFERENCES form (id) ON DELETE SET NULL,
+    kind text NOT NULL,
+    template text NOT NULL,
+    recipient text NOT NULL,
+    status text NOT NULL,
+    error text,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS notifications_public_user_id_idx ON notifications (public_user_id);
+CREATE INDEX IF NOT EXISTS notifications_form_id_idx ON notifications (form_id);
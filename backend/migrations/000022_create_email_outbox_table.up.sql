@@ -0,0 +1,27 @@
+-- emails are queued here in the same transaction as the change that triggers them,
+-- the api's outbox workers send them and retry with backoff until they are sent or dead-lettered
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id bigserial PRIMARY KEY,
+    recipient text NOT NULL,
+    template text NOT NULL,
+    data jsonb NOT NULL DEFAULT '{}',
+    notification_id bigint REFERENCES notifications (id) ON DELETE SET NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_error text,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP(0) WITH TIME ZONE,
+    CONSTRAINT email_outbox_status_check CHECK (status IN ('pending', 'sent', 'dead'))
+);
+
+-- the workers only ever look for pending emails which are due
+CREATE INDEX IF NOT EXISTS email_outbox_due_idx ON email_outbox (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS email_outbox_status_idx ON email_outbox (status, id);
+CREATE INDEX IF NOT EXISTS email_outbox_notification_id_idx ON email_outbox (notification_id);
+
+INSERT INTO permissions (code)
+VALUES
+('emails:read'),
+('emails:write')
+ON CONFLICT (code) DO NOTHING;
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'jobs:read';
+DROP INDEX IF EXISTS tokens_expiry_idx;
@@ -0,0 +1,7 @@
+-- the purge-expired-tokens job looks tokens up by expiry
+CREATE INDEX IF NOT EXISTS tokens_expiry_idx ON tokens (expiry);
+
+INSERT INTO permissions (code)
+VALUES
+('jobs:read')
+ON CONFLICT (code) DO NOTHING;
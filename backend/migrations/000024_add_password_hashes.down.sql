@@ -0,0 +1,2 @@
+ALTER TABLE public_user DROP COLUMN IF EXISTS password_hash;
+ALTER TABLE admin_users DROP COLUMN IF EXISTS password_hash;
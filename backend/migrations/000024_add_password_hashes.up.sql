@@ -0,0 +1,4 @@
+-- passwords are stored as bcrypt hashes from now on, au_password and pu_password only
+-- hold the passwords of accounts which haven't set a new one yet and are emptied once they do
+ALTER TABLE admin_users ADD COLUMN IF NOT EXISTS password_hash bytea;
+ALTER TABLE public_user ADD COLUMN IF NOT EXISTS password_hash bytea;
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'users:unlock';
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS login_throttle;
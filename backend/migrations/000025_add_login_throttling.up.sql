@@ -0,0 +1,32 @@
+-- failed logins are counted per account (by kind and email, so unknown emails are throttled too)
+-- and per client ip, keys look like "account:public:someone@example.com" and "ip:203.0.113.7"
+CREATE TABLE IF NOT EXISTS login_throttle (
+    key text PRIMARY KEY,
+    failures integer NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP(0) WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS login_throttle_locked_until_idx ON login_throttle (locked_until) WHERE locked_until IS NOT NULL;
+
+-- security relevant events such as lockouts, about an account and optionally done by an admin
+CREATE TABLE IF NOT EXISTS audit_events (
+    id bigserial PRIMARY KEY,
+    event text NOT NULL,
+    admin_id integer REFERENCES admin_users (id) ON DELETE SET NULL,
+    public_user_id integer REFERENCES public_user (id) ON DELETE SET NULL,
+    actor_admin_id integer REFERENCES admin_users (id) ON DELETE SET NULL,
+    ip text,
+    details jsonb NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT audit_events_subject_check CHECK (admin_id IS NULL OR public_user_id IS NULL)
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_event_idx ON audit_events (event, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_admin_id_idx ON audit_events (admin_id);
+CREATE INDEX IF NOT EXISTS audit_events_public_user_id_idx ON audit_events (public_user_id);
+
+INSERT INTO permissions (code)
+VALUES
+('users:unlock')
+ON CONFLICT (code) DO NOTHING;
@@ -0,0 +1,19 @@
+-- an admin's TOTP secret, confirmed_at is set once a code from their authenticator app has been checked
+-- last_used_step is the time step of the last code accepted, so a code can't be used twice
+CREATE TABLE IF NOT EXISTS admin_totp (
+    admin_id integer PRIMARY KEY REFERENCES admin_users (id) ON DELETE CASCADE,
+    secret bytea NOT NULL,
+    confirmed_at TIMESTAMP(0) WITH TIME ZONE,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- one-time recovery codes for when the authenticator app is lost, only their hashes are kept
+CREATE TABLE IF NOT EXISTS admin_recovery_codes (
+    id bigserial PRIMARY KEY,
+    admin_id integer NOT NULL REFERENCES admin_users (id) ON DELETE CASCADE,
+    hash bytea NOT NULL,
+    used_at TIMESTAMP(0) WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS admin_recovery_codes_hash_idx ON admin_recovery_codes (admin_id, hash);
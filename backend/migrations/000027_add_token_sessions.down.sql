@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE code IN ('sessions:read', 'sessions:write');
+DROP INDEX IF EXISTS tokens_id_idx;
+ALTER TABLE tokens DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE tokens DROP COLUMN IF EXISTS ip;
+ALTER TABLE tokens DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS created_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS id;
@@ -0,0 +1,15 @@
+-- authentication tokens are the users' sessions, the id lets them be listed and revoked without the hash
+-- ever leaving the database, last_used_at and ip are written in batches so they can lag a little
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS id bigserial;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW();
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS ip text;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS user_agent text;
+
+CREATE UNIQUE INDEX IF NOT EXISTS tokens_id_idx ON tokens (id);
+
+INSERT INTO permissions (code)
+VALUES
+('sessions:read'),
+('sessions:write')
+ON CONFLICT (code) DO NOTHING;
@@ -0,0 +1,7 @@
+-- refresh tokens of the jwt auth mode are rotated on every use, each one replaced is kept with used_at
+-- set until it expires so presenting it again can be caught, family ties together the tokens of one
+-- session and is the id of its first token
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family bigint;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
@@ -0,0 +1,19 @@
+-- staff accounts linked to the government directory, an account is found again by the provider's issuer
+-- and subject which never change, unlike the email
+CREATE TABLE IF NOT EXISTS admin_identities (
+    admin_id integer PRIMARY KEY REFERENCES admin_users (id) ON DELETE CASCADE,
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMP(0) WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS admin_identities_subject_idx ON admin_identities (issuer, subject);
+
+-- single sign-on logins waiting for the browser to come back from the provider, only the state's hash is kept
+CREATE TABLE IF NOT EXISTS sso_logins (
+    state_hash bytea PRIMARY KEY,
+    nonce text NOT NULL,
+    code_verifier text NOT NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1,35 @@
+-- keys the registry's partner agencies call the api with, they belong to no account, the prefix finds the key
+-- and only the secret's hash is kept, the previous hash stays valid for a grace period after a rotation
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    secret_hash bytea NOT NULL,
+    previous_secret_hash bytea,
+    previous_expiry TIMESTAMP(0) WITH TIME ZONE,
+    rate_limit_rps double precision NOT NULL,
+    rate_limit_burst integer NOT NULL,
+    created_by integer REFERENCES admin_users (id) ON DELETE SET NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP(0) WITH TIME ZONE,
+    rotated_at TIMESTAMP(0) WITH TIME ZONE,
+    revoked_at TIMESTAMP(0) WITH TIME ZONE,
+    last_used_at TIMESTAMP(0) WITH TIME ZONE,
+    last_used_ip text,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT api_keys_rate_limit_check CHECK (rate_limit_rps > 0 AND rate_limit_burst > 0)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS api_keys_prefix_idx ON api_keys (prefix);
+
+CREATE TABLE IF NOT EXISTS api_keys_permissions (
+    api_key_id bigint NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+    PRIMARY KEY (api_key_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+('api_keys:read'),
+('api_keys:write')
+ON CONFLICT (code) DO NOTHING;
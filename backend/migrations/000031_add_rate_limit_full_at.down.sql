@@ -0,0 +1,4 @@
+CREATE INDEX IF NOT EXISTS rate_limits_updated_at_idx ON rate_limits (updated_at);
+DROP INDEX IF EXISTS rate_limits_full_at_idx;
+
+ALTER TABLE rate_limits DROP COLUMN IF EXISTS full_at;
@@ -0,0 +1,4 @@
+ALTER TABLE rate_limits ADD COLUMN IF NOT EXISTS full_at TIMESTAMP(6) WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS rate_limits_full_at_idx ON rate_limits (full_at);
+DROP INDEX IF EXISTS rate_limits_updated_at_idx;
@@ -0,0 +1 @@
+-- the cleared tokens can't be put back, they had expired anyway
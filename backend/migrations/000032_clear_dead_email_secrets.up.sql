@@ -0,0 +1,2 @@
+-- dead-lettered emails no longer keep their password reset tokens, clear the ones already waiting for an admin
+UPDATE email_outbox SET data = data - 'passwordResetToken' WHERE status = 'dead';
@@ -0,0 +1,3 @@
+DELETE FROM sso_logins;
+ALTER TABLE sso_logins DROP COLUMN IF EXISTS verifier_hash;
+ALTER TABLE sso_logins ADD COLUMN IF NOT EXISTS code_verifier text NOT NULL;
@@ -0,0 +1,5 @@
+-- the PKCE verifier is handed to the client which started the login and only its hash is kept, so the state
+-- and code in the redirect are no use on their own. Logins in flight are dropped, they only last minutes.
+DELETE FROM sso_logins;
+ALTER TABLE sso_logins DROP COLUMN IF EXISTS code_verifier;
+ALTER TABLE sso_logins ADD COLUMN IF NOT EXISTS verifier_hash bytea NOT NULL;
@@ -0,0 +1,3 @@
+-- whether a second factor was checked when a session was started, staff logging in with the directory only
+-- meet the two-factor policy when the directory says it asked for one
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS mfa boolean NOT NULL DEFAULT false;
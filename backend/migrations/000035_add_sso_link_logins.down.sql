@@ -0,0 +1 @@
+ALTER TABLE sso_logins DROP COLUMN IF EXISTS link_admin_id;
@@ -0,0 +1,3 @@
+-- staff with an account from before single sign-on link it to the directory themselves, by starting a login
+-- while signed in, instead of it being matched by email
+ALTER TABLE sso_logins ADD COLUMN IF NOT EXISTS link_admin_id integer REFERENCES admin_users (id) ON DELETE CASCADE;
@@ -0,0 +1,6 @@
+INSERT INTO admin_users_permissions (admin_id, permission_id)
+SELECT admin_id, permission_id
+FROM admin_directory_permissions
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS admin_directory_permissions;
@@ -0,0 +1,17 @@
+-- the permissions staff hold through their directory groups, replaced on every single sign-on login,
+-- kept apart from the ones granted in BioAff so a login never takes those away
+CREATE TABLE IF NOT EXISTS admin_directory_permissions (
+    admin_id integer NOT NULL REFERENCES admin_users (id) ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+    PRIMARY KEY (admin_id, permission_id)
+);
+
+-- linked accounts had every permission replaced on each login, so what they hold came from their groups
+INSERT INTO admin_directory_permissions (admin_id, permission_id)
+SELECT ap.admin_id, ap.permission_id
+FROM admin_users_permissions ap
+WHERE ap.admin_id IN (SELECT admin_id FROM admin_identities)
+ON CONFLICT DO NOTHING;
+
+DELETE FROM admin_users_permissions
+WHERE admin_id IN (SELECT admin_id FROM admin_identities);
@@ -0,0 +1,9 @@
+-- emails caught mid-send go back in the queue, they may go out twice
+UPDATE email_outbox SET status = 'pending' WHERE status = 'sending';
+
+DROP INDEX IF EXISTS email_outbox_leased_until_idx;
+
+ALTER TABLE email_outbox DROP CONSTRAINT IF EXISTS email_outbox_status_check;
+ALTER TABLE email_outbox ADD CONSTRAINT email_outbox_status_check CHECK (status IN ('pending', 'sent', 'dead'));
+
+ALTER TABLE email_outbox DROP COLUMN IF EXISTS leased_until;
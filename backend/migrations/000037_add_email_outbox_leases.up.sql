@@ -0,0 +1,9 @@
+-- a worker marks an email sending with a lease before it talks to the mail server, so the send runs outside
+-- any transaction and an expired lease shows the worker died mid-send
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS leased_until TIMESTAMP(0) WITH TIME ZONE;
+
+ALTER TABLE email_outbox DROP CONSTRAINT IF EXISTS email_outbox_status_check;
+ALTER TABLE email_outbox ADD CONSTRAINT email_outbox_status_check CHECK (status IN ('pending', 'sending', 'sent', 'dead'));
+
+-- the workers look for expired leases alongside the due pending emails
+CREATE INDEX IF NOT EXISTS email_outbox_leased_until_idx ON email_outbox (leased_until) WHERE status = 'sending';
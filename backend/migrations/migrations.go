@@ -0,0 +1,28 @@
+// BIOAFF/backend/migrations/migrations.go
+
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// Schema holds the schema migrations, they run in every environment
+//
+//go:embed *.sql
+var Schema embed.FS
+
+// seed holds the sample data migrations
+//
+//go:embed seed/*.sql
+var seed embed.FS
+
+// Seed() - the sample data migrations, only ever loaded into development databases
+func Seed() fs.FS {
+	sub, err := fs.Sub(seed, "seed")
+	if err != nil {
+		//the directory is embedded above, so this can't happen
+		panic(err)
+	}
+	return sub
+}
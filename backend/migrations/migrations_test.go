@@ -0,0 +1,244 @@
+// BIOAFF/backend/migrations/migrations_test.go
+
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/jinzhu/gorm/backend/internal/migrate"
+	"github.com/jinzhu/gorm/backend/internal/testdb"
+	"github.com/jinzhu/gorm/backend/migrations"
+)
+
+func TestMain(m *testing.M) {
+	testdb.Main(m)
+}
+
+// newSchemaMigrator() - a migrator for the embedded schema migrations
+func newSchemaMigrator(t *testing.T, db *sql.DB) *migrate.Migrator {
+	t.Helper()
+
+	m, err := migrate.New(db, migrations.Schema, "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// countTables() - the tables in the test's schema, apart from the version table
+func countTables(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	var n int
+	query := `
+		SELECT count(*)
+		FROM information_schema.tables
+		WHERE table_schema = current_schema()
+		AND table_name <> 'schema_migrations'`
+	err := db.QueryRow(query).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSchemaRoundTrip(t *testing.T) {
+	db := testdb.Empty(t)
+	m := newSchemaMigrator(t, db)
+	ctx := context.Background()
+
+	all, err := migrate.Load(migrations.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//every migration is applied, reverted and applied again on its own,
+	//so a down file which doesn't undo its up file fails at its own step
+	for _, migration := range all {
+		for _, step := range []string{"up", "down", "up"} {
+			var err error
+			if step == "up" {
+				_, err = m.Up(ctx, 1)
+			} else {
+				_, err = m.Down(ctx, 1)
+			}
+			if err != nil {
+				t.Fatalf("%06d_%s %s: %s", migration.Version, migration.Name, step, err)
+			}
+		}
+	}
+
+	//and then everything comes back out
+	_, err = m.Down(ctx, len(all))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countTables(t, db); n != 0 {
+		t.Errorf("got %d tables left after migrating down, want 0", n)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != 0 || len(status.Pending) != len(all) {
+		t.Errorf("got version %d with %d pending, want version 0 with %d pending", status.Version, len(status.Pending), len(all))
+	}
+}
+
+func TestSeedRoundTrip(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+
+	seed, err := migrate.New(db, migrations.Seed(), "seed_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := seed.Up(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the sample history entries must be attached to the sample form
+	var orphans int
+	err = db.QueryRow(`SELECT count(*) FROM history WHERE form_id IS NULL`).Scan(&orphans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orphans != 0 {
+		t.Errorf("got %d history entries without a form, want 0", orphans)
+	}
+
+	_, err = seed.Down(ctx, len(applied))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestKeyFixesPreserveRows - the key fixes of 000012 to 000014 keep the rows written under the old schema
+func TestKeyFixesPreserveRows(t *testing.T) {
+	db := testdb.Empty(t)
+	m := newSchemaMigrator(t, db)
+	ctx := context.Background()
+
+	//000001 to 000011 is the schema before the fixes
+	_, err := m.Up(ctx, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the old history table keys on the admin, so each entry needs an admin of its own
+	statements := []string{
+		`INSERT INTO admin_users (id, email, au_password) VALUES (1, 'one@example.com', 'x'), (2, 'two@example.com', 'x')`,
+		`INSERT INTO public_user (id, email, pu_password) VALUES (7, 'applicant@example.com', 'x')`,
+		`INSERT INTO form (user_id, form_id, form_status, archive_status, affiant_full_name, social_security_num,
+			social_security_date, social_security_country, passport_number, passport_date, passport_country, dob,
+			place_of_birth, nationality, affiants_address, residencial_phone_number)
+		VALUES (7, 343434, 'verified', false, 'Affiant', 1, NOW(), 'Belize', 1, NOW(), 'Belize', NOW(),
+			'Corozal', 'Belizean', 'Somewhere', 1)`,
+		`INSERT INTO history (admin_id, form_id, comments) VALUES (1, 343434, 'matches the form'), (2, 999, 'no such form')`,
+		`INSERT INTO archive (user_id, form_id, form_status, archive_status, affiant_full_name, social_security_num,
+			social_security_date, social_security_country, passport_number, passport_date, passport_country, dob,
+			place_of_birth, nationality, affiants_address, residencial_phone_number)
+		VALUES (7, 343434, 'verified', true, 'Affiant', 1, NOW(), 'Belize', 1, NOW(), 'Belize', NOW(),
+			'Corozal', 'Belizean', 'Somewhere', 1)`,
+	}
+	for _, statement := range statements {
+		_, err := db.Exec(statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = m.Up(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var formID, userID int64
+	err = db.QueryRow(`SELECT id, user_id FROM form WHERE form_id = 343434`).Scan(&formID, &userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != 7 {
+		t.Errorf("got form user_id %d, want 7", userID)
+	}
+
+	var matched, orphaned sql.NullInt64
+	err = db.QueryRow(`SELECT form_id FROM history WHERE admin_id = 1`).Scan(&matched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matched.Valid || matched.Int64 != formID {
+		t.Errorf("got history form_id %v, want %d", matched, formID)
+	}
+	err = db.QueryRow(`SELECT form_id FROM history WHERE admin_id = 2`).Scan(&orphaned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orphaned.Valid {
+		t.Errorf("got history form_id %d for a missing form, want NULL", orphaned.Int64)
+	}
+	//but the form number it had is kept
+	var unmatched int64
+	err = db.QueryRow(`
+		SELECT u.form_number
+		FROM history_unmatched_forms u
+		INNER JOIN history h ON h.id = u.history_id
+		WHERE h.admin_id = 2`).Scan(&unmatched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unmatched != 999 {
+		t.Errorf("got unmatched form number %d, want 999", unmatched)
+	}
+
+	var archived int
+	err = db.QueryRow(`SELECT count(*) FROM archive WHERE user_id = 7`).Scan(&archived)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived != 1 {
+		t.Errorf("got %d archived forms, want 1", archived)
+	}
+
+	//a user can now file a second form
+	_, err = db.Exec(`
+		INSERT INTO form (user_id, form_id, form_status, archive_status, affiant_full_name, social_security_num,
+			social_security_date, social_security_country, passport_number, passport_date, passport_country, dob,
+			place_of_birth, nationality, affiants_address, residencial_phone_number)
+		SELECT user_id, 343435, form_status, archive_status, affiant_full_name, social_security_num,
+			social_security_date, social_security_country, passport_number, passport_date, passport_country, dob,
+			place_of_birth, nationality, affiants_address, residencial_phone_number
+		FROM form WHERE id = $1`, formID)
+	if err != nil {
+		t.Fatalf("inserting a second form for the same user: %s", err)
+	}
+	_, err = db.Exec(`DELETE FROM form WHERE form_id = 343435`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//going back puts the form number back into history
+	_, err = m.Down(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var formNumber int64
+	err = db.QueryRow(`SELECT form_id FROM history WHERE admin_id = 1`).Scan(&formNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formNumber != 343434 {
+		t.Errorf("got history form_id %d after migrating down, want 343434", formNumber)
+	}
+	err = db.QueryRow(`SELECT form_id FROM history WHERE admin_id = 2`).Scan(&formNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formNumber != 999 {
+		t.Errorf("got history form_id %d for the unmatched entry after migrating down, want 999", formNumber)
+	}
+}
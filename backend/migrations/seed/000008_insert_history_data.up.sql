@@ -0,0 +1,7 @@
+INSERT INTO history(admin_id,form_id,comments) 
+
+SELECT a.id, f.id, c.comments
+FROM admin_users a, form f, (VALUES
+('First Comment Made'),
+('Second comment Made')) AS c(comments)
+WHERE a.email = 'hipbau11@gmail.com' AND f.form_id = 343434;